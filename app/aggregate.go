@@ -0,0 +1,585 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Accumulator folds the arguments of one aggregate function over a group of rows
+type Accumulator interface {
+	Step(args []Value) error
+	Result() (Value, error)
+}
+
+// AggregateCall is one aggregate function call found while compiling a query
+type AggregateCall struct {
+	Name     string
+	Args     []*CompiledExpr
+	Distinct bool
+
+	newAccumulator func() Accumulator
+}
+
+// AggregateSet collects the aggregate calls of a query. Aggregate results are
+// appended to the input row, so rows produced by the aggregate operator hold the
+// input columns first and the result of call i at index base+i.
+type AggregateSet struct {
+	base  int
+	Calls []*AggregateCall
+}
+
+// NewAggregateSet creates an empty aggregate set for input rows with width columns
+func NewAggregateSet(width int) *AggregateSet {
+	return &AggregateSet{base: width}
+}
+
+// add registers a call and returns the row index its result is stored at
+func (s *AggregateSet) add(call *AggregateCall) int {
+	s.Calls = append(s.Calls, call)
+	return s.base + len(s.Calls) - 1
+}
+
+// rowSelector returns the index of the only min() or max() call, or -1. When a
+// query has exactly one of them, bare columns take their values from the row that
+// produced the minimum or maximum; otherwise, or while every value seen is NULL,
+// they come from the group's first row, as in SQLite.
+func (s *AggregateSet) rowSelector() int {
+	selector := -1
+	for i, call := range s.Calls {
+		if call.Name == "min" || call.Name == "max" {
+			if selector >= 0 {
+				return -1
+			}
+			selector = i
+		}
+	}
+	return selector
+}
+
+// isAggregateFunction reports whether a call to name with argCount arguments is an
+// aggregate; min and max with several arguments are the scalar functions
+func isAggregateFunction(name string, argCount int) bool {
+	switch name {
+	case "count", "sum", "total", "avg", "group_concat":
+		return true
+	case "min", "max":
+		return argCount == 1
+	default:
+		return false
+	}
+}
+
+// compileAggregate compiles an aggregate call; its arguments are plain expressions over the input row
+func (c *ExprCompiler) compileAggregate(name string, distinct bool, exprs sqlparser.SelectExprs) (*CompiledExpr, error) {
+	if c.aggregates == nil {
+		return nil, fmt.Errorf("misuse of aggregate function %s()", name)
+	}
+
+	call := &AggregateCall{Name: name, Distinct: distinct}
+	argCompiler := NewExprCompiler(c.schema)
+	for _, selectExpr := range exprs {
+		switch arg := selectExpr.(type) {
+		case *sqlparser.StarExpr:
+			if name != "count" || len(exprs) != 1 {
+				return nil, fmt.Errorf("wrong number of arguments to function %s()", name)
+			}
+		case *sqlparser.AliasedExpr:
+			compiled, err := argCompiler.Compile(arg.Expr)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, compiled)
+		default:
+			return nil, fmt.Errorf("unsupported argument to function %s(): %T", name, selectExpr)
+		}
+	}
+
+	var collation Collation
+	if len(call.Args) > 0 {
+		collation = call.Args[0].Collation
+	}
+	switch {
+	case name == "count" && len(call.Args) <= 1:
+		call.newAccumulator = func() Accumulator { return &countAccumulator{} }
+	case (name == "sum" || name == "total" || name == "avg") && len(call.Args) == 1:
+		call.newAccumulator = func() Accumulator { return &sumAccumulator{mode: name} }
+	case (name == "min" || name == "max") && len(call.Args) == 1:
+		call.newAccumulator = func() Accumulator { return &minMaxAccumulator{max: name == "max", collation: collation} }
+	case name == "group_concat" && (len(call.Args) == 1 || len(call.Args) == 2):
+		call.newAccumulator = func() Accumulator { return &groupConcatAccumulator{} }
+	default:
+		return nil, fmt.Errorf("wrong number of arguments to function %s()", name)
+	}
+	if distinct && len(call.Args) != 1 {
+		return nil, fmt.Errorf("DISTINCT aggregates must have exactly one argument")
+	}
+
+	index := c.aggregates.add(call)
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		return row.Get(index)
+	}}, nil
+}
+
+// newAccumulators creates fresh accumulators for every call in the set
+func (s *AggregateSet) newAccumulators() []Accumulator {
+	accumulators := make([]Accumulator, len(s.Calls))
+	for i, call := range s.Calls {
+		accumulators[i] = call.newAccumulator()
+		if call.Distinct {
+			accumulators[i] = &distinctAccumulator{
+				inner:     accumulators[i],
+				collation: call.Args[0].Collation,
+				seen:      make(map[string][]Value),
+			}
+		}
+	}
+	return accumulators
+}
+
+// aggregateGroup is the running state of the aggregates over one group of rows
+type aggregateGroup struct {
+	keys         []Value // GROUP BY key values of the group
+	row          *Row    // the row bare columns are taken from, nil for an empty group
+	accumulators []Accumulator
+}
+
+// step feeds one input row into the group's accumulators
+func (g *aggregateGroup) step(set *AggregateSet, selector int, row *Row) error {
+	args := make([]Value, 0, 2)
+	for i, call := range set.Calls {
+		args = args[:0]
+		for _, arg := range call.Args {
+			value, err := arg.Eval(row)
+			if err != nil {
+				return fmt.Errorf("error evaluating argument of %s(): %v", call.Name, err)
+			}
+			args = append(args, value)
+		}
+		if err := g.accumulators[i].Step(args); err != nil {
+			return err
+		}
+		if i == selector {
+			if g.accumulators[i].(*minMaxAccumulator).changed {
+				g.row = row
+			}
+		}
+	}
+	if g.row == nil {
+		g.row = row
+	}
+	return nil
+}
+
+// result builds the output row: the bare-column row followed by the aggregate results
+func (g *aggregateGroup) result(set *AggregateSet) (*Row, error) {
+	values := make([]Value, set.base+len(set.Calls))
+	for i := 0; i < set.base; i++ {
+		values[i] = NewNullValue()
+		if g.row != nil && i < len(g.row.Values) {
+			values[i] = g.row.Values[i]
+		}
+	}
+	for i, accumulator := range g.accumulators {
+		value, err := accumulator.Result()
+		if err != nil {
+			return nil, err
+		}
+		values[set.base+i] = value
+	}
+	return &Row{Values: values}, nil
+}
+
+// aggregateSource folds the whole input into a single row of aggregate results.
+// It works on any row source, whether it comes from a table scan or an index.
+func aggregateSource(source RowSource, set *AggregateSet) RowSource {
+	return func(yield func(*Row, error) bool) {
+		selector := set.rowSelector()
+		group := &aggregateGroup{accumulators: set.newAccumulators()}
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if err := group.step(set, selector, row); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		yield(group.result(set))
+	}
+}
+
+// groupSource evaluates the aggregates once per group of rows with equal GROUP BY
+// keys, producing one row per group in key order. When the input is known to arrive
+// in group order, for example from an index, groups are streamed one at a time;
+// otherwise they are collected in a hash table and sorted at the end.
+func groupSource(source RowSource, keys []*CompiledExpr, set *AggregateSet, inGroupOrder bool) RowSource {
+	if inGroupOrder {
+		return streamGroups(source, keys, set)
+	}
+	return hashGroups(source, keys, set)
+}
+
+// groupKeys evaluates the GROUP BY keys of a row
+func groupKeys(row *Row, keys []*CompiledExpr) ([]Value, error) {
+	values := make([]Value, len(keys))
+	for i, key := range keys {
+		var err error
+		if values[i], err = key.Eval(row); err != nil {
+			return nil, fmt.Errorf("error evaluating GROUP BY term %d: %v", i+1, err)
+		}
+	}
+	return values, nil
+}
+
+// compareGroupKeys orders key tuples using each key's collation; NULLs are equal to each other
+func compareGroupKeys(a, b []Value, keys []*CompiledExpr) int {
+	for i, key := range keys {
+		if c := CompareCollated(a[i], b[i], key.Collation); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// streamGroups aggregates input that arrives with each group's rows contiguous
+func streamGroups(source RowSource, keys []*CompiledExpr, set *AggregateSet) RowSource {
+	return func(yield func(*Row, error) bool) {
+		selector := set.rowSelector()
+		var group *aggregateGroup
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			values, err := groupKeys(row, keys)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if group != nil && compareGroupKeys(group.keys, values, keys) != 0 {
+				if !yield(group.result(set)) {
+					return
+				}
+				group = nil
+			}
+			if group == nil {
+				group = &aggregateGroup{keys: values, accumulators: set.newAccumulators()}
+			}
+			if err := group.step(set, selector, row); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		if group != nil {
+			yield(group.result(set))
+		}
+	}
+}
+
+// hashGroups aggregates input in any order, bucketing groups by a hash of their keys
+func hashGroups(source RowSource, keys []*CompiledExpr, set *AggregateSet) RowSource {
+	return func(yield func(*Row, error) bool) {
+		selector := set.rowSelector()
+		buckets := make(map[string][]*aggregateGroup)
+		var groups []*aggregateGroup
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			values, err := groupKeys(row, keys)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			var hash strings.Builder
+			for _, value := range values {
+				key := distinctKey(value)
+				fmt.Fprintf(&hash, "%d:%s", len(key), key)
+			}
+			var group *aggregateGroup
+			for _, candidate := range buckets[hash.String()] {
+				if compareGroupKeys(candidate.keys, values, keys) == 0 {
+					group = candidate
+					break
+				}
+			}
+			if group == nil {
+				group = &aggregateGroup{keys: values, accumulators: set.newAccumulators()}
+				buckets[hash.String()] = append(buckets[hash.String()], group)
+				groups = append(groups, group)
+			}
+			if err := group.step(set, selector, row); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		slices.SortFunc(groups, func(a, b *aggregateGroup) int {
+			return compareGroupKeys(a.keys, b.keys, keys)
+		})
+		for _, group := range groups {
+			if !yield(group.result(set)) {
+				return
+			}
+		}
+	}
+}
+
+// countAccumulator implements count(*) and count(x), which skips NULLs
+type countAccumulator struct {
+	count int64
+}
+
+func (a *countAccumulator) Step(args []Value) error {
+	if len(args) == 0 || StorageClassOf(args[0]) != StorageClassNull {
+		a.count++
+	}
+	return nil
+}
+
+func (a *countAccumulator) Result() (Value, error) {
+	return NewIntegerValue(a.count), nil
+}
+
+// sumAccumulator implements sum(), total() and avg(). Integers are summed exactly
+// until a non-integer is seen or the sum overflows; from then on the sum is REAL and
+// kept with Kahan-Babuska-Neumaier compensation, as SQLite does. sum() reports an
+// error if the exact sum overflowed and no REAL value was added after that.
+type sumAccumulator struct {
+	mode     string // "sum", "total" or "avg"
+	count    int64
+	intSum   int64
+	realSum  float64
+	realErr  float64 // the low-order part of the REAL sum that realSum lost to rounding
+	approx   bool    // the result is REAL
+	overflow bool
+}
+
+func (a *sumAccumulator) Step(args []Value) error {
+	value := args[0]
+	if StorageClassOf(value) == StorageClassNull {
+		return nil
+	}
+	a.count++
+
+	value = ApplyAffinity(value, AffinityNumeric)
+	if StorageClassOf(value) == StorageClassInteger {
+		i, _ := value.Int64()
+		if a.approx {
+			a.addInteger(i)
+			return nil
+		}
+		sum := a.intSum + i
+		if (i > 0 && sum < a.intSum) || (i < 0 && sum > a.intSum) {
+			a.overflow = true
+			a.startReal()
+			a.addInteger(i)
+			return nil
+		}
+		a.intSum = sum
+		return nil
+	}
+
+	var f float64
+	if StorageClassOf(value) == StorageClassReal {
+		f, _ = value.Float64()
+	} else {
+		f, _ = numericPrefix(value.String()).Float64()
+	}
+	if !a.approx {
+		a.startReal()
+	} else {
+		a.overflow = false
+	}
+	a.addReal(f)
+	return nil
+}
+
+// startReal carries the exact integer sum over into the REAL sum, splitting off
+// the low bits of an integer too large for a float64 to hold exactly
+func (a *sumAccumulator) startReal() {
+	a.approx = true
+	a.realSum, a.realErr = float64(a.intSum), 0
+	if a.intSum <= -1<<52 || a.intSum >= 1<<52 {
+		small := a.intSum % 16384
+		a.realSum, a.realErr = float64(a.intSum-small), float64(small)
+	}
+}
+
+// addInteger adds an integer to the REAL sum, in two parts when it is too large
+// for a float64 to hold exactly
+func (a *sumAccumulator) addInteger(i int64) {
+	if i <= -1<<52 || i >= 1<<52 {
+		small := i % 16384
+		a.addReal(float64(i - small))
+		a.addReal(float64(small))
+		return
+	}
+	a.addReal(float64(i))
+}
+
+// addReal adds f to the REAL sum, collecting the rounding error of the addition
+func (a *sumAccumulator) addReal(f float64) {
+	t := a.realSum + f
+	if math.Abs(a.realSum) > math.Abs(f) {
+		a.realErr += (a.realSum - t) + f
+	} else {
+		a.realErr += (f - t) + a.realSum
+	}
+	a.realSum = t
+}
+
+// total returns the sum as a float64, with the rounding error added back
+func (a *sumAccumulator) total() float64 {
+	switch {
+	case !a.approx:
+		return float64(a.intSum)
+	case math.IsInf(a.realSum, 0):
+		return a.realSum
+	}
+	return a.realSum + a.realErr
+}
+
+func (a *sumAccumulator) Result() (Value, error) {
+	switch a.mode {
+	case "total":
+		return NewRealValue(a.total()), nil
+	case "avg":
+		if a.count == 0 {
+			return NewNullValue(), nil
+		}
+		return NewRealValue(a.total() / float64(a.count)), nil
+	}
+
+	if a.count == 0 {
+		return NewNullValue(), nil
+	}
+	if a.overflow {
+		return nil, fmt.Errorf("integer overflow")
+	}
+	if a.approx {
+		return NewRealValue(a.total()), nil
+	}
+	return NewIntegerValue(a.intSum), nil
+}
+
+// minMaxAccumulator implements the min() and max() aggregates, which ignore NULLs
+type minMaxAccumulator struct {
+	max       bool
+	collation Collation
+	best      Value
+	changed   bool // whether the last step replaced the current best value
+}
+
+func (a *minMaxAccumulator) Step(args []Value) error {
+	a.changed = false
+	value := args[0]
+	if StorageClassOf(value) == StorageClassNull {
+		return nil
+	}
+	if a.best != nil {
+		c := CompareCollated(value, a.best, a.collation)
+		if (a.max && c <= 0) || (!a.max && c >= 0) {
+			return nil
+		}
+	}
+	a.best = value
+	a.changed = true
+	return nil
+}
+
+func (a *minMaxAccumulator) Result() (Value, error) {
+	if a.best == nil {
+		return NewNullValue(), nil
+	}
+	return a.best, nil
+}
+
+// groupConcatAccumulator implements group_concat(x) and group_concat(x, separator)
+type groupConcatAccumulator struct {
+	result   strings.Builder
+	nonEmpty bool
+}
+
+func (a *groupConcatAccumulator) Step(args []Value) error {
+	if StorageClassOf(args[0]) == StorageClassNull {
+		return nil
+	}
+	if a.nonEmpty {
+		separator := ","
+		if len(args) > 1 {
+			separator = ""
+			if StorageClassOf(args[1]) != StorageClassNull {
+				separator = args[1].String()
+			}
+		}
+		a.result.WriteString(separator)
+	}
+	a.result.WriteString(args[0].String())
+	a.nonEmpty = true
+	return nil
+}
+
+func (a *groupConcatAccumulator) Result() (Value, error) {
+	if !a.nonEmpty {
+		return NewNullValue(), nil
+	}
+	return NewTextValue(a.result.String()), nil
+}
+
+// distinctAccumulator passes each distinct non-NULL argument to inner only once.
+// Values are bucketed by a coarse key and compared with the argument's collation.
+type distinctAccumulator struct {
+	inner     Accumulator
+	collation Collation
+	seen      map[string][]Value
+}
+
+func (a *distinctAccumulator) Step(args []Value) error {
+	value := args[0]
+	if StorageClassOf(value) == StorageClassNull {
+		return a.inner.Step(args)
+	}
+	key := distinctKey(value)
+	for _, seen := range a.seen[key] {
+		if CompareCollated(seen, value, a.collation) == 0 {
+			return nil
+		}
+	}
+	a.seen[key] = append(a.seen[key], value)
+	return a.inner.Step(args)
+}
+
+func (a *distinctAccumulator) Result() (Value, error) {
+	return a.inner.Result()
+}
+
+// distinctKey maps values that may compare equal to the same key. Integers and
+// integral reals share a key, and text is folded so that every built-in collation
+// only considers values with the same key equal.
+func distinctKey(value Value) string {
+	switch StorageClassOf(value) {
+	case StorageClassNull:
+		return "z"
+	case StorageClassInteger:
+		i, _ := value.Int64()
+		return fmt.Sprintf("n%d", i)
+	case StorageClassReal:
+		f, _ := value.Float64()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return fmt.Sprintf("n%d", int64(f))
+		}
+		return fmt.Sprintf("r%v", f)
+	case StorageClassText:
+		return "t" + strings.ToLower(strings.TrimRight(value.String(), " "))
+	default:
+		return "b" + string(value.Raw())
+	}
+}
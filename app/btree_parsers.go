@@ -1,15 +1,134 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 )
 
+// OverflowReader assembles cell payloads that spill onto overflow pages
+type OverflowReader struct {
+	dbRaw DatabaseRaw
+}
+
+// NewOverflowReader creates a new overflow reader
+func NewOverflowReader(dbRaw DatabaseRaw) *OverflowReader {
+	return &OverflowReader{dbRaw: dbRaw}
+}
+
+// ReadPayload returns the full payload of a cell whose local content starts at offset.
+// maxLocal is the X threshold of the page type: U-35 for table leaves and
+// ((U-12)*64/255)-23 for index pages. The returned overflow page is 0 when the
+// payload is stored entirely on the page.
+func (r *OverflowReader) ReadPayload(ctx context.Context, pageData []byte, offset int, payloadSize uint64, maxLocal int) ([]byte, uint32, error) {
+	usableSize := r.dbRaw.GetUsableSize()
+	localSize := localPayloadSize(int(payloadSize), usableSize, maxLocal)
+
+	if offset+localSize > len(pageData) {
+		return nil, 0, fmt.Errorf("payload extends beyond page boundary")
+	}
+	if uint64(localSize) == payloadSize {
+		return pageData[offset : offset+localSize], 0, nil
+	}
+
+	if offset+localSize+4 > len(pageData) {
+		return nil, 0, fmt.Errorf("overflow page pointer extends beyond page boundary")
+	}
+	firstOverflow := binary.BigEndian.Uint32(pageData[offset+localSize:])
+
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, pageData[offset:offset+localSize]...)
+
+	// Each overflow page holds a 4-byte next-page pointer followed by U-4 bytes of content
+	remaining := int(payloadSize) - localSize
+	maxPages := remaining/(usableSize-4) + 1
+	nextPage := firstOverflow
+	for pages := 0; remaining > 0; pages++ {
+		if nextPage == 0 || pages >= maxPages {
+			return nil, 0, NewDatabaseError("read_overflow_chain", ErrInvalidDatabase, map[string]interface{}{
+				"first_overflow_page": firstOverflow,
+				"missing_bytes":       remaining,
+			})
+		}
+
+		overflowData, err := r.dbRaw.ReadPage(ctx, int(nextPage))
+		if err != nil {
+			return nil, 0, fmt.Errorf("read overflow page %d: %w", nextPage, err)
+		}
+
+		chunk := usableSize - 4
+		if chunk > remaining {
+			chunk = remaining
+		}
+		payload = append(payload, overflowData[4:4+chunk]...)
+		remaining -= chunk
+		nextPage = binary.BigEndian.Uint32(overflowData[0:4])
+	}
+
+	return payload, firstOverflow, nil
+}
+
+// localPayloadSize computes how many payload bytes are stored on the b-tree page itself,
+// following the U/X/M/K rules from the SQLite file format specification
+func localPayloadSize(payloadSize, usableSize, maxLocal int) int {
+	if payloadSize <= maxLocal {
+		return payloadSize
+	}
+
+	minLocal := ((usableSize-12)*32/255 - 23)
+	k := minLocal + (payloadSize-minLocal)%(usableSize-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// tableLeafMaxLocal returns the X threshold for table b-tree leaf cells
+func tableLeafMaxLocal(usableSize int) int {
+	return usableSize - 35
+}
+
+// indexMaxLocal returns the X threshold for index b-tree cells (leaf and interior)
+func indexMaxLocal(usableSize int) int {
+	return (usableSize-12)*64/255 - 23
+}
+
+// parseRecord parses a record header and body from an assembled payload. TEXT
+// values stored in UTF-16 are decoded to UTF-8, with their serial types adjusted
+// to the new length.
+func parseRecord(payload []byte, encoding TextEncoding) (Record, error) {
+	header, headerOffset := readRecordHeader(payload, 0)
+	body, _, err := readRecordBody(payload, headerOffset, header)
+	if err != nil {
+		return Record{}, err
+	}
+	if encoding.isUTF16() {
+		bodyIndex := 0
+		for i, serialType := range header.SerialTypes {
+			if getSerialTypeSize(serialType) == 0 {
+				continue
+			}
+			if serialType >= 13 && serialType%2 == 1 {
+				text := encoding.Decode(body.Values[bodyIndex].([]byte))
+				body.Values[bodyIndex] = text
+				header.SerialTypes[i] = uint64(13 + 2*len(text))
+			}
+			bodyIndex++
+		}
+	}
+	return Record{
+		RecordHeader: header,
+		RecordBody:   body,
+	}, nil
+}
+
 // TableBTreeParser implements BTreeCellParser for table B-trees
-type TableBTreeParser struct{}
+type TableBTreeParser struct {
+	overflow *OverflowReader
+}
 
 // ParseLeafCell parses a leaf table cell
-func (p *TableBTreeParser) ParseLeafCell(pageData []byte, offset int) (*Cell, error) {
+func (p *TableBTreeParser) ParseLeafCell(ctx context.Context, pageData []byte, offset int) (*Cell, error) {
 	if offset >= len(pageData) {
 		return nil, fmt.Errorf("cell offset %d exceeds page size", offset)
 	}
@@ -22,31 +141,29 @@ func (p *TableBTreeParser) ParseLeafCell(pageData []byte, offset int) (*Cell, er
 	rowID, bytesRead := readVarint(pageData, offset)
 	offset += bytesRead
 
-	// Read payload
-	if offset+int(payloadSize) > len(pageData) {
-		return nil, fmt.Errorf("payload extends beyond page boundary")
+	// Read payload, following the overflow chain if needed
+	maxLocal := tableLeafMaxLocal(p.overflow.dbRaw.GetUsableSize())
+	payload, overflowPage, err := p.overflow.ReadPayload(ctx, pageData, offset, payloadSize, maxLocal)
+	if err != nil {
+		return nil, err
 	}
-	payload := pageData[offset : offset+int(payloadSize)]
 
 	// Parse record from payload
-	header, headerOffset := readRecordHeader(payload, 0)
-	body, _, err := readRecordBody(payload, headerOffset, header)
+	record, err := parseRecord(payload, TextEncoding(p.overflow.dbRaw.GetHeader().TextEncoding))
 	if err != nil {
 		return nil, err
 	}
 
 	return &Cell{
-		PayloadSize: payloadSize,
-		Rowid:       rowID,
-		Record: Record{
-			RecordHeader: header,
-			RecordBody:   body,
-		},
+		PayloadSize:  payloadSize,
+		Rowid:        rowID,
+		Record:       record,
+		OverflowPage: overflowPage,
 	}, nil
 }
 
 // ParseInteriorCell parses an interior table cell
-func (p *TableBTreeParser) ParseInteriorCell(pageData []byte, offset int) (uint32, BTreeKey, error) {
+func (p *TableBTreeParser) ParseInteriorCell(ctx context.Context, pageData []byte, offset int) (uint32, BTreeKey, error) {
 	if offset+4 > len(pageData) {
 		return 0, nil, fmt.Errorf("interior cell offset %d exceeds page size %d", offset, len(pageData))
 	}
@@ -66,20 +183,13 @@ func (p *TableBTreeParser) ExtractSearchKey(cell *Cell) BTreeKey {
 	return cell.Rowid
 }
 
-// MatchesSearchKey checks if a cell matches the search key
-func (p *TableBTreeParser) MatchesSearchKey(cell *Cell, searchKey BTreeKey) bool {
-	searchRowid, ok := searchKey.(uint64)
-	if !ok {
-		return false
-	}
-	return cell.Rowid == searchRowid
-}
-
 // IndexBTreeParser implements BTreeCellParser for index B-trees
-type IndexBTreeParser struct{}
+type IndexBTreeParser struct {
+	overflow *OverflowReader
+}
 
 // ParseLeafCell parses a leaf index cell
-func (p *IndexBTreeParser) ParseLeafCell(pageData []byte, offset int) (*Cell, error) {
+func (p *IndexBTreeParser) ParseLeafCell(ctx context.Context, pageData []byte, offset int) (*Cell, error) {
 	if offset >= len(pageData) {
 		return nil, fmt.Errorf("cell offset %d exceeds page size", offset)
 	}
@@ -88,42 +198,36 @@ func (p *IndexBTreeParser) ParseLeafCell(pageData []byte, offset int) (*Cell, er
 	payloadSize, bytesRead := readVarint(pageData, offset)
 	offset += bytesRead
 
-	if offset+int(payloadSize) > len(pageData) {
-		return nil, fmt.Errorf("payload extends beyond page boundary")
+	maxLocal := indexMaxLocal(p.overflow.dbRaw.GetUsableSize())
+	payload, overflowPage, err := p.overflow.ReadPayload(ctx, pageData, offset, payloadSize, maxLocal)
+	if err != nil {
+		return nil, err
 	}
-	payload := pageData[offset : offset+int(payloadSize)]
 
 	// Parse record from payload
-	header, headerOffset := readRecordHeader(payload, 0)
-	body, _, err := readRecordBody(payload, headerOffset, header)
+	record, err := parseRecord(payload, TextEncoding(p.overflow.dbRaw.GetHeader().TextEncoding))
 	if err != nil {
 		return nil, err
 	}
 
-	// For index cells, the last value in the record is typically the rowid
+	// For index cells, the last column of the record is the rowid
 	var rowid uint64
-	if len(body.Values) > 0 {
-		// Try to extract rowid from the last value
-		lastValue := body.Values[len(body.Values)-1]
-		if bytes, ok := lastValue.([]byte); ok {
-			rowid = BytesToInteger(bytes)
+	if values := record.ColumnValues(); len(values) > 0 {
+		if id, err := values[len(values)-1].Int64(); err == nil {
+			rowid = uint64(id)
 		}
 	}
 
 	return &Cell{
-		PayloadSize: payloadSize,
-		Rowid:       rowid,
-		Record: Record{
-			RecordHeader: header,
-			RecordBody:   body,
-		},
+		PayloadSize:  payloadSize,
+		Rowid:        rowid,
+		Record:       record,
+		OverflowPage: overflowPage,
 	}, nil
 }
 
 // ParseInteriorCell parses an interior index cell
-func (p *IndexBTreeParser) ParseInteriorCell(pageData []byte, offset int) (uint32, BTreeKey, error) {
-	// fmt.Printf("DEBUG: ParseInteriorCell at offset 0x%x\n", offset)
-
+func (p *IndexBTreeParser) ParseInteriorCell(ctx context.Context, pageData []byte, offset int) (uint32, BTreeKey, error) {
 	if offset+4 > len(pageData) {
 		return 0, nil, fmt.Errorf("interior cell offset exceeds page size")
 	}
@@ -138,83 +242,23 @@ func (p *IndexBTreeParser) ParseInteriorCell(pageData []byte, offset int) (uint3
 	// fmt.Printf("DEBUG: Record starts at offset 0x%x, payload size: %d\n", recordOffset, payloadSize)
 	offset += bytesRead
 
-	if offset+int(payloadSize) > len(pageData) {
-		return 0, nil, fmt.Errorf("payload extends beyond page boundary")
+	maxLocal := indexMaxLocal(p.overflow.dbRaw.GetUsableSize())
+	payload, _, err := p.overflow.ReadPayload(ctx, pageData, offset, payloadSize, maxLocal)
+	if err != nil {
+		return childPageNum, nil, err
 	}
-	payload := pageData[offset : offset+int(payloadSize)]
 
 	// Parse the key from payload
-	header, headerOffset := readRecordHeader(payload, 0)
-	body, _, err := readRecordBody(payload, headerOffset, header)
+	record, err := parseRecord(payload, TextEncoding(p.overflow.dbRaw.GetHeader().TextEncoding))
 	if err != nil {
 		return childPageNum, nil, err
 	}
 
-	// Extract the first key value for comparison
-	var key interface{}
-	if len(body.Values) > 0 {
-		// Convert bytes to string for proper comparison
-		if bytes, ok := body.Values[0].([]byte); ok {
-			key = string(bytes)
-		} else {
-			key = body.Values[0]
-		}
-	}
-
-	return childPageNum, key, nil
+	// The whole record, rowid included, is the key
+	return childPageNum, IndexKey(record.ColumnValues()), nil
 }
 
-// ExtractSearchKey extracts the first indexed column value from an index cell
+// ExtractSearchKey returns the full key of an index cell, rowid included
 func (p *IndexBTreeParser) ExtractSearchKey(cell *Cell) BTreeKey {
-	if len(cell.Record.RecordBody.Values) > 0 {
-		value := cell.Record.RecordBody.Values[0]
-		if bytes, ok := value.([]byte); ok {
-			result := string(bytes)
-			// Debug: show record structure for entries starting with 'er'
-			// if len(result) >= 2 && result[:2] == "er" {
-			// 	fmt.Printf("DEBUG: RECORD with 'er' - Values count: %d\n", len(cell.Record.RecordBody.Values))
-			// 	for i, val := range cell.Record.RecordBody.Values {
-			// 		if valBytes, ok := val.([]byte); ok {
-			// 			fmt.Printf("DEBUG:   Value[%d]: '%s' (len=%d)\n", i, string(valBytes), len(valBytes))
-			// 		} else {
-			// 			fmt.Printf("DEBUG:   Value[%d]: %v (%T)\n", i, val, val)
-			// 		}
-			// 	}
-			// }
-			// if result == "eritrea" {
-			// 	fmt.Printf("DEBUG: Found exact match 'eritrea'!\n")
-			// }
-			return result
-		}
-		return value
-	}
-	return nil
-}
-
-// MatchesSearchKey checks if an index cell matches the search key
-func (p *IndexBTreeParser) MatchesSearchKey(cell *Cell, searchKey BTreeKey) bool {
-	cellKey := p.ExtractSearchKey(cell)
-	if cellKey == nil {
-		return false
-	}
-
-	// Convert both to strings for comparison
-	var cellStr string
-	if bytes, ok := cellKey.([]byte); ok {
-		cellStr = string(bytes)
-	} else {
-		cellStr = fmt.Sprintf("%v", cellKey)
-	}
-
-	var searchStr string
-	if bytes, ok := searchKey.([]byte); ok {
-		searchStr = string(bytes)
-	} else {
-		searchStr = fmt.Sprintf("%v", searchKey)
-	}
-
-	// fmt.Printf("DEBUG: MatchesSearchKey - cellKey: %T '%s' vs searchKey: %T '%s' = %v\n",
-	// 	cellKey, cellStr, searchKey, searchStr, cellStr == searchStr)
-
-	return cellStr == searchStr
+	return IndexKey(cell.Record.ColumnValues())
 }
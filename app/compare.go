@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// StorageClass represents the SQLite storage class of a value
+type StorageClass uint8
+
+const (
+	StorageClassNull StorageClass = iota
+	StorageClassInteger
+	StorageClassReal
+	StorageClassText
+	StorageClassBlob
+)
+
+// StorageClassOf returns the storage class of a value; a nil value is NULL
+func StorageClassOf(value Value) StorageClass {
+	if value == nil {
+		return StorageClassNull
+	}
+	switch value.Type() {
+	case ValueTypeNull:
+		return StorageClassNull
+	case ValueTypeFloat64:
+		return StorageClassReal
+	case ValueTypeText:
+		return StorageClassText
+	case ValueTypeBlob:
+		return StorageClassBlob
+	default:
+		return StorageClassInteger
+	}
+}
+
+// storageClassRank orders storage classes for comparison: NULL < INTEGER/REAL < TEXT < BLOB
+func storageClassRank(class StorageClass) int {
+	switch class {
+	case StorageClassNull:
+		return 0
+	case StorageClassInteger, StorageClassReal:
+		return 1
+	case StorageClassText:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Collation compares two text values
+type Collation func(a, b string) int
+
+// BinaryCollation compares text byte by byte
+func BinaryCollation(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+// NoCaseCollation compares text ignoring the case of ASCII letters
+func NoCaseCollation(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		if ca >= 'A' && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if cb >= 'A' && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return cmp.Compare(ca, cb)
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// RTrimCollation compares text like BINARY, ignoring trailing spaces
+func RTrimCollation(a, b string) int {
+	return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+}
+
+// LookupCollation returns the built-in collating sequence with the given name
+func LookupCollation(name string) (Collation, error) {
+	switch strings.ToUpper(name) {
+	case "", "BINARY":
+		return BinaryCollation, nil
+	case "NOCASE":
+		return NoCaseCollation, nil
+	case "RTRIM":
+		return RTrimCollation, nil
+	default:
+		return nil, fmt.Errorf("no such collation sequence: %s", name)
+	}
+}
+
+// CompareValues compares two values using SQLite's storage class ordering.
+// Values of different classes never compare equal; numbers compare numerically,
+// text compares with the BINARY collation and blobs compare with memcmp.
+func CompareValues(a, b Value) int {
+	return CompareCollated(a, b, BinaryCollation)
+}
+
+// CompareCollated compares two values like CompareValues, using collation for text
+func CompareCollated(a, b Value, collation Collation) int {
+	classA, classB := StorageClassOf(a), StorageClassOf(b)
+	if rankA, rankB := storageClassRank(classA), storageClassRank(classB); rankA != rankB {
+		return cmp.Compare(rankA, rankB)
+	}
+
+	switch classA {
+	case StorageClassNull:
+		return 0
+	case StorageClassInteger, StorageClassReal:
+		return compareNumbers(a, b)
+	case StorageClassText:
+		if collation == nil {
+			collation = BinaryCollation
+		}
+		return collation(a.String(), b.String())
+	default:
+		return bytes.Compare(a.Raw(), b.Raw())
+	}
+}
+
+// compareNumbers compares two INTEGER or REAL values, exactly when both are integers
+func compareNumbers(a, b Value) int {
+	if StorageClassOf(a) == StorageClassInteger && StorageClassOf(b) == StorageClassInteger {
+		i, _ := a.Int64()
+		j, _ := b.Int64()
+		return cmp.Compare(i, j)
+	}
+
+	f, _ := a.Float64()
+	g, _ := b.Float64()
+	if c := cmp.Compare(f, g); c != 0 {
+		return c
+	}
+
+	// Equal as doubles; large integers may still differ beyond float64 precision
+	if StorageClassOf(a) == StorageClassInteger {
+		i, _ := a.Int64()
+		return compareIntegerReal(i, g)
+	}
+	if StorageClassOf(b) == StorageClassInteger {
+		j, _ := b.Int64()
+		return -compareIntegerReal(j, f)
+	}
+	return 0
+}
+
+// compareIntegerReal compares an INTEGER with a REAL exactly. A REAL of 2^63 or
+// more is greater than every integer, and one below -2^63 is smaller.
+func compareIntegerReal(i int64, f float64) int {
+	switch {
+	case f >= 1<<63:
+		return -1
+	case f < -1<<63:
+		return 1
+	}
+	return cmp.Compare(i, int64(f))
+}
+
+// ApplyAffinity converts a value according to a column affinity, as SQLite does
+// before storing a value or comparing it against a column
+func ApplyAffinity(value Value, affinity Affinity) Value {
+	switch affinity {
+	case AffinityText:
+		switch StorageClassOf(value) {
+		case StorageClassInteger, StorageClassReal:
+			return NewTextValue(value.String())
+		}
+	case AffinityNumeric, AffinityInteger, AffinityReal:
+		if StorageClassOf(value) == StorageClassText {
+			if number, ok := parseNumericText(value.String()); ok {
+				value = number
+			}
+		}
+		if affinity == AffinityReal && StorageClassOf(value) == StorageClassInteger {
+			f, _ := value.Float64()
+			return NewRealValue(f)
+		}
+	}
+	return value
+}
+
+// parseNumericText converts text that looks like a number into an INTEGER or REAL value
+func parseNumericText(text string) (Value, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, false
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return NewIntegerValue(i), true
+	}
+	// strconv accepts forms SQLite does not (hex floats, Inf, NaN, underscores)
+	if strings.ContainsAny(text, "xXnN_") {
+		return nil, false
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, false
+	}
+	// A REAL with no fractional part that fits in an integer becomes an INTEGER
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return NewIntegerValue(int64(f)), true
+	}
+	return NewRealValue(f), true
+}
+
+// comparisonAffinity decides which affinity is applied to both operands of a comparison
+func comparisonAffinity(left, right Affinity) Affinity {
+	if left != AffinityNone && right != AffinityNone {
+		if isNumericAffinity(left) || isNumericAffinity(right) {
+			return AffinityNumeric
+		}
+		return AffinityBlob
+	}
+	if left == AffinityNone {
+		return right
+	}
+	return left
+}
+
+// isNumericAffinity reports whether an affinity is NUMERIC, INTEGER or REAL
+func isNumericAffinity(affinity Affinity) bool {
+	return affinity == AffinityNumeric || affinity == AffinityInteger || affinity == AffinityReal
+}
+
+// Truth is the result of SQLite's three-valued logic: true, false or NULL (unknown)
+type Truth uint8
+
+const (
+	TruthFalse Truth = iota
+	TruthTrue
+	TruthNull
+)
+
+// truthOf converts a Go boolean into a Truth
+func truthOf(b bool) Truth {
+	if b {
+		return TruthTrue
+	}
+	return TruthFalse
+}
+
+// And combines two truths with SQL AND semantics
+func (t Truth) And(other Truth) Truth {
+	switch {
+	case t == TruthFalse || other == TruthFalse:
+		return TruthFalse
+	case t == TruthNull || other == TruthNull:
+		return TruthNull
+	default:
+		return TruthTrue
+	}
+}
+
+// Or combines two truths with SQL OR semantics
+func (t Truth) Or(other Truth) Truth {
+	switch {
+	case t == TruthTrue || other == TruthTrue:
+		return TruthTrue
+	case t == TruthNull || other == TruthNull:
+		return TruthNull
+	default:
+		return TruthFalse
+	}
+}
+
+// Not negates a truth; NOT NULL is still NULL
+func (t Truth) Not() Truth {
+	switch t {
+	case TruthTrue:
+		return TruthFalse
+	case TruthFalse:
+		return TruthTrue
+	default:
+		return TruthNull
+	}
+}
+
+// CompareOperands evaluates a comparison operator between two operands.
+// The comparison affinity is applied first, and any NULL operand yields TruthNull.
+// A nil collation compares text with BINARY.
+func CompareOperands(left, right Value, leftAffinity, rightAffinity Affinity, collation Collation, operator string) (Truth, error) {
+	if StorageClassOf(left) == StorageClassNull || StorageClassOf(right) == StorageClassNull {
+		if _, err := applyComparisonOperator(0, operator); err != nil {
+			return TruthNull, err
+		}
+		return TruthNull, nil
+	}
+
+	affinity := comparisonAffinity(leftAffinity, rightAffinity)
+	left = ApplyAffinity(left, affinity)
+	right = ApplyAffinity(right, affinity)
+
+	result, err := applyComparisonOperator(CompareCollated(left, right, collation), operator)
+	if err != nil {
+		return TruthNull, err
+	}
+	return truthOf(result), nil
+}
+
+// applyComparisonOperator turns a three-way comparison result into the operator's outcome
+func applyComparisonOperator(c int, operator string) (bool, error) {
+	switch operator {
+	case "=":
+		return c == 0, nil
+	case "!=", "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">=":
+		return c >= 0, nil
+	default:
+		return false, fmt.Errorf("unsupported comparison operator: %s", operator)
+	}
+}
+
+// literalValue converts a SQL literal into a typed value
+func literalValue(expr sqlparser.Expr) (Value, error) {
+	switch e := expr.(type) {
+	case *sqlparser.NullVal:
+		return NewNullValue(), nil
+	case sqlparser.BoolVal:
+		if e {
+			return NewIntegerValue(1), nil
+		}
+		return NewIntegerValue(0), nil
+	case *sqlparser.SQLVal:
+		switch e.Type {
+		case sqlparser.StrVal:
+			return NewTextValue(string(e.Val)), nil
+		case sqlparser.IntVal:
+			if i, err := strconv.ParseInt(string(e.Val), 10, 64); err == nil {
+				return NewIntegerValue(i), nil
+			}
+			// Integer literals too large for 64 bits become REAL, as in SQLite
+			f, err := strconv.ParseFloat(string(e.Val), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer literal: %s", e.Val)
+			}
+			return NewRealValue(f), nil
+		case sqlparser.FloatVal:
+			f, err := strconv.ParseFloat(string(e.Val), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid real literal: %s", e.Val)
+			}
+			return NewRealValue(f), nil
+		case sqlparser.HexVal:
+			data, err := hex.DecodeString(string(e.Val))
+			if err != nil {
+				return nil, fmt.Errorf("invalid blob literal: %s", e.Val)
+			}
+			return NewBlobValue(data), nil
+		default:
+			return NewTextValue(string(e.Val)), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported literal type: %T", expr)
+	}
+}
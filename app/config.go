@@ -11,6 +11,7 @@ type DatabaseConfig struct {
 	ReadTimeout     int // milliseconds
 	ValidationMode  ValidationLevel
 	EnableProfiling bool
+	Logger          func(error) // Optional logger for warnings
 }
 
 // ValidationLevel defines validation strictness
@@ -53,6 +54,14 @@ func WithValidation(level ValidationLevel) DatabaseOption {
 	}
 }
 
+// WithLogger sets the logger that receives warnings, such as a hot journal found
+// in ValidationBasic mode
+func WithLogger(logger func(error)) DatabaseOption {
+	return func(cfg *DatabaseConfig) {
+		cfg.Logger = logger
+	}
+}
+
 // WithProfiling enables or disables profiling
 func WithProfiling(enabled bool) DatabaseOption {
 	return func(cfg *DatabaseConfig) {
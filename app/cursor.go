@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"iter"
+)
+
+// BTreeCursor walks the entries of a B-tree in key order, reading pages lazily.
+// It keeps the path from the root to the current entry as a stack of pages, so
+// only one page per level is held in memory and a scan that stops early never
+// reads the pages after it.
+//
+// In a table B-tree the entries are the cells of the leaf pages. In an index
+// B-tree the cells of interior pages are entries as well, ordered between the
+// child subtrees on either side of them.
+type BTreeCursor struct {
+	tree  *BTree
+	ctx   context.Context
+	stack []cursorFrame
+	cell  *Cell
+	err   error
+}
+
+// cursorFrame is one page on the cursor's path from the root
+type cursorFrame struct {
+	pageNum  int
+	data     []byte
+	header   *PageHeader
+	pointers int  // offset of the cell pointer array
+	leaf     bool // leaf page; interior pages index children instead of cells
+	index    int  // current cell on a leaf, current child on an interior page (CellCount is the rightmost child)
+}
+
+// Cursor returns a cursor over the B-tree, positioned before the first entry.
+// Call First or Seek to position it.
+func (bt *BTree) Cursor(ctx context.Context) *BTreeCursor {
+	return &BTreeCursor{tree: bt, ctx: ctx}
+}
+
+// Cells streams every entry of the B-tree in key order
+func (bt *BTree) Cells(ctx context.Context) iter.Seq2[*Cell, error] {
+	return func(yield func(*Cell, error) bool) {
+		cursor := bt.Cursor(ctx)
+		for cursor.First(); cursor.Valid(); cursor.Next() {
+			if !yield(cursor.Cell(), nil) {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// First positions the cursor on the smallest entry
+func (c *BTreeCursor) First() bool {
+	c.reset()
+	c.descend(c.tree.rootPage, nil)
+	c.settle()
+	return c.Valid()
+}
+
+// Seek positions the cursor on the first entry whose key is greater than or equal
+// to key, compared with the tree's comparator: a uint64 rowid for table B-trees,
+// an IndexKey or a prefix of one for index B-trees.
+func (c *BTreeCursor) Seek(key BTreeKey) bool {
+	c.reset()
+	c.descend(c.tree.rootPage, key)
+	c.settle()
+	return c.Valid()
+}
+
+// Next advances the cursor to the following entry
+func (c *BTreeCursor) Next() bool {
+	if !c.Valid() {
+		return false
+	}
+	top := &c.stack[len(c.stack)-1]
+	top.index++
+	if !top.leaf {
+		// the cursor was on an interior index entry; continue with the subtree after it
+		c.descend(c.childPage(top, top.index), nil)
+	}
+	c.settle()
+	return c.Valid()
+}
+
+// Valid reports whether the cursor is positioned on an entry
+func (c *BTreeCursor) Valid() bool {
+	return c.cell != nil && c.err == nil
+}
+
+// Cell returns the entry under the cursor
+func (c *BTreeCursor) Cell() *Cell {
+	return c.cell
+}
+
+// Err returns the error that stopped the cursor, if any
+func (c *BTreeCursor) Err() error {
+	return c.err
+}
+
+func (c *BTreeCursor) reset() {
+	c.stack = c.stack[:0]
+	c.cell = nil
+	c.err = nil
+}
+
+// descend pushes the pages from pageNum down to a leaf. With a nil key it follows
+// the leftmost children; otherwise it follows the children that can hold key and
+// stops at the first leaf cell not smaller than key.
+func (c *BTreeCursor) descend(pageNum int, key BTreeKey) {
+	for c.err == nil {
+		frame, err := c.loadPage(pageNum)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if key != nil {
+			frame.index, err = c.lowerBound(&frame, key)
+			if err != nil {
+				c.err = err
+				return
+			}
+		}
+		c.stack = append(c.stack, frame)
+		if frame.leaf {
+			return
+		}
+		pageNum = c.childPage(&frame, frame.index)
+	}
+}
+
+// settle moves the cursor from its current stack position to the next entry,
+// popping exhausted pages and descending into the following subtrees
+func (c *BTreeCursor) settle() {
+	c.cell = nil
+	for c.err == nil && len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		count := int(top.header.CellCount)
+
+		if top.leaf {
+			if top.index < count {
+				c.cell, c.err = c.tree.parser.ParseLeafCell(c.ctx, top.data, c.cellOffset(top, top.index))
+				if c.err != nil {
+					c.err = fmt.Errorf("parse cell %d on page %d: %w", top.index, top.pageNum, c.err)
+					c.cell = nil
+				}
+				return
+			}
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+
+		// an interior page is only reached here after its child top.index is done
+		if c.tree.btreeType == BTreeTypeIndex && top.index < count {
+			c.cell, c.err = c.interiorEntry(top, top.index)
+			return
+		}
+		top.index++
+		if top.index > count {
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+		c.descend(c.childPage(top, top.index), nil)
+	}
+}
+
+// Estimate samples the path down the middle of the B-tree to estimate its number
+// of entries, without reading the rest of it: each interior page on the path is
+// taken to be as full as the page sampled below it. It also returns the depth,
+// the number of pages a lookup reads, and the entries per leaf page.
+func (c *BTreeCursor) Estimate() (entries float64, depth int, perLeaf float64, err error) {
+	c.reset()
+	var path []cursorFrame
+	for pageNum := c.tree.rootPage; ; {
+		frame, err := c.loadPage(pageNum)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		path = append(path, frame)
+		if frame.leaf {
+			break
+		}
+		pageNum = c.childPage(&frame, int(frame.header.CellCount)/2)
+	}
+
+	entries = float64(path[len(path)-1].header.CellCount)
+	perLeaf = max(entries, 1)
+	for i := len(path) - 2; i >= 0; i-- {
+		cells := float64(path[i].header.CellCount)
+		entries *= cells + 1
+		if path[i].header.IsInteriorIndex() {
+			// interior index cells are entries themselves
+			entries += cells
+		}
+	}
+	return entries, len(path), perLeaf, nil
+}
+
+// loadPage reads a page and its header into a stack frame
+func (c *BTreeCursor) loadPage(pageNum int) (cursorFrame, error) {
+	if err := c.ctx.Err(); err != nil {
+		return cursorFrame{}, err
+	}
+	data, err := c.tree.dbRaw.ReadPage(c.ctx, pageNum)
+	if err != nil {
+		return cursorFrame{}, fmt.Errorf("read page %d: %w", pageNum, err)
+	}
+
+	headerOffset := 0
+	if pageNum == 1 {
+		headerOffset = 100 // page 1 starts with the database header
+	}
+	header, err := c.tree.parsePageHeaderAtOffset(data, headerOffset)
+	if err != nil {
+		return cursorFrame{}, fmt.Errorf("parse header of page %d: %w", pageNum, err)
+	}
+
+	frame := cursorFrame{pageNum: pageNum, data: data, header: header, leaf: c.tree.isLeafPage(header)}
+	switch {
+	case frame.leaf:
+		frame.pointers = headerOffset + 8
+	case header.IsInteriorTable() || header.IsInteriorIndex():
+		frame.pointers = headerOffset + 12
+	default:
+		return cursorFrame{}, NewDatabaseError("load_btree_page", ErrInvalidPageType, map[string]interface{}{
+			"page":      pageNum,
+			"page_type": header.PageType,
+		})
+	}
+	if frame.pointers+2*int(header.CellCount) > len(data) {
+		return cursorFrame{}, NewDatabaseError("load_btree_page", ErrInvalidCellPointer, map[string]interface{}{
+			"page":       pageNum,
+			"cell_count": header.CellCount,
+		})
+	}
+	return frame, nil
+}
+
+// lowerBound returns the position of the first cell on the page whose key is not
+// smaller than key; on an interior page that is the child that can hold key
+func (c *BTreeCursor) lowerBound(frame *cursorFrame, key BTreeKey) (int, error) {
+	lo, hi := 0, int(frame.header.CellCount)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		cellKey, err := c.cellKey(frame, mid)
+		if err != nil {
+			return 0, err
+		}
+		if c.tree.comparator(cellKey, key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// cellKey returns the search key of cell i of a page
+func (c *BTreeCursor) cellKey(frame *cursorFrame, i int) (BTreeKey, error) {
+	offset := c.cellOffset(frame, i)
+	if !frame.leaf {
+		_, key, err := c.tree.parser.ParseInteriorCell(c.ctx, frame.data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("parse interior cell %d on page %d: %w", i, frame.pageNum, err)
+		}
+		return key, nil
+	}
+	cell, err := c.tree.parser.ParseLeafCell(c.ctx, frame.data, offset)
+	if err != nil {
+		return nil, fmt.Errorf("parse cell %d on page %d: %w", i, frame.pageNum, err)
+	}
+	return c.tree.parser.ExtractSearchKey(cell), nil
+}
+
+// interiorEntry parses the entry held by cell i of an interior index page, which
+// has the layout of a leaf index cell after the 4-byte child pointer
+func (c *BTreeCursor) interiorEntry(frame *cursorFrame, i int) (*Cell, error) {
+	cell, err := c.tree.parser.ParseLeafCell(c.ctx, frame.data, c.cellOffset(frame, i)+4)
+	if err != nil {
+		return nil, fmt.Errorf("parse interior cell %d on page %d: %w", i, frame.pageNum, err)
+	}
+	return cell, nil
+}
+
+// childPage returns child i of an interior page; CellCount selects the rightmost child
+func (c *BTreeCursor) childPage(frame *cursorFrame, i int) int {
+	if i >= int(frame.header.CellCount) {
+		rightmost := frame.pointers - 4
+		return int(binary.BigEndian.Uint32(frame.data[rightmost:frame.pointers]))
+	}
+	offset := c.cellOffset(frame, i)
+	if offset+4 > len(frame.data) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(frame.data[offset : offset+4]))
+}
+
+// cellOffset returns the content offset of cell i of a page
+func (c *BTreeCursor) cellOffset(frame *cursorFrame, i int) int {
+	pointer := frame.pointers + 2*i
+	return int(binary.BigEndian.Uint16(frame.data[pointer : pointer+2]))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/xwb1989/sqlparser"
@@ -11,10 +12,11 @@ import (
 // DatabaseImpl implements DatabaseInterface
 type DatabaseImpl struct {
 	dbRaw        DatabaseRaw
-	tables       map[string]Table // cached tables
-	indexes      map[string]Index // cached indexes
-	schemas      []SchemaRecord   // cached schema records
-	schemaLoaded bool             // flag to track if schema is loaded
+	tables       map[string]Table       // cached tables
+	indexes      map[string]Index       // cached indexes
+	schemas      []SchemaRecord         // cached schema records
+	schemaLoaded bool                   // flag to track if schema is loaded
+	stats        map[string]*TableStats // cached planner statistics by table
 }
 
 // NewDatabase creates a new logical database instance with functional options
@@ -30,6 +32,7 @@ func NewDatabase(filePath string, options ...DatabaseOption) (*DatabaseImpl, err
 		indexes:      make(map[string]Index),
 		schemas:      nil,
 		schemaLoaded: false,
+		stats:        make(map[string]*TableStats),
 	}
 
 	return db, nil
@@ -52,7 +55,7 @@ func (db *DatabaseImpl) LoadSchema(ctx context.Context) ([]SchemaRecord, error)
 	indexes := make(map[string]Index)
 
 	for _, cell := range schemaCells {
-		schema := cell.Record.RecordBody.ParseAsSchema()
+		schema := cell.Record.ParseAsSchema()
 		if schema != nil {
 			schemas = append(schemas, *schema)
 		}
@@ -61,19 +64,32 @@ func (db *DatabaseImpl) LoadSchema(ctx context.Context) ([]SchemaRecord, error)
 	// Single pass: create tables and indexes, and associate indexes with tables
 	for _, schema := range schemas {
 		if schema.Type == "table" && schema.Name != "sqlite_master" {
+			encoding := TextEncoding(db.dbRaw.GetHeader().TextEncoding)
+			if primaryKey, ok := withoutRowidKey(schema.SQL); ok {
+				tables[schema.Name] = NewWithoutRowidTable(db.dbRaw, &schema, primaryKey, encoding)
+				continue
+			}
 			tableRaw := NewTableRaw(db.dbRaw, schema.Name, int(schema.RootPage))
-			// fmt.Fprintf(os.Stderr, "Creating table: %s\n", schema.Name)
-			tableImpl := NewTable(tableRaw, &schema)
+			tableImpl := NewTable(tableRaw, &schema, encoding)
 			tables[schema.Name] = Table(tableImpl)
 		} else if schema.Type == "index" {
+			tableImpl, _ := tables[schema.TblName].(*TableImpl)
 			indexRaw := NewIndexRaw(db.dbRaw, schema.Name, int(schema.RootPage), &schema)
+			if schema.SQL == "" && tableImpl != nil {
+				if keyColumns, ok := tableImpl.autoindexKey(ctx, schema.Name); ok {
+					indexRaw = NewAutoIndexRaw(db.dbRaw, schema.Name, int(schema.RootPage), &schema, keyColumns)
+				}
+			}
 			index := NewIndex(indexRaw, &schema)
 			indexes[schema.Name] = index
-			// Associate with table if it exists
-			if table, ok := tables[schema.TblName]; ok {
-				if tableImpl, ok := table.(*TableImpl); ok {
-					tableImpl.AddIndex(index)
+			// Associate with table if it exists. The entries of an index on a WITHOUT
+			// ROWID table end with the primary key instead of a rowid, which lookups
+			// do not follow, so the planner does not get to use them.
+			if tableImpl != nil && !tableImpl.WithoutRowid() {
+				if columns, err := tableImpl.GetSchema(ctx); err == nil {
+					indexRaw.inheritCollations(columns)
 				}
+				tableImpl.AddIndex(index)
 			}
 		}
 	}
@@ -97,7 +113,6 @@ func (db *DatabaseImpl) GetTables(ctx context.Context) ([]string, error) {
 	names := make([]string, 0, len(db.tables)+1)
 	names = append(names, "sqlite_master")
 	for name := range db.tables {
-		// fmt.Fprintf(os.Stderr, "Found table: %s\n", name)
 		if name != "sqlite_master" {
 			names = append(names, name)
 		}
@@ -158,12 +173,44 @@ func (db *DatabaseImpl) GetPageSize() int {
 	return db.dbRaw.GetPageSize()
 }
 
+// GetHeader returns the database header
+func (db *DatabaseImpl) GetHeader() *DatabaseHeader {
+	return db.dbRaw.GetHeader()
+}
+
+// FileSize returns the size of the database file in bytes
+func (db *DatabaseImpl) FileSize() (int64, error) {
+	return db.dbRaw.FileSize()
+}
+
+// Snapshots lists the commits in the write-ahead log, oldest first
+func (db *DatabaseImpl) Snapshots() []WALSnapshot {
+	return db.dbRaw.Snapshots()
+}
+
+// UseSnapshot makes reads see the database as of a commit frame in the
+// write-ahead log, or as of the last commit for 0. The schema is loaded again,
+// as it may have changed between commits.
+func (db *DatabaseImpl) UseSnapshot(frame int) error {
+	if err := db.dbRaw.UseSnapshot(frame); err != nil {
+		return err
+	}
+	db.ClearCache()
+	return nil
+}
+
+// PageCacheStats returns the counters of the underlying page cache
+func (db *DatabaseImpl) PageCacheStats() PageCacheStats {
+	return db.dbRaw.PageCacheStats()
+}
+
 // ClearCache clears all cached data (tables and schema)
 func (db *DatabaseImpl) ClearCache() {
 	db.tables = make(map[string]Table)
 	db.indexes = make(map[string]Index)
 	db.schemas = nil
 	db.schemaLoaded = false
+	db.stats = make(map[string]*TableStats)
 }
 
 // parseTableSchema parses table schema from CREATE TABLE SQL
@@ -188,26 +235,28 @@ func parseTableSchema(schemaSQL string) ([]Column, error) {
 			})
 		}
 
-		// Parse columns and detect PRIMARY KEY and AUTOINCREMENT
+		// Parse columns and detect the rowid alias and AUTOINCREMENT
 		columns := make([]Column, len(parsedStmt.TableSpec.Columns))
+		primaryKey, aliasable := primaryKeyColumns(schemaSQL)
 
 		for i, col := range parsedStmt.TableSpec.Columns {
-			isAutoIncrement := bool(col.Type.Autoincrement)
-			isIntegerPrimaryKey := isAutoIncrement && strings.ToUpper(col.Type.Type) == "INTEGER"
+			isPrimaryKey := slices.ContainsFunc(primaryKey, func(name string) bool {
+				return strings.EqualFold(name, col.Name.String())
+			})
+			// Only a type spelled exactly INTEGER makes the primary key an alias of the rowid
+			isRowidAlias := isPrimaryKey && aliasable &&
+				strings.EqualFold(col.Type.Type, "integer") && col.Type.Length == nil && !bool(col.Type.Unsigned)
 
 			columns[i] = Column{
 				Name:            col.Name.String(),
 				Type:            col.Type.Type,
 				Index:           i,
-				Nullable:        true,                // Default assumption
-				IsPrimaryKey:    isIntegerPrimaryKey, // In SQLite, INTEGER PRIMARY KEY AUTOINCREMENT is the primary key
-				IsAutoIncrement: isAutoIncrement,
+				Nullable:        true, // Default assumption
+				IsPrimaryKey:    isPrimaryKey,
+				IsAutoIncrement: bool(col.Type.Autoincrement),
+				RowidAlias:      isRowidAlias,
+				Collation:       col.Type.Collate,
 			}
-
-			// Primary key columns are not nullable
-			// if isIntegerPrimaryKey {
-			// 	columns[i].Nullable = false
-			// }
 		}
 
 		return columns, nil
@@ -229,6 +278,9 @@ func normalizeSQLiteToMySQL(sql string) string {
 	normalized = strings.ReplaceAll(normalized, "primary key autoincrement", "AUTO_INCREMENT PRIMARY KEY")
 	normalized = strings.ReplaceAll(normalized, "PRIMARY KEY AUTOINCREMENT", "AUTO_INCREMENT PRIMARY KEY")
 
+	// Drop constraint names, ON CONFLICT clauses and key sort orders
+	normalized = stripKeyClauses(normalized)
+
 	// Handle column names with spaces - wrap them in backticks for MySQL compatibility
 	normalized = handleColumnNamesWithSpaces(normalized)
 
@@ -254,8 +306,9 @@ func (db *DatabaseImpl) loadTableIndexes(ctx context.Context, table *TableImpl,
 	// Find all indexes that belong to this table
 	for _, schema := range schemas {
 		if schema.Type == "index" && schema.TblName == tableName {
-			// Create index
-			index, err := db.createIndexFromSchema(ctx, &schema)
+			// Create index; keys inherit the collations of the table columns
+			columns, _ := table.GetSchema(ctx)
+			index, err := db.createIndexFromSchema(ctx, &schema, columns)
 			if err != nil {
 				return fmt.Errorf("create index %s for table %s: %w", schema.Name, tableName, err)
 			}
@@ -269,9 +322,10 @@ func (db *DatabaseImpl) loadTableIndexes(ctx context.Context, table *TableImpl,
 }
 
 // createIndexFromSchema creates an index from a schema record
-func (db *DatabaseImpl) createIndexFromSchema(ctx context.Context, schema *SchemaRecord) (Index, error) {
+func (db *DatabaseImpl) createIndexFromSchema(ctx context.Context, schema *SchemaRecord, tableColumns []Column) (Index, error) {
 	// Create raw index
 	indexRaw := NewIndexRaw(db.dbRaw, schema.Name, int(schema.RootPage), schema)
+	indexRaw.inheritCollations(tableColumns)
 
 	// Create logical index
 	index := NewIndex(indexRaw, schema)
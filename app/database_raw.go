@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
@@ -16,6 +17,9 @@ type DatabaseRawImpl struct {
 	config         *DatabaseConfig
 	resourceMgr    *ResourceManager
 	concurrencySem chan struct{} // Semaphore for limiting concurrency
+	pageCache      *PageCache
+	wal            *WAL     // write-ahead log with the newest pages, for a database in WAL mode
+	journal        *Journal // hot rollback journal with the pages before an interrupted transaction
 }
 
 // NewDatabaseRaw creates a new raw database instance with functional options
@@ -43,6 +47,7 @@ func NewDatabaseRaw(filePath string, options ...DatabaseOption) (*DatabaseRawImp
 		config:         config,
 		resourceMgr:    resourceMgr,
 		concurrencySem: concurrencySem,
+		pageCache:      NewPageCache(config.PageCacheSize),
 	}
 
 	// Parse the database header
@@ -51,11 +56,59 @@ func NewDatabaseRaw(filePath string, options ...DatabaseOption) (*DatabaseRawImp
 		return nil, fmt.Errorf("parse database header: %w", err)
 	}
 
+	// In WAL mode the newest committed pages, page 1 and its header included, are
+	// in the -wal file until a checkpoint copies them back
+	wal, err := OpenWAL(filePath, db.pageSize)
+	if err != nil {
+		resourceMgr.Close()
+		return nil, err
+	}
+	if wal != nil {
+		resourceMgr.Add(wal)
+		db.wal = wal
+		if err := db.parseWALHeader(); err != nil {
+			resourceMgr.Close()
+			return nil, fmt.Errorf("parse database header: %w", err)
+		}
+	}
+
+	// A hot journal means a writer crashed mid-transaction and left the file
+	// half-written; its original pages give the state before the transaction
+	journal, err := OpenHotJournal(filePath, db.pageSize)
+	if err != nil {
+		resourceMgr.Close()
+		return nil, err
+	}
+	if journal != nil {
+		switch config.ValidationMode {
+		case ValidationStrict:
+			resourceMgr.Close()
+			return nil, fmt.Errorf("%w %s: a writer crashed in the middle of a transaction", ErrHotJournal, journal.Path)
+		case ValidationBasic:
+			if config.Logger != nil {
+				config.Logger(fmt.Errorf("%w %s: reading the database as it was before the interrupted transaction", ErrHotJournal, journal.Path))
+			}
+		}
+		db.journal = journal
+		if page, ok := journal.Page(1); ok {
+			if err := db.parseHeaderPage(page); err != nil {
+				resourceMgr.Close()
+				return nil, fmt.Errorf("parse database header: %w", err)
+			}
+		}
+	}
+
 	return db, nil
 }
 
-// ReadPage reads a page from the database file with context support
+// ReadPage reads a page from the database file with context support.
+// Pages are served from the page cache when possible; the returned slice
+// may be shared with other readers and must not be modified.
 func (db *DatabaseRawImpl) ReadPage(ctx context.Context, pageNum int) ([]byte, error) {
+	if pageData, ok := db.pageCache.Get(pageNum); ok {
+		return pageData, nil
+	}
+
 	// Acquire concurrency semaphore
 	select {
 	case db.concurrencySem <- struct{}{}:
@@ -73,7 +126,22 @@ func (db *DatabaseRawImpl) ReadPage(ctx context.Context, pageNum int) ([]byte, e
 	offset := int64(pageNum-1) * int64(db.pageSize)
 	// fmt.Printf("DEBUG: ReadPage(%d) - pageSize=%d, offset=0x%x\n", pageNum, db.pageSize, offset)
 
+	if db.journal != nil {
+		if pageData, ok := db.journal.Page(pageNum); ok {
+			return pageData, nil
+		}
+	}
+
 	pageData := make([]byte, db.pageSize)
+	if db.wal != nil {
+		if frame, ok := db.wal.Lookup(pageNum); ok {
+			if err := db.wal.ReadFrame(frame, pageData); err != nil {
+				return nil, fmt.Errorf("read page %d: %w", pageNum, err)
+			}
+			db.pageCache.Put(pageNum, pageData)
+			return pageData, nil
+		}
+	}
 	n, err := db.file.ReadAt(pageData, offset)
 	if err != nil {
 		return nil, fmt.Errorf("read page %d at offset %d: %w", pageNum, offset, err)
@@ -83,9 +151,15 @@ func (db *DatabaseRawImpl) ReadPage(ctx context.Context, pageNum int) ([]byte, e
 			pageNum, db.pageSize, n)
 	}
 
+	db.pageCache.Put(pageNum, pageData)
 	return pageData, nil
 }
 
+// PageCacheStats returns the hit, miss and eviction counters of the page cache
+func (db *DatabaseRawImpl) PageCacheStats() PageCacheStats {
+	return db.pageCache.Stats()
+}
+
 // ReadSchemaTable reads the schema table (sqlite_schema/sqlite_master) from page 1 with context
 func (db *DatabaseRawImpl) ReadSchemaTable(ctx context.Context) ([]Cell, error) {
 	// Schema table is always on page 1 - use BTree abstraction
@@ -97,16 +171,58 @@ func (db *DatabaseRawImpl) ReadSchemaTable(ctx context.Context) ([]Cell, error)
 	return cells, nil
 }
 
+// Snapshots lists the commits in the write-ahead log, oldest first; there are
+// none outside WAL mode
+func (db *DatabaseRawImpl) Snapshots() []WALSnapshot {
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.Snapshots()
+}
+
+// UseSnapshot makes reads see the database as of a commit frame in the
+// write-ahead log, or as of the last commit for 0
+func (db *DatabaseRawImpl) UseSnapshot(frame int) error {
+	if db.wal == nil {
+		return fmt.Errorf("database is not in WAL mode")
+	}
+	if err := db.wal.UseSnapshot(frame); err != nil {
+		return err
+	}
+	db.pageCache.Clear()
+	if err := db.parseHeader(); err != nil {
+		return fmt.Errorf("parse database header: %w", err)
+	}
+	if err := db.parseWALHeader(); err != nil {
+		return fmt.Errorf("parse database header: %w", err)
+	}
+	return nil
+}
+
 // GetPageSize returns the database page size
 func (db *DatabaseRawImpl) GetPageSize() int {
 	return db.pageSize
 }
 
+// GetUsableSize returns the usable page size (page size minus the reserved bytes per page)
+func (db *DatabaseRawImpl) GetUsableSize() int {
+	return db.pageSize - int(db.header.ReservedBytes)
+}
+
 // GetHeader returns the database header for inspection
 func (db *DatabaseRawImpl) GetHeader() *DatabaseHeader {
 	return db.header
 }
 
+// FileSize returns the size of the database file in bytes
+func (db *DatabaseRawImpl) FileSize() (int64, error) {
+	info, err := db.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat database file: %w", err)
+	}
+	return info.Size(), nil
+}
+
 // Close closes the database file using resource manager
 func (db *DatabaseRawImpl) Close() error {
 	if db.resourceMgr != nil {
@@ -147,3 +263,31 @@ func (db *DatabaseRawImpl) parseHeader() error {
 
 	return nil
 }
+
+// parseWALHeader replaces the header with the one on the newest image of page 1
+// in the write-ahead log, if it holds one
+func (db *DatabaseRawImpl) parseWALHeader() error {
+	frame, ok := db.wal.Lookup(1)
+	if !ok {
+		return nil
+	}
+	page := make([]byte, db.pageSize)
+	if err := db.wal.ReadFrame(frame, page); err != nil {
+		return err
+	}
+	return db.parseHeaderPage(page)
+}
+
+// parseHeaderPage replaces the header with the one on an image of page 1 from the
+// write-ahead log or a journal, which must have the same page size
+func (db *DatabaseRawImpl) parseHeaderPage(page []byte) error {
+	header := &DatabaseHeader{}
+	if err := binary.Read(bytes.NewReader(page), binary.BigEndian, header); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if !header.IsValidMagicNumber() || header.GetActualPageSize() != db.pageSize {
+		return fmt.Errorf("invalid header on an image of page 1")
+	}
+	db.header = header
+	return nil
+}
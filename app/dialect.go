@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The MySQL grammar used by sqlparser reads "||" as logical OR, while SQLite
+// uses it for string concatenation. SQLite has no "^" operator, and "^" binds
+// tighter than "*" in the MySQL grammar just like "||" does in SQLite, so
+// queries are rewritten to use "^" and the expression compiler treats it as
+// concatenation. A "^" typed in a query is rejected, as SQLite does.
+const concatOperator = "^"
+
+// rewriteSQLiteQuery rewrites SQLite-only syntax in a query into a form sqlparser accepts
+func rewriteSQLiteQuery(sql string) (string, error) {
+	var result strings.Builder
+	result.Grow(len(sql))
+
+	var quote byte // the quote character of the literal or identifier being copied, if any
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '[':
+			quote = ']'
+		case ch == concatOperator[0]:
+			return "", fmt.Errorf("unrecognized token: %q", concatOperator)
+		case ch == '|' && i+1 < len(sql) && sql[i+1] == '|':
+			result.WriteString(" " + concatOperator + " ")
+			i++
+			continue
+		}
+		result.WriteByte(ch)
+	}
+
+	return quoteCollationNames(result.String()), nil
+}
+
+// quoteCollationNames quotes the collation name after each COLLATE, since names
+// such as BINARY are reserved words in the MySQL grammar
+func quoteCollationNames(sql string) string {
+	tokens := tokenizeSQL(sql)
+
+	var result strings.Builder
+	copied := 0
+	for i := 0; i+1 < len(tokens); i++ {
+		name := tokens[i+1]
+		// a quoted name is skipped by the tokenizer, so only adjacent words are bare names
+		if tokens[i].text != "COLLATE" || name.text == "," || strings.TrimSpace(sql[tokens[i].end:name.start]) != "" {
+			continue
+		}
+		result.WriteString(sql[copied:name.start])
+		result.WriteString("`" + sql[name.start:name.end] + "`")
+		copied = name.end
+		i++
+	}
+	if copied == 0 {
+		return sql
+	}
+	result.WriteString(sql[copied:])
+	return result.String()
+}
+
+// NullsOrder is the NULLS FIRST / NULLS LAST modifier of an ORDER BY term
+type NullsOrder int
+
+const (
+	NullsDefault NullsOrder = iota // NULLs sort as the smallest value
+	NullsFirst
+	NullsLast
+)
+
+// sqlToken is a word or comma found outside quotes, with its parenthesis depth
+type sqlToken struct {
+	start, end int
+	text       string
+	depth      int
+}
+
+// tokenizeSQL returns the words and commas of a query, skipping literals and quoted identifiers
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	depth := 0
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`' || ch == '[':
+			closing := ch
+			if ch == '[' {
+				closing = ']'
+			}
+			if end := strings.IndexByte(sql[i+1:], closing); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(sql)
+			}
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',':
+			tokens = append(tokens, sqlToken{start: i, end: i + 1, text: ",", depth: depth})
+		case isIdentifierChar(ch):
+			start := i
+			for i+1 < len(sql) && isIdentifierChar(sql[i+1]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{start: start, end: i + 1, text: strings.ToUpper(sql[start : i+1]), depth: depth})
+		}
+	}
+	return tokens
+}
+
+func isIdentifierChar(ch byte) bool {
+	return ch == '_' || ch == '$' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+}
+
+// stripNullsOrdering removes NULLS FIRST / NULLS LAST from the top-level ORDER BY
+// clause, which sqlparser cannot parse, and returns the modifier of each term
+func stripNullsOrdering(sql string) (string, []NullsOrder) {
+	tokens := tokenizeSQL(sql)
+
+	var result strings.Builder
+	var orders []NullsOrder
+	copied := 0
+	inOrderBy := false
+	term := 0
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.depth != 0 {
+			continue
+		}
+		switch {
+		case token.text == "ORDER" && i+1 < len(tokens) && tokens[i+1].text == "BY":
+			inOrderBy = true
+			term = 0
+			i++
+		case !inOrderBy:
+		case token.text == ",":
+			term++
+		case token.text == "LIMIT":
+			inOrderBy = false
+		case token.text == "NULLS" && i+1 < len(tokens) && (tokens[i+1].text == "FIRST" || tokens[i+1].text == "LAST"):
+			for len(orders) <= term {
+				orders = append(orders, NullsDefault)
+			}
+			orders[term] = NullsFirst
+			if tokens[i+1].text == "LAST" {
+				orders[term] = NullsLast
+			}
+			result.WriteString(sql[copied:token.start])
+			copied = tokens[i+1].end
+			i++
+		}
+	}
+	if orders == nil {
+		return sql, nil
+	}
+	result.WriteString(sql[copied:])
+	return result.String(), orders
+}
+
+// tableConstraintKeywords start the table constraints of a CREATE TABLE column list
+var tableConstraintKeywords = map[string]bool{"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "CHECK": true, "FOREIGN": true}
+
+// tableDefinitions splits the column list of a CREATE TABLE statement into its
+// column definitions and table constraints
+func tableDefinitions(tokens []sqlToken) [][]sqlToken {
+	var definitions [][]sqlToken
+	var current []sqlToken
+	for _, token := range tokens {
+		switch {
+		case token.depth < 1:
+			continue
+		case token.depth == 1 && token.text == ",":
+			definitions = append(definitions, current)
+			current = nil
+		default:
+			current = append(current, token)
+		}
+	}
+	if len(current) > 0 {
+		definitions = append(definitions, current)
+	}
+	return definitions
+}
+
+// primaryKeyAt reports whether the tokens at i are PRIMARY KEY
+func primaryKeyAt(tokens []sqlToken, i int) bool {
+	return tokens[i].text == "PRIMARY" && i+1 < len(tokens) && tokens[i+1].text == "KEY"
+}
+
+// unquoteIdentifiers blanks out identifier quotes so quoted names tokenize as words;
+// offsets into the result match the original statement
+func unquoteIdentifiers(sql string) string {
+	return strings.NewReplacer(`"`, " ", "`", " ", "[", " ", "]", " ").Replace(sql)
+}
+
+// primaryKeyColumns returns the PRIMARY KEY columns of a CREATE TABLE statement
+// and whether a single-column key there may alias the rowid; the declared type is
+// checked by the caller. As in SQLite, a column constraint PRIMARY KEY DESC does
+// not make an alias while a table constraint PRIMARY KEY (x DESC) does, and
+// WITHOUT ROWID tables have no rowid to alias.
+func primaryKeyColumns(sql string) ([]string, bool) {
+	plain := unquoteIdentifiers(sql)
+	tokens := tokenizeSQL(plain)
+
+	aliasable := !withoutRowid(tokens)
+	var names []string
+	for _, definition := range tableDefinitions(tokens) {
+		if len(definition) == 0 {
+			continue
+		}
+		tableConstraint := tableConstraintKeywords[definition[0].text]
+		for i := range definition {
+			if definition[i].depth != 1 || !primaryKeyAt(definition, i) {
+				continue
+			}
+			if !tableConstraint {
+				names = append(names, plain[definition[0].start:definition[0].end])
+				if i+2 < len(definition) && definition[i+2].depth == 1 && definition[i+2].text == "DESC" {
+					aliasable = false
+				}
+				break
+			}
+			// PRIMARY KEY (column [COLLATE name] [ASC|DESC], ...)
+			for j := i + 2; j < len(definition) && definition[j].depth == 2; j++ {
+				if j == i+2 || definition[j-1].text == "," {
+					names = append(names, plain[definition[j].start:definition[j].end])
+				}
+			}
+			break
+		}
+	}
+	return names, aliasable && len(names) == 1
+}
+
+// withoutRowid reports whether the tokens of a CREATE TABLE statement declare a
+// WITHOUT ROWID table
+func withoutRowid(tokens []sqlToken) bool {
+	for i := range tokens {
+		if tokens[i].depth == 0 && tokens[i].text == "WITHOUT" && i+1 < len(tokens) && tokens[i+1].text == "ROWID" {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutRowidKey returns the PRIMARY KEY of a CREATE TABLE statement declaring a
+// WITHOUT ROWID table, which stores its rows in an index B-tree ordered by the key.
+// The key columns carry the sort order and collation the constraint gives them.
+func withoutRowidKey(sql string) ([]IndexKeyColumn, bool) {
+	plain := unquoteIdentifiers(sql)
+	tokens := tokenizeSQL(plain)
+	if !withoutRowid(tokens) {
+		return nil, false
+	}
+	for _, definition := range tableDefinitions(tokens) {
+		for i := range definition {
+			if definition[i].depth != 1 || !primaryKeyAt(definition, i) {
+				continue
+			}
+			if !tableConstraintKeywords[definition[0].text] {
+				key := IndexKeyColumn{Name: plain[definition[0].start:definition[0].end]}
+				key.Desc = i+2 < len(definition) && definition[i+2].depth == 1 && definition[i+2].text == "DESC"
+				return []IndexKeyColumn{key}, true
+			}
+			// PRIMARY KEY (column [COLLATE name] [ASC|DESC], ...)
+			open := strings.IndexByte(sql[definition[i+1].end:], '(')
+			if open < 0 {
+				return nil, false
+			}
+			keys, end := parseKeyList(sql, definition[i+1].end+open)
+			return keys, end >= 0
+		}
+	}
+	return nil, false
+}
+
+// autoindexKeys returns the keys of the indexes SQLite builds for the PRIMARY KEY
+// and UNIQUE constraints of a CREATE TABLE statement, in the order it numbers them
+// sqlite_autoindex_<table>_1, _2, ... A primary key aliasing the rowid column
+// rowidAlias gets no index, and a constraint repeating an earlier key shares its index.
+func autoindexKeys(sql string, rowidAlias string) [][]IndexKeyColumn {
+	plain := unquoteIdentifiers(sql)
+	tokens := tokenizeSQL(plain)
+
+	var keys [][]IndexKeyColumn
+	add := func(key []IndexKeyColumn) {
+		for _, existing := range keys {
+			if slices.EqualFunc(existing, key, func(a, b IndexKeyColumn) bool {
+				return strings.EqualFold(a.Name, b.Name) && strings.EqualFold(a.Collation, b.Collation)
+			}) {
+				return
+			}
+		}
+		keys = append(keys, key)
+	}
+	for _, definition := range tableDefinitions(tokens) {
+		if len(definition) == 0 {
+			continue
+		}
+		tableConstraint := tableConstraintKeywords[definition[0].text]
+		for i := range definition {
+			if definition[i].depth != 1 || !primaryKeyAt(definition, i) && definition[i].text != "UNIQUE" {
+				continue
+			}
+			if !tableConstraint {
+				key := IndexKeyColumn{Name: plain[definition[0].start:definition[0].end]}
+				if definition[i].text == "PRIMARY" {
+					if rowidAlias != "" && strings.EqualFold(key.Name, rowidAlias) {
+						continue
+					}
+					key.Desc = i+2 < len(definition) && definition[i+2].depth == 1 && definition[i+2].text == "DESC"
+				}
+				add([]IndexKeyColumn{key})
+				continue
+			}
+			// PRIMARY KEY (...) or UNIQUE (...)
+			open := strings.IndexByte(sql[definition[i].end:], '(')
+			if open < 0 {
+				break
+			}
+			key, end := parseKeyList(sql, definition[i].end+open)
+			if end >= 0 && !(definition[i].text == "PRIMARY" && len(key) == 1 && rowidAlias != "" && strings.EqualFold(key[0].Name, rowidAlias)) {
+				add(key)
+			}
+			break
+		}
+	}
+	return keys
+}
+
+// stripKeyClauses removes the parts of SQLite column and table constraints the
+// MySQL grammar does not accept: constraint names, ON CONFLICT clauses and the
+// sort order of PRIMARY KEY and UNIQUE columns
+func stripKeyClauses(sql string) string {
+	tokens := tokenizeSQL(sql)
+
+	var result strings.Builder
+	copied := 0
+	skip := func(from, to sqlToken) {
+		result.WriteString(sql[copied:from.start])
+		copied = to.end
+	}
+	inKey := -1 // depth of the column list of the PRIMARY KEY or UNIQUE being copied
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.depth <= inKey {
+			inKey = -1
+		}
+		switch {
+		case token.depth < 1:
+		case token.text == "CONSTRAINT" && i+1 < len(tokens) && strings.TrimSpace(sql[token.end:tokens[i+1].start]) == "":
+			skip(token, tokens[i+1])
+			i++
+		case token.text == "ON" && i+2 < len(tokens) && tokens[i+1].text == "CONFLICT":
+			skip(token, tokens[i+2])
+			i += 2
+		case primaryKeyAt(tokens, i) || token.text == "UNIQUE":
+			if token.text == "PRIMARY" {
+				i++
+			}
+			inKey = token.depth
+			if i+1 < len(tokens) && tokens[i+1].depth == token.depth && (tokens[i+1].text == "ASC" || tokens[i+1].text == "DESC") {
+				skip(tokens[i+1], tokens[i+1])
+				i++
+			}
+		case inKey >= 0 && token.depth == inKey+1 && (token.text == "ASC" || token.text == "DESC"):
+			skip(token, token)
+		}
+	}
+	if copied == 0 {
+		return sql
+	}
+	result.WriteString(sql[copied:])
+	return result.String()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// TextEncoding is the encoding a database stores TEXT values in, from offset 56
+// of the database header
+type TextEncoding uint32
+
+const (
+	TextEncodingUTF8    TextEncoding = 1
+	TextEncodingUTF16LE TextEncoding = 2
+	TextEncodingUTF16BE TextEncoding = 3
+)
+
+// String returns the name SQLite's encoding pragma uses for the encoding
+func (e TextEncoding) String() string {
+	switch e {
+	case TextEncodingUTF16LE:
+		return "UTF-16le"
+	case TextEncodingUTF16BE:
+		return "UTF-16be"
+	default:
+		return "UTF-8"
+	}
+}
+
+// isUTF16 reports whether the encoding is one of the UTF-16 byte orders; a header
+// holding zero or an unknown value is read as UTF-8
+func (e TextEncoding) isUTF16() bool {
+	return e == TextEncodingUTF16LE || e == TextEncodingUTF16BE
+}
+
+// byteOrder returns the byte order of a UTF-16 encoding
+func (e TextEncoding) byteOrder() binary.ByteOrder {
+	if e == TextEncodingUTF16BE {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Decode converts TEXT stored in the encoding to UTF-8. A trailing odd byte is
+// dropped and unpaired surrogates become U+FFFD, as SQLite does.
+func (e TextEncoding) Decode(data []byte) []byte {
+	if !e.isUTF16() {
+		return data
+	}
+	order := e.byteOrder()
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	decoded := make([]byte, 0, len(data))
+	for _, r := range utf16.Decode(units) {
+		decoded = utf8.AppendRune(decoded, r)
+	}
+	return decoded
+}
+
+// Compare orders two UTF-8 strings the way memcmp orders them stored in the
+// encoding, which is how the BINARY collation compares TEXT. UTF-16 sorts
+// characters above U+FFFF before U+E000-U+FFFF, and UTF-16le compares the low byte
+// of each code unit first.
+func (e TextEncoding) Compare(a, b string) int {
+	if !e.isUTF16() {
+		return cmp.Compare(a, b)
+	}
+	// the strings order the same in every encoding up to their first difference
+	// when it is between ASCII characters or one string ends there
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) || i == len(b) || a[i] < utf8.RuneSelf && b[i] < utf8.RuneSelf {
+		return cmp.Compare(a[i:], b[i:])
+	}
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] == ub[i] {
+			continue
+		}
+		if e == TextEncodingUTF16LE {
+			return cmp.Compare(ua[i]<<8|ua[i]>>8, ub[i]<<8|ub[i]>>8)
+		}
+		return cmp.Compare(ua[i], ub[i])
+	}
+	return cmp.Compare(len(ua), len(ub))
+}
+
+// binaryCollation returns the BINARY collating sequence for TEXT stored in the
+// encoding
+func (e TextEncoding) binaryCollation() Collation {
+	if e.isUTF16() {
+		return e.Compare
+	}
+	return BinaryCollation
+}
+
+// lookupStoredCollation returns the collating sequence with the given name for
+// TEXT stored in the encoding. Only BINARY depends on the encoding: SQLite runs
+// NOCASE and RTRIM on UTF-8.
+func lookupStoredCollation(name string, encoding TextEncoding) (Collation, error) {
+	if sameCollation(name, "") {
+		return encoding.binaryCollation(), nil
+	}
+	return LookupCollation(name)
+}
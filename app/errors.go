@@ -10,10 +10,12 @@ var (
 	ErrInvalidDatabase    = fmt.Errorf("invalid database file")
 	ErrTableNotFound      = fmt.Errorf("table not found")
 	ErrColumnNotFound     = fmt.Errorf("column not found")
+	ErrRowNotFound        = fmt.Errorf("row not found")
 	ErrInvalidPageType    = fmt.Errorf("invalid page type")
 	ErrInsufficientData   = fmt.Errorf("insufficient data")
 	ErrInvalidCellPointer = fmt.Errorf("invalid cell pointer")
 	ErrInvalidVarint      = fmt.Errorf("invalid varint")
+	ErrHotJournal         = fmt.Errorf("hot journal")
 )
 
 // DatabaseError represents a database-specific error
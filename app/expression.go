@@ -0,0 +1,905 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Evaluator computes the value of a compiled expression for a row
+type Evaluator func(row *Row) (Value, error)
+
+// Predicate reports whether a row satisfies a compiled condition.
+// A condition that evaluates to NULL does not match.
+type Predicate func(row *Row) (bool, error)
+
+// CompiledExpr is an expression resolved against a row schema, ready to be evaluated per row
+type CompiledExpr struct {
+	Eval      Evaluator
+	Affinity  Affinity  // column references carry their declared affinity; other expressions have none
+	Collation Collation // collating sequence for text comparisons, nil for BINARY
+
+	// explicitCollation is set when the collation comes from a COLLATE operator
+	// rather than a column declaration; explicit collations take precedence
+	explicitCollation bool
+}
+
+// ExprCompiler compiles parsed SQL expressions against a row schema
+type ExprCompiler struct {
+	schema     []Column
+	aggregates *AggregateSet  // collects aggregate calls; nil where aggregates are not allowed
+	aliases    []resultColumn // result columns whose aliases names can refer to, after the schema
+}
+
+// NewExprCompiler creates a new expression compiler for rows shaped like schema
+func NewExprCompiler(schema []Column) *ExprCompiler {
+	return &ExprCompiler{schema: schema}
+}
+
+// NewAggregateCompiler creates a compiler for the result expressions of an aggregate
+// query. Aggregate calls are registered in aggregates and evaluate to their slot in
+// the rows produced by the aggregate operator; column references keep their positions.
+func NewAggregateCompiler(schema []Column, aggregates *AggregateSet) *ExprCompiler {
+	return &ExprCompiler{schema: schema, aggregates: aggregates}
+}
+
+// WithAliases returns a compiler that also resolves the aliases of the result
+// columns, as SQLite does in HAVING. A schema column of the same name wins.
+func (c *ExprCompiler) WithAliases(columns []resultColumn) *ExprCompiler {
+	return &ExprCompiler{schema: c.schema, aggregates: c.aggregates, aliases: columns}
+}
+
+// CompilePredicate compiles a boolean condition such as a WHERE clause
+func (c *ExprCompiler) CompilePredicate(expr sqlparser.Expr) (Predicate, error) {
+	compiled, err := c.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(row *Row) (bool, error) {
+		value, err := compiled.Eval(row)
+		if err != nil {
+			return false, err
+		}
+		return truthOfValue(value) == TruthTrue, nil
+	}, nil
+}
+
+// Compile compiles an expression into a reusable evaluator
+func (c *ExprCompiler) Compile(expr sqlparser.Expr) (*CompiledExpr, error) {
+	switch e := expr.(type) {
+	case *sqlparser.ColName:
+		return c.compileColumn(e)
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal:
+		value, err := literalValue(e)
+		if err != nil {
+			return nil, err
+		}
+		return constantExpr(value), nil
+	case *sqlparser.ParenExpr:
+		return c.Compile(e.Expr)
+	case *sqlparser.CollateExpr:
+		return c.compileCollate(e)
+	case *sqlparser.AndExpr:
+		return c.compileLogical(e.Left, e.Right, true)
+	case *sqlparser.OrExpr:
+		return c.compileLogical(e.Left, e.Right, false)
+	case *sqlparser.NotExpr:
+		return c.compileNot(e.Expr)
+	case *sqlparser.ComparisonExpr:
+		return c.compileComparison(e)
+	case *sqlparser.RangeCond:
+		return c.compileBetween(e)
+	case *sqlparser.IsExpr:
+		return c.compileIs(e)
+	case *sqlparser.BinaryExpr:
+		return c.compileBinary(e)
+	case *sqlparser.UnaryExpr:
+		return c.compileUnary(e)
+	case *sqlparser.FuncExpr:
+		return c.compileFunc(e)
+	case *sqlparser.GroupConcatExpr:
+		if len(e.OrderBy) > 0 || e.Separator != "" {
+			return nil, fmt.Errorf("unsupported group_concat() syntax")
+		}
+		return c.compileAggregate("group_concat", e.Distinct != "", e.Exprs)
+	default:
+		return nil, fmt.Errorf("unsupported expression type: %T", expr)
+	}
+}
+
+// compileColumn resolves a column reference (case-insensitively) to its position in the row
+func (c *ExprCompiler) compileColumn(colName *sqlparser.ColName) (*CompiledExpr, error) {
+	col, err := c.resolveColumn(colName)
+	if errors.Is(err, ErrColumnNotFound) && colName.Qualifier.IsEmpty() {
+		for _, column := range c.aliases {
+			if column.alias != "" && strings.EqualFold(column.alias, colName.Name.String()) {
+				// the expression is over the schema, where aliases no longer apply
+				return NewAggregateCompiler(c.schema, c.aggregates).Compile(column.expr)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	collation, err := lookupStoredCollation(col.Collation, col.encoding)
+	if err != nil {
+		return nil, err
+	}
+	index := col.Index
+	return &CompiledExpr{
+		Eval: func(row *Row) (Value, error) {
+			return row.Get(index)
+		},
+		Affinity:  AffinityForType(col.Type),
+		Collation: collation,
+	}, nil
+}
+
+// resolveColumn finds the schema column a possibly qualified name refers to.
+// Columns without a Table match any qualifier, so single-table schemas accept "t.x".
+func (c *ExprCompiler) resolveColumn(colName *sqlparser.ColName) (*Column, error) {
+	columnName := colName.Name.String()
+	qualifier := colName.Qualifier.Name.String()
+
+	var match *Column
+	for pass := 0; pass < 2 && match == nil; pass++ {
+		// real columns shadow the rowid names, which the second pass resolves
+		if pass == 1 && !isRowidName(columnName) {
+			break
+		}
+		for i := range c.schema {
+			col := &c.schema[i]
+			if col.IsRowid != (pass == 1) || pass == 0 && !strings.EqualFold(col.Name, columnName) {
+				continue
+			}
+			if qualifier != "" && col.Table != "" && !strings.EqualFold(col.Table, qualifier) {
+				continue
+			}
+			if qualifier == "" && col.Hidden && !col.IsRowid {
+				continue
+			}
+			if match != nil {
+				return nil, fmt.Errorf("ambiguous column name: %s", columnName)
+			}
+			match = col
+		}
+	}
+	if match == nil {
+		if qualifier != "" {
+			columnName = qualifier + "." + columnName
+		}
+		return nil, NewDatabaseError("compile_column", ErrColumnNotFound, map[string]interface{}{
+			"column_name": columnName,
+		})
+	}
+	return match, nil
+}
+
+// isRowidName reports whether name is one of the names SQLite accepts for the rowid
+func isRowidName(name string) bool {
+	return strings.EqualFold(name, "rowid") || strings.EqualFold(name, "oid") || strings.EqualFold(name, "_rowid_")
+}
+
+// compileCollate compiles "x COLLATE name", which keeps the value and affinity of x
+func (c *ExprCompiler) compileCollate(collate *sqlparser.CollateExpr) (*CompiledExpr, error) {
+	inner, err := c.Compile(collate.Expr)
+	if err != nil {
+		return nil, err
+	}
+	collation, err := LookupCollation(collate.Charset)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledExpr{
+		Eval:              inner.Eval,
+		Affinity:          inner.Affinity,
+		Collation:         collation,
+		explicitCollation: true,
+	}, nil
+}
+
+// comparisonCollation picks the collation of a binary comparison: an explicit COLLATE
+// on either side wins (left first), then the left column's collation, then the right's
+func comparisonCollation(left, right *CompiledExpr) Collation {
+	switch {
+	case left.explicitCollation:
+		return left.Collation
+	case right.explicitCollation:
+		return right.Collation
+	case left.Collation != nil:
+		return left.Collation
+	default:
+		return right.Collation
+	}
+}
+
+// constantExpr wraps a constant value as a compiled expression
+func constantExpr(value Value) *CompiledExpr {
+	return &CompiledExpr{
+		Eval: func(row *Row) (Value, error) {
+			return value, nil
+		},
+		Affinity: AffinityNone,
+	}
+}
+
+// compileLogical compiles AND (isAnd) or OR with short-circuit three-valued logic
+func (c *ExprCompiler) compileLogical(leftExpr, rightExpr sqlparser.Expr, isAnd bool) (*CompiledExpr, error) {
+	left, err := c.Compile(leftExpr)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.Compile(rightExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The value of the left side that decides the result on its own
+	shortCircuit := TruthTrue
+	if isAnd {
+		shortCircuit = TruthFalse
+	}
+
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		leftValue, err := left.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		leftTruth := truthOfValue(leftValue)
+		if leftTruth == shortCircuit {
+			return truthValue(leftTruth), nil
+		}
+
+		rightValue, err := right.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if isAnd {
+			return truthValue(leftTruth.And(truthOfValue(rightValue))), nil
+		}
+		return truthValue(leftTruth.Or(truthOfValue(rightValue))), nil
+	}}, nil
+}
+
+// compileNot compiles logical negation
+func (c *ExprCompiler) compileNot(expr sqlparser.Expr) (*CompiledExpr, error) {
+	inner, err := c.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		value, err := inner.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		return truthValue(truthOfValue(value).Not()), nil
+	}}, nil
+}
+
+// compileComparison compiles =, !=, <, <=, >, >=, LIKE and IN
+func (c *ExprCompiler) compileComparison(comp *sqlparser.ComparisonExpr) (*CompiledExpr, error) {
+	switch comp.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		return c.compileIn(comp)
+	case sqlparser.LikeStr, sqlparser.NotLikeStr:
+		return c.compileLike(comp)
+	}
+
+	if _, err := applyComparisonOperator(0, comp.Operator); err != nil {
+		return nil, err
+	}
+
+	left, err := c.Compile(comp.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.Compile(comp.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	operator := comp.Operator
+	collation := comparisonCollation(left, right)
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		leftValue, err := left.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		rightValue, err := right.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		truth, err := CompareOperands(leftValue, rightValue, left.Affinity, right.Affinity, collation, operator)
+		if err != nil {
+			return nil, err
+		}
+		return truthValue(truth), nil
+	}}, nil
+}
+
+// compileIn compiles "x IN (a, b, ...)" and its negation
+func (c *ExprCompiler) compileIn(comp *sqlparser.ComparisonExpr) (*CompiledExpr, error) {
+	tuple, ok := comp.Right.(sqlparser.ValTuple)
+	if !ok {
+		return nil, fmt.Errorf("unsupported IN operand: %T", comp.Right)
+	}
+
+	left, err := c.Compile(comp.Left)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*CompiledExpr, len(tuple))
+	for i, itemExpr := range tuple {
+		if items[i], err = c.Compile(itemExpr); err != nil {
+			return nil, err
+		}
+	}
+
+	negate := comp.Operator == sqlparser.NotInStr
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		leftValue, err := left.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+
+		// No match is FALSE, unless a NULL was involved, in which case it is unknown
+		result := TruthFalse
+		for _, item := range items {
+			itemValue, err := item.Eval(row)
+			if err != nil {
+				return nil, err
+			}
+			truth, err := CompareOperands(leftValue, itemValue, left.Affinity, item.Affinity, comparisonCollation(left, item), "=")
+			if err != nil {
+				return nil, err
+			}
+			result = result.Or(truth)
+			if result == TruthTrue {
+				break
+			}
+		}
+
+		if negate {
+			result = result.Not()
+		}
+		return truthValue(result), nil
+	}}, nil
+}
+
+// compileLike compiles "x LIKE pattern [ESCAPE e]" and its negation
+func (c *ExprCompiler) compileLike(comp *sqlparser.ComparisonExpr) (*CompiledExpr, error) {
+	left, err := c.Compile(comp.Left)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := c.Compile(comp.Right)
+	if err != nil {
+		return nil, err
+	}
+	var escape *CompiledExpr
+	if comp.Escape != nil {
+		if escape, err = c.Compile(comp.Escape); err != nil {
+			return nil, err
+		}
+	}
+
+	negate := comp.Operator == sqlparser.NotLikeStr
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		leftValue, err := left.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		patternValue, err := pattern.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if StorageClassOf(leftValue) == StorageClassNull || StorageClassOf(patternValue) == StorageClassNull {
+			return NewNullValue(), nil
+		}
+
+		escapeRune := rune(-1)
+		if escape != nil {
+			escapeValue, err := escape.Eval(row)
+			if err != nil {
+				return nil, err
+			}
+			if StorageClassOf(escapeValue) == StorageClassNull {
+				return NewNullValue(), nil
+			}
+			escapeText := escapeValue.String()
+			if utf8.RuneCountInString(escapeText) != 1 {
+				return nil, fmt.Errorf("ESCAPE expression must be a single character")
+			}
+			escapeRune, _ = utf8.DecodeRuneInString(escapeText)
+		}
+
+		matched := likeMatch(patternValue.String(), leftValue.String(), escapeRune)
+		return truthValue(truthOf(matched != negate)), nil
+	}}, nil
+}
+
+// likeMatch implements SQLite's LIKE: case-insensitive for ASCII letters,
+// '%' matches any sequence and '_' matches exactly one character
+func likeMatch(pattern, text string, escape rune) bool {
+	p, t := []rune(pattern), []rune(text)
+	pi, ti := 0, 0
+	starPi, starTi := -1, 0
+
+	for ti < len(t) {
+		if pi < len(p) {
+			switch {
+			case p[pi] == escape && pi+1 < len(p):
+				if foldASCII(p[pi+1]) == foldASCII(t[ti]) {
+					pi += 2
+					ti++
+					continue
+				}
+			case p[pi] == '%':
+				starPi, starTi = pi, ti
+				pi++
+				continue
+			case p[pi] == '_' || foldASCII(p[pi]) == foldASCII(t[ti]):
+				pi++
+				ti++
+				continue
+			}
+		}
+		// Mismatch: let the last '%' absorb one more character
+		if starPi < 0 {
+			return false
+		}
+		starTi++
+		pi, ti = starPi+1, starTi
+	}
+
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// foldASCII lowercases ASCII letters only, as SQLite's LIKE does
+func foldASCII(r rune) rune {
+	if r < utf8.RuneSelf {
+		return unicode.ToLower(r)
+	}
+	return r
+}
+
+// compileBetween compiles "x BETWEEN a AND b" as "x >= a AND x <= b"
+func (c *ExprCompiler) compileBetween(cond *sqlparser.RangeCond) (*CompiledExpr, error) {
+	left, err := c.Compile(cond.Left)
+	if err != nil {
+		return nil, err
+	}
+	from, err := c.Compile(cond.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.Compile(cond.To)
+	if err != nil {
+		return nil, err
+	}
+
+	negate := cond.Operator == sqlparser.NotBetweenStr
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		values := make([]Value, 3)
+		for i, operand := range []*CompiledExpr{left, from, to} {
+			value, err := operand.Eval(row)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+
+		lower, err := CompareOperands(values[0], values[1], left.Affinity, from.Affinity, comparisonCollation(left, from), ">=")
+		if err != nil {
+			return nil, err
+		}
+		upper, err := CompareOperands(values[0], values[2], left.Affinity, to.Affinity, comparisonCollation(left, to), "<=")
+		if err != nil {
+			return nil, err
+		}
+
+		result := lower.And(upper)
+		if negate {
+			result = result.Not()
+		}
+		return truthValue(result), nil
+	}}, nil
+}
+
+// compileIs compiles IS [NOT] NULL and IS [NOT] TRUE/FALSE
+func (c *ExprCompiler) compileIs(is *sqlparser.IsExpr) (*CompiledExpr, error) {
+	inner, err := c.Compile(is.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var test func(value Value) bool
+	switch is.Operator {
+	case sqlparser.IsNullStr:
+		test = func(value Value) bool { return StorageClassOf(value) == StorageClassNull }
+	case sqlparser.IsNotNullStr:
+		test = func(value Value) bool { return StorageClassOf(value) != StorageClassNull }
+	case sqlparser.IsTrueStr:
+		test = func(value Value) bool { return truthOfValue(value) == TruthTrue }
+	case sqlparser.IsNotTrueStr:
+		test = func(value Value) bool { return truthOfValue(value) != TruthTrue }
+	case sqlparser.IsFalseStr:
+		test = func(value Value) bool { return truthOfValue(value) == TruthFalse }
+	case sqlparser.IsNotFalseStr:
+		test = func(value Value) bool { return truthOfValue(value) != TruthFalse }
+	default:
+		return nil, fmt.Errorf("unsupported IS operator: %s", is.Operator)
+	}
+
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		value, err := inner.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		return truthValue(truthOf(test(value))), nil
+	}}, nil
+}
+
+// compileBinary compiles arithmetic, bitwise and concatenation operators
+func (c *ExprCompiler) compileBinary(binary *sqlparser.BinaryExpr) (*CompiledExpr, error) {
+	left, err := c.Compile(binary.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.Compile(binary.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	var apply func(a, b Value) Value
+	switch binary.Operator {
+	case concatOperator:
+		apply = concatValues
+	case sqlparser.PlusStr, sqlparser.MinusStr, sqlparser.MultStr, sqlparser.DivStr, sqlparser.ModStr:
+		operator := binary.Operator
+		apply = func(a, b Value) Value { return arithmetic(operator, a, b) }
+	case sqlparser.BitAndStr, sqlparser.BitOrStr, sqlparser.ShiftLeftStr, sqlparser.ShiftRightStr:
+		operator := binary.Operator
+		apply = func(a, b Value) Value { return bitwise(operator, a, b) }
+	default:
+		return nil, fmt.Errorf("unsupported binary operator: %s", binary.Operator)
+	}
+
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		leftValue, err := left.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		rightValue, err := right.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if StorageClassOf(leftValue) == StorageClassNull || StorageClassOf(rightValue) == StorageClassNull {
+			return NewNullValue(), nil
+		}
+		return apply(leftValue, rightValue), nil
+	}}, nil
+}
+
+// compileUnary compiles unary minus, unary plus, bitwise NOT and '!'
+func (c *ExprCompiler) compileUnary(unary *sqlparser.UnaryExpr) (*CompiledExpr, error) {
+	if unary.Operator == sqlparser.BangStr {
+		return c.compileNot(unary.Expr)
+	}
+
+	inner, err := c.Compile(unary.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var apply func(value Value) Value
+	switch unary.Operator {
+	case sqlparser.UPlusStr:
+		// Unary plus is a no-op that also strips column affinity
+		apply = func(value Value) Value { return value }
+	case sqlparser.UMinusStr:
+		apply = negate
+	case sqlparser.TildaStr:
+		apply = func(value Value) Value { return NewIntegerValue(^toInteger(value)) }
+	default:
+		return nil, fmt.Errorf("unsupported unary operator: %s", unary.Operator)
+	}
+
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		value, err := inner.Eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if StorageClassOf(value) == StorageClassNull {
+			return NewNullValue(), nil
+		}
+		return apply(value), nil
+	}}, nil
+}
+
+// compileFunc compiles a function call; aggregates are delegated to compileAggregate
+func (c *ExprCompiler) compileFunc(fn *sqlparser.FuncExpr) (*CompiledExpr, error) {
+	name := fn.Name.Lowered()
+	if isAggregateFunction(name, len(fn.Exprs)) {
+		return c.compileAggregate(name, fn.Distinct, fn.Exprs)
+	}
+	if name != "min" && name != "max" {
+		return nil, fmt.Errorf("no such function: %s", name)
+	}
+
+	// Scalar min() and max() return NULL if any argument is NULL
+	args := make([]*CompiledExpr, len(fn.Exprs))
+	for i, selectExpr := range fn.Exprs {
+		arg, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, fmt.Errorf("wrong number of arguments to function %s()", name)
+		}
+		var err error
+		if args[i], err = c.Compile(arg.Expr); err != nil {
+			return nil, err
+		}
+	}
+	collation := args[0].Collation
+	return &CompiledExpr{Eval: func(row *Row) (Value, error) {
+		var best Value
+		for _, arg := range args {
+			value, err := arg.Eval(row)
+			if err != nil {
+				return nil, err
+			}
+			if StorageClassOf(value) == StorageClassNull {
+				return NewNullValue(), nil
+			}
+			if best == nil {
+				best = value
+				continue
+			}
+			order := CompareCollated(value, best, collation)
+			if (name == "max" && order > 0) || (name == "min" && order < 0) {
+				best = value
+			}
+		}
+		return best, nil
+	}}, nil
+}
+
+// truthOfValue interprets a value in a boolean context: NULL is unknown, numbers are true when non-zero
+func truthOfValue(value Value) Truth {
+	switch StorageClassOf(value) {
+	case StorageClassNull:
+		return TruthNull
+	case StorageClassInteger:
+		i, _ := value.Int64()
+		return truthOf(i != 0)
+	default:
+		f, _ := toNumeric(value).Float64()
+		return truthOf(f != 0)
+	}
+}
+
+// truthValue converts a Truth into the INTEGER 1/0 or NULL value SQLite produces for boolean expressions
+func truthValue(truth Truth) Value {
+	switch truth {
+	case TruthTrue:
+		return NewIntegerValue(1)
+	case TruthFalse:
+		return NewIntegerValue(0)
+	default:
+		return NewNullValue()
+	}
+}
+
+// toNumeric converts a value to INTEGER or REAL the way arithmetic operators do:
+// text and blobs use their longest numeric prefix, or 0 if there is none
+func toNumeric(value Value) Value {
+	switch StorageClassOf(value) {
+	case StorageClassInteger, StorageClassReal:
+		return value
+	case StorageClassNull:
+		return NewIntegerValue(0)
+	default:
+		return numericPrefix(string(value.Raw()))
+	}
+}
+
+// numericPrefix parses the longest prefix of text that forms a number
+func numericPrefix(text string) Value {
+	text = strings.TrimLeft(text, " \t\n\r\f\v")
+
+	end := 0
+	if end < len(text) && (text[end] == '+' || text[end] == '-') {
+		end++
+	}
+	digitsStart := end
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	isReal := false
+	if end < len(text) && text[end] == '.' {
+		isReal = true
+		end++
+		for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+			end++
+		}
+	}
+	if end == digitsStart || (isReal && end == digitsStart+1) {
+		return NewIntegerValue(0)
+	}
+	if end < len(text) && (text[end] == 'e' || text[end] == 'E') {
+		exp := end + 1
+		if exp < len(text) && (text[exp] == '+' || text[exp] == '-') {
+			exp++
+		}
+		if exp < len(text) && text[exp] >= '0' && text[exp] <= '9' {
+			isReal = true
+			for end = exp; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+			}
+		}
+	}
+
+	if !isReal {
+		if i, err := strconv.ParseInt(text[:end], 10, 64); err == nil {
+			return NewIntegerValue(i)
+		}
+	}
+	f, _ := strconv.ParseFloat(text[:end], 64)
+	return NewRealValue(f)
+}
+
+// toInteger converts a value to a 64-bit integer, truncating REALs
+func toInteger(value Value) int64 {
+	number := toNumeric(value)
+	if StorageClassOf(number) == StorageClassInteger {
+		i, _ := number.Int64()
+		return i
+	}
+	f, _ := number.Float64()
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	case f <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(f)
+}
+
+// arithmetic applies +, -, *, / or % with SQLite semantics: integer math that
+// falls back to REAL on overflow, and NULL on division by zero
+func arithmetic(operator string, a, b Value) Value {
+	a, b = toNumeric(a), toNumeric(b)
+
+	if StorageClassOf(a) == StorageClassInteger && StorageClassOf(b) == StorageClassInteger {
+		x, _ := a.Int64()
+		y, _ := b.Int64()
+		if result, ok := integerArithmetic(operator, x, y); ok {
+			return result
+		}
+	}
+
+	x, _ := a.Float64()
+	y, _ := b.Float64()
+	switch operator {
+	case sqlparser.PlusStr:
+		return NewRealValue(x + y)
+	case sqlparser.MinusStr:
+		return NewRealValue(x - y)
+	case sqlparser.MultStr:
+		return NewRealValue(x * y)
+	case sqlparser.DivStr:
+		if y == 0 {
+			return NewNullValue()
+		}
+		return NewRealValue(x / y)
+	default:
+		// REAL remainder operates on the integer parts of its operands
+		ix, iy := toInteger(a), toInteger(b)
+		if iy == 0 {
+			return NewNullValue()
+		}
+		if iy == -1 {
+			return NewRealValue(0)
+		}
+		return NewRealValue(float64(ix % iy))
+	}
+}
+
+// integerArithmetic performs integer arithmetic; ok is false when the result overflows
+func integerArithmetic(operator string, x, y int64) (Value, bool) {
+	switch operator {
+	case sqlparser.PlusStr:
+		sum := x + y
+		if (x >= 0) == (y >= 0) && (sum >= 0) != (x >= 0) {
+			return nil, false
+		}
+		return NewIntegerValue(sum), true
+	case sqlparser.MinusStr:
+		diff := x - y
+		if (x >= 0) != (y >= 0) && (diff >= 0) != (x >= 0) {
+			return nil, false
+		}
+		return NewIntegerValue(diff), true
+	case sqlparser.MultStr:
+		if x != 0 && y != 0 {
+			product := x * y
+			if product/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+				return nil, false
+			}
+			return NewIntegerValue(product), true
+		}
+		return NewIntegerValue(0), true
+	case sqlparser.DivStr:
+		if y == 0 {
+			return NewNullValue(), true
+		}
+		if x == math.MinInt64 && y == -1 {
+			return nil, false
+		}
+		return NewIntegerValue(x / y), true
+	default:
+		if y == 0 {
+			return NewNullValue(), true
+		}
+		if y == -1 {
+			return NewIntegerValue(0), true
+		}
+		return NewIntegerValue(x % y), true
+	}
+}
+
+// bitwise applies &, |, << or >> to the integer values of its operands
+func bitwise(operator string, a, b Value) Value {
+	x, y := toInteger(a), toInteger(b)
+	switch operator {
+	case sqlparser.BitAndStr:
+		return NewIntegerValue(x & y)
+	case sqlparser.BitOrStr:
+		return NewIntegerValue(x | y)
+	}
+
+	// A negative shift amount shifts in the opposite direction
+	if operator == sqlparser.ShiftRightStr {
+		y = -y
+	}
+	switch {
+	case y >= 64:
+		return NewIntegerValue(0)
+	case y >= 0:
+		return NewIntegerValue(x << uint(y))
+	case y <= -64:
+		if x < 0 {
+			return NewIntegerValue(-1)
+		}
+		return NewIntegerValue(0)
+	default:
+		return NewIntegerValue(x >> uint(-y))
+	}
+}
+
+// negate applies unary minus
+func negate(value Value) Value {
+	number := toNumeric(value)
+	if StorageClassOf(number) == StorageClassInteger {
+		i, _ := number.Int64()
+		if i != math.MinInt64 {
+			return NewIntegerValue(-i)
+		}
+	}
+	f, _ := number.Float64()
+	return NewRealValue(-f)
+}
+
+// concatValues implements the || operator on the text forms of both operands
+func concatValues(a, b Value) Value {
+	return NewTextValue(a.String() + b.String())
+}
@@ -3,140 +3,597 @@ package main
 import (
 	"fmt"
 	"io"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
 )
 
-// OutputFormatter handles different output formats
-type OutputFormatter interface {
-	FormatValue(value Value) string
-	FormatRow(row *Row, schema []*Column) string
-	FormatTable(rows []*Row, schema []*Column) string
-	FormatCount(count int) string
+// OutputModes are the modes of the .mode command
+var OutputModes = []string{"box", "column", "csv", "html", "insert", "json", "jsonl", "line", "list", "markdown", "quote", "table", "tabs", "tsv"}
+
+// OutputSettings control how query results are written, as the sqlite3 shell's
+// .mode, .headers, .separator and .nullvalue commands do
+type OutputSettings struct {
+	Mode       string
+	Headers    bool
+	HeadersSet bool   // .headers was given, so .mode column leaves Headers alone
+	ColSep     string // written between the values of a row
+	RowSep     string // written after each row
+	NullValue  string // written for NULL
+	Table      string // table named by the statements of insert mode
 }
 
-// ConsoleFormatter formats output for console display
-type ConsoleFormatter struct {
-	io.Writer
+// DefaultOutputSettings returns the settings the shell starts with: list mode,
+// without headers
+func DefaultOutputSettings() OutputSettings {
+	return OutputSettings{Mode: "list", ColSep: "|", RowSep: "\n", Table: "table"}
 }
 
-// NewConsoleFormatter creates a new console formatter
-func NewConsoleFormatter(writer io.Writer) *ConsoleFormatter {
-	return &ConsoleFormatter{Writer: writer}
+// SetMode switches to an output mode and resets the separators to its own.
+// Insert mode takes the table name from table when it is given.
+func (s *OutputSettings) SetMode(mode, table string) error {
+	mode = strings.ToLower(mode)
+	if !slices.Contains(OutputModes, mode) {
+		return fmt.Errorf("mode should be one of: %s", strings.Join(OutputModes, " "))
+	}
+	s.Mode = mode
+	switch mode {
+	case "csv":
+		s.ColSep, s.RowSep = ",", "\r\n"
+	case "tabs", "tsv":
+		s.ColSep, s.RowSep = "\t", "\n"
+	case "quote":
+		s.ColSep, s.RowSep = ",", "\n"
+	case "list":
+		s.ColSep, s.RowSep = "|", "\n"
+	case "column":
+		if !s.HeadersSet {
+			s.Headers = true
+		}
+	case "insert":
+		s.Table = "table"
+		if table != "" {
+			s.Table = table
+		}
+	}
+	return nil
 }
 
-// FormatValue formats a single value
-func (cf *ConsoleFormatter) FormatValue(value Value) string {
-	if value == nil {
-		return ""
+// OutputFormatter writes the rows of a query result. Begin is given the column
+// names, then WriteRow each row, and End finishes the output. Nothing is written
+// for a result without rows.
+type OutputFormatter interface {
+	Begin(columns []string) error
+	WriteRow(values []Value) error
+	End() error
+}
+
+// NewOutputFormatter creates the formatter of the output mode of settings
+func NewOutputFormatter(w io.Writer, settings OutputSettings) OutputFormatter {
+	switch settings.Mode {
+	case "csv":
+		return &ListFormatter{w: w, settings: settings, value: settings.csvValue, header: settings.csvField}
+	case "quote":
+		return &ListFormatter{w: w, settings: settings, value: quoteValue, header: quoteText}
+	case "json", "jsonl":
+		return &JSONFormatter{w: w, lines: settings.Mode == "jsonl"}
+	case "line":
+		return &LineFormatter{w: w, settings: settings}
+	case "html":
+		return &HTMLFormatter{w: w, settings: settings}
+	case "insert":
+		return &InsertFormatter{w: w, settings: settings}
+	case "column", "box", "table", "markdown":
+		return &GridFormatter{w: w, settings: settings}
+	default:
+		return &ListFormatter{w: w, settings: settings, value: settings.textValue, header: func(name string) string { return name }}
 	}
-	return value.String()
 }
 
-// FormatRow formats a single row
-func (cf *ConsoleFormatter) FormatRow(row *Row, schema []*Column) string {
-	if row == nil {
-		return ""
+// textValue renders a value as plain text, with the null value for NULL
+func (s OutputSettings) textValue(value Value) string {
+	if value == nil || value.Type() == ValueTypeNull {
+		return s.NullValue
 	}
+	return value.String()
+}
+
+// ListFormatter writes each row on its own line with separators between the
+// values, as the list, tabs, csv and quote modes do; they differ in how values
+// and headers are written
+type ListFormatter struct {
+	w        io.Writer
+	settings OutputSettings
+	value    func(Value) string
+	header   func(string) string
+	columns  []string
+	rows     int
+}
 
-	var parts []string
-	for i, value := range row.Values {
-		if i < len(schema) {
-			parts = append(parts, cf.FormatValue(value))
+// Begin remembers the column names for the header
+func (f *ListFormatter) Begin(columns []string) error {
+	f.columns = columns
+	return nil
+}
+
+// WriteRow writes a row, preceded by the header if it is the first
+func (f *ListFormatter) WriteRow(values []Value) error {
+	fields := make([]string, 0, len(values))
+	if f.rows == 0 && f.settings.Headers {
+		for _, name := range f.columns {
+			fields = append(fields, f.header(name))
+		}
+		if _, err := io.WriteString(f.w, strings.Join(fields, f.settings.ColSep)+f.settings.RowSep); err != nil {
+			return err
 		}
+		fields = fields[:0]
+	}
+	f.rows++
+	for _, value := range values {
+		fields = append(fields, f.value(value))
 	}
+	_, err := io.WriteString(f.w, strings.Join(fields, f.settings.ColSep)+f.settings.RowSep)
+	return err
+}
 
-	return strings.Join(parts, "\t")
+// End has nothing left to write
+func (f *ListFormatter) End() error {
+	return nil
 }
 
-// FormatTable formats multiple rows as a table
-func (cf *ConsoleFormatter) FormatTable(rows []*Row, schema []*Column) string {
-	if len(rows) == 0 {
-		return ""
+// csvValue renders a value for csv mode: numbers as they are, text and blobs as
+// fields that are quoted when needed
+func (s OutputSettings) csvValue(value Value) string {
+	switch StorageClassOf(value) {
+	case StorageClassNull:
+		return s.NullValue
+	case StorageClassInteger, StorageClassReal:
+		return value.String()
+	default:
+		return s.csvField(value.String())
 	}
+}
 
-	var result strings.Builder
-
-	// Header
-	var headers []string
-	for _, col := range schema {
-		headers = append(headers, col.Name)
+// csvField double-quotes a field that is empty or holds the separator, quotes,
+// spaces, control characters or non-ASCII bytes, as the sqlite3 shell does
+func (s OutputSettings) csvField(field string) string {
+	quote := field == "" || s.ColSep != "" && strings.Contains(field, s.ColSep)
+	for i := 0; i < len(field) && !quote; i++ {
+		c := field[i]
+		quote = c <= ' ' || c == '"' || c == '\'' || c >= 0x7f
+	}
+	if !quote {
+		return field
 	}
-	result.WriteString(strings.Join(headers, "\t"))
-	result.WriteString("\n")
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
 
-	// Rows
-	for _, row := range rows {
-		result.WriteString(cf.FormatRow(row, schema))
-		result.WriteString("\n")
+// quoteValue renders a value as an SQL literal
+func quoteValue(value Value) string {
+	switch StorageClassOf(value) {
+	case StorageClassNull:
+		return "NULL"
+	case StorageClassReal:
+		f, _ := value.Float64()
+		if math.IsNaN(f) {
+			return "NULL"
+		}
+		return exactReal(f)
+	case StorageClassText:
+		return quoteText(value.String())
+	case StorageClassBlob:
+		return fmt.Sprintf("X'%x'", value.Raw())
+	default:
+		return value.String()
 	}
+}
 
-	return result.String()
+// quoteText renders text as an SQL string literal
+func quoteText(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", "''") + "'"
 }
 
-// FormatCount formats a count result
-func (cf *ConsoleFormatter) FormatCount(count int) string {
-	return fmt.Sprintf("%d", count)
+// exactReal renders a REAL so that reading it back gives the same value: whole
+// numbers as integers with ".0", others with as many digits as they need, and
+// infinities as the out of range literals SQLite reads as infinite
+func exactReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "9.0e+999"
+	case math.IsInf(f, -1):
+		return "-9.0e+999"
+	case f == math.Trunc(f) && math.Abs(f) < 1<<63:
+		return strconv.FormatInt(int64(f), 10) + ".0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	mantissa, exponent, _ := strings.Cut(s, "e")
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	if exponent != "" {
+		return mantissa + "e" + exponent
+	}
+	return mantissa
+}
+
+// quoteIdentifier double-quotes a name that is a keyword or not a plain identifier
+func quoteIdentifier(name string) string {
+	if sqlparser.String(sqlparser.NewTableIdent(name)) == name {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
-// JSONFormatter formats output as JSON (placeholder for future use)
+// JSONFormatter writes rows as JSON objects keyed by column name: an array of
+// them in json mode, or one per line in jsonl mode
 type JSONFormatter struct {
-	io.Writer
+	w       io.Writer
+	lines   bool
+	columns []string
+	rows    int
 }
 
-// NewJSONFormatter creates a new JSON formatter
-func NewJSONFormatter(writer io.Writer) *JSONFormatter {
-	return &JSONFormatter{Writer: writer}
+// Begin remembers the column names for the object keys
+func (f *JSONFormatter) Begin(columns []string) error {
+	f.columns = columns
+	return nil
 }
 
-// FormatValue formats a single value as JSON
-func (jf *JSONFormatter) FormatValue(value Value) string {
-	if value == nil {
-		return "null"
+// WriteRow writes a row as an object
+func (f *JSONFormatter) WriteRow(values []Value) error {
+	var b strings.Builder
+	switch {
+	case f.lines:
+	case f.rows == 0:
+		b.WriteString("[")
+	default:
+		b.WriteString(",\n")
 	}
+	f.rows++
+	b.WriteString("{")
+	for i, value := range values {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(jsonString(f.columns[i], false))
+		b.WriteString(":")
+		b.WriteString(jsonValue(value))
+	}
+	b.WriteString("}")
+	if f.lines {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(f.w, b.String())
+	return err
+}
+
+// End closes the array of json mode
+func (f *JSONFormatter) End() error {
+	if f.lines || f.rows == 0 {
+		return nil
+	}
+	_, err := io.WriteString(f.w, "]\n")
+	return err
+}
 
-	switch value.Type() {
-	case ValueTypeText:
-		return fmt.Sprintf(`"%s"`, strings.ReplaceAll(value.String(), `"`, `\"`))
-	case ValueTypeNull:
+// jsonValue renders a value as JSON: numbers as numbers, text and blobs as
+// strings, and NULL as null
+func jsonValue(value Value) string {
+	switch StorageClassOf(value) {
+	case StorageClassNull:
 		return "null"
+	case StorageClassReal:
+		f, _ := value.Float64()
+		if math.IsNaN(f) {
+			return "null"
+		}
+		return exactReal(f)
+	case StorageClassText:
+		return jsonString(value.String(), false)
+	case StorageClassBlob:
+		return jsonString(string(value.Raw()), true)
 	default:
 		return value.String()
 	}
 }
 
-// FormatRow formats a single row as JSON
-func (jf *JSONFormatter) FormatRow(row *Row, schema []*Column) string {
-	if row == nil {
-		return "{}"
+// jsonString renders s as a JSON string. A blob is taken byte by byte, each byte
+// standing for the character with its code, so any bytes make valid JSON.
+func jsonString(s string, bytewise bool) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	write := func(r rune) {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 || bytewise && r >= 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	if bytewise {
+		for i := 0; i < len(s); i++ {
+			write(rune(s[i]))
+		}
+	} else {
+		for _, r := range s {
+			write(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// LineFormatter writes each value on its own line after its right-aligned column
+// name, with a blank line between rows
+type LineFormatter struct {
+	w        io.Writer
+	settings OutputSettings
+	columns  []string
+	width    int
+	rows     int
+}
+
+// Begin measures the column names
+func (f *LineFormatter) Begin(columns []string) error {
+	f.columns = columns
+	f.width = 5
+	for _, name := range columns {
+		f.width = max(f.width, utf8.RuneCountInString(name))
+	}
+	return nil
+}
+
+// WriteRow writes a row as "name = value" lines
+func (f *LineFormatter) WriteRow(values []Value) error {
+	var b strings.Builder
+	if f.rows > 0 {
+		b.WriteString("\n")
 	}
+	f.rows++
+	for i, value := range values {
+		fmt.Fprintf(&b, "%s%s = %s\n", strings.Repeat(" ", f.width-utf8.RuneCountInString(f.columns[i])), f.columns[i], f.settings.textValue(value))
+	}
+	_, err := io.WriteString(f.w, b.String())
+	return err
+}
+
+// End has nothing left to write
+func (f *LineFormatter) End() error {
+	return nil
+}
+
+// HTMLFormatter writes rows as HTML table rows, without the enclosing table
+type HTMLFormatter struct {
+	w        io.Writer
+	settings OutputSettings
+	columns  []string
+	rows     int
+}
 
-	var pairs []string
-	for i, value := range row.Values {
-		if i < len(schema) {
-			key := fmt.Sprintf(`"%s"`, schema[i].Name)
-			val := jf.FormatValue(value)
-			pairs = append(pairs, fmt.Sprintf(`%s: %s`, key, val))
+// Begin remembers the column names for the header
+func (f *HTMLFormatter) Begin(columns []string) error {
+	f.columns = columns
+	return nil
+}
+
+// WriteRow writes a TR of TD cells, preceded by a TR of TH cells for the header
+func (f *HTMLFormatter) WriteRow(values []Value) error {
+	var b strings.Builder
+	if f.rows == 0 && f.settings.Headers {
+		b.WriteString("<TR>")
+		for _, name := range f.columns {
+			fmt.Fprintf(&b, "<TH>%s</TH>\n", htmlEscape(name))
 		}
+		b.WriteString("</TR>\n")
 	}
+	f.rows++
+	b.WriteString("<TR>")
+	for _, value := range values {
+		fmt.Fprintf(&b, "<TD>%s</TD>\n", htmlEscape(f.settings.textValue(value)))
+	}
+	b.WriteString("</TR>\n")
+	_, err := io.WriteString(f.w, b.String())
+	return err
+}
+
+// End has nothing left to write
+func (f *HTMLFormatter) End() error {
+	return nil
+}
+
+// htmlEscaper escapes the characters that are special in HTML text and attributes
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&#39;")
 
-	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+// htmlEscape escapes text for HTML
+func htmlEscape(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// InsertFormatter writes each row as an INSERT statement into the settings' table,
+// listing the columns when headers are on
+type InsertFormatter struct {
+	w        io.Writer
+	settings OutputSettings
+	prefix   string
+}
+
+// Begin prepares the start of the statements
+func (f *InsertFormatter) Begin(columns []string) error {
+	f.prefix = "INSERT INTO " + quoteIdentifier(f.settings.Table)
+	if f.settings.Headers {
+		names := make([]string, len(columns))
+		for i, name := range columns {
+			names[i] = quoteIdentifier(name)
+		}
+		f.prefix += "(" + strings.Join(names, ",") + ")"
+	}
+	f.prefix += " VALUES("
+	return nil
 }
 
-// FormatTable formats multiple rows as JSON array
-func (jf *JSONFormatter) FormatTable(rows []*Row, schema []*Column) string {
-	if len(rows) == 0 {
-		return "[]"
+// WriteRow writes a row as an INSERT statement
+func (f *InsertFormatter) WriteRow(values []Value) error {
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = quoteValue(value)
 	}
+	_, err := io.WriteString(f.w, f.prefix+strings.Join(literals, ",")+");\n")
+	return err
+}
+
+// End has nothing left to write
+func (f *InsertFormatter) End() error {
+	return nil
+}
+
+// GridFormatter lines rows up in columns as wide as their name and widest value,
+// as the column, box, table and markdown modes do. It holds the rows until End,
+// which knows the widths. Values spanning lines continue on the lines below.
+type GridFormatter struct {
+	w        io.Writer
+	settings OutputSettings
+	columns  []string
+	rows     [][]string
+}
+
+// Begin remembers the column names for the header
+func (f *GridFormatter) Begin(columns []string) error {
+	f.columns = columns
+	return nil
+}
 
-	var rowStrings []string
-	for _, row := range rows {
-		rowStrings = append(rowStrings, jf.FormatRow(row, schema))
+// WriteRow keeps a row until the widths are known
+func (f *GridFormatter) WriteRow(values []Value) error {
+	cells := make([]string, len(values))
+	for i, value := range values {
+		cells[i] = f.settings.textValue(value)
 	}
+	f.rows = append(f.rows, cells)
+	return nil
+}
+
+// gridStyle is the frame a grid mode draws around and between cells
+type gridStyle struct {
+	open, sep, close string    // around and between the cells of a line
+	padding          int       // spaces on each side of the cells, counted in rules
+	top, middle      [4]string // rules: start, fill, junction and end; no fill for none
+	bottom           [4]string
+	rowRule          bool // middle rule between rows when a value spans lines
+	center           bool // center the header
+}
 
-	return fmt.Sprintf("[%s]", strings.Join(rowStrings, ", "))
+// gridStyles are the frames of the grid modes
+var gridStyles = map[string]gridStyle{
+	"column": {sep: "  ", middle: [4]string{"", "-", "  ", ""}},
+	"box": {open: "│ ", sep: " │ ", close: " │", padding: 1, rowRule: true, center: true,
+		top: [4]string{"┌", "─", "┬", "┐"}, middle: [4]string{"├", "─", "┼", "┤"}, bottom: [4]string{"└", "─", "┴", "┘"}},
+	"table": {open: "| ", sep: " | ", close: " |", padding: 1, rowRule: true, center: true,
+		top: [4]string{"+", "-", "+", "+"}, middle: [4]string{"+", "-", "+", "+"}, bottom: [4]string{"+", "-", "+", "+"}},
+	"markdown": {open: "| ", sep: " | ", close: " |", padding: 1, center: true,
+		middle: [4]string{"|", "-", "|", "|"}},
 }
 
-// FormatCount formats a count result as JSON
-func (jf *JSONFormatter) FormatCount(count int) string {
-	return fmt.Sprintf(`{"count": %d}`, count)
+// End writes the rows lined up, with the header on top
+func (f *GridFormatter) End() error {
+	if len(f.rows) == 0 {
+		return nil
+	}
+	style := gridStyles[f.settings.Mode]
+	headers := f.settings.Headers || f.settings.Mode != "column"
+
+	widths := make([]int, len(f.columns))
+	multiline := false
+	for i, name := range f.columns {
+		widths[i] = utf8.RuneCountInString(name)
+	}
+	for _, row := range f.rows {
+		for i, cell := range row {
+			for line := range strings.SplitSeq(cell, "\n") {
+				widths[i] = max(widths[i], utf8.RuneCountInString(line))
+			}
+			multiline = multiline || strings.Contains(cell, "\n")
+		}
+	}
+
+	var b strings.Builder
+	rule := func(parts [4]string) {
+		if parts[1] == "" {
+			return
+		}
+		b.WriteString(parts[0])
+		for i, width := range widths {
+			if i > 0 {
+				b.WriteString(parts[2])
+			}
+			b.WriteString(strings.Repeat(parts[1], width+2*style.padding))
+		}
+		b.WriteString(parts[3] + "\n")
+	}
+	line := func(cells []string, center bool) {
+		lines := make([][]string, len(cells))
+		height := 1
+		for i, cell := range cells {
+			lines[i] = strings.Split(cell, "\n")
+			height = max(height, len(lines[i]))
+		}
+		for n := range height {
+			b.WriteString(style.open)
+			for i, width := range widths {
+				if i > 0 {
+					b.WriteString(style.sep)
+				}
+				text := ""
+				if n < len(lines[i]) {
+					text = lines[i][n]
+				}
+				pad := width - utf8.RuneCountInString(text)
+				left := 0
+				if center {
+					left = pad / 2
+				}
+				b.WriteString(strings.Repeat(" ", left) + text + strings.Repeat(" ", pad-left))
+			}
+			b.WriteString(style.close + "\n")
+		}
+	}
+
+	rule(style.top)
+	if headers {
+		line(f.columns, style.center)
+		rule(style.middle)
+	}
+	for i, row := range f.rows {
+		if i > 0 && multiline {
+			switch {
+			case style.rowRule:
+				rule(style.middle)
+			case f.settings.Mode == "column":
+				b.WriteString("\n")
+			}
+		}
+		line(row, false)
+	}
+	rule(style.bottom)
+	_, err := io.WriteString(f.w, b.String())
+	return err
 }
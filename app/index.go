@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"iter"
 )
 
 // IndexImpl implements Index interface
@@ -24,26 +25,45 @@ func NewIndex(indexRaw IndexRaw, schema *SchemaRecord) *IndexImpl {
 // GetSchema returns the column schema for the index
 func (i *IndexImpl) GetSchema(ctx context.Context) ([]Column, error) {
 	// For indices, we need to parse the indexed columns from the CREATE INDEX SQL
-	columns := make([]Column, len(i.indexRaw.GetIndexedColumns()))
-	for idx, colName := range i.indexRaw.GetIndexedColumns() {
+	columns := make([]Column, len(i.indexRaw.GetKeyColumns()))
+	for idx, key := range i.indexRaw.GetKeyColumns() {
 		columns[idx] = Column{
-			Name:  colName,
-			Type:  "TEXT", // Default to TEXT for simplicity
-			Index: idx,
+			Name:      key.Name,
+			Type:      "TEXT", // Default to TEXT for simplicity
+			Index:     idx,
+			Collation: key.Collation,
+			encoding:  key.encoding,
 		}
 	}
 	return columns, nil
 }
 
+// GetKeyColumns returns the key columns of the index with their sort order and collation
+func (i *IndexImpl) GetKeyColumns() []IndexKeyColumn {
+	return i.indexRaw.GetKeyColumns()
+}
+
+// IsPartial reports whether the index covers only the rows matching its WHERE clause
+func (i *IndexImpl) IsPartial() bool {
+	return i.indexRaw.IsPartial()
+}
+
+// IsUnique reports whether no two entries of the index share a key
+func (i *IndexImpl) IsUnique() bool {
+	return i.indexRaw.IsUnique()
+}
 
 // Count returns the number of entries in the index
 func (i *IndexImpl) Count(ctx context.Context) (int, error) {
-	cells, err := i.indexRaw.ReadAllCells(ctx)
-	if err != nil {
-		return 0, fmt.Errorf("count rows for index %s: %w", i.schema.Name, err)
+	count := 0
+	for _, err := range i.indexRaw.Cells(ctx) {
+		if err != nil {
+			return 0, fmt.Errorf("count rows for index %s: %w", i.schema.Name, err)
+		}
+		count++
 	}
 
-	return len(cells), nil
+	return count, nil
 }
 
 // GetName returns the index name
@@ -62,3 +82,14 @@ func (i *IndexImpl) SearchByKey(ctx context.Context, key interface{}) ([]IndexEn
 }
 
 
+
+// Scan streams the index entries in key order
+func (i *IndexImpl) Scan(ctx context.Context) iter.Seq2[*IndexEntry, error] {
+	return i.indexRaw.ScanEntries(ctx, nil)
+}
+
+// ScanFrom streams the index entries in key order, starting at the first entry
+// whose key is not smaller than key, a single value or an IndexKey prefix
+func (i *IndexImpl) ScanFrom(ctx context.Context, key interface{}) iter.Seq2[*IndexEntry, error] {
+	return i.indexRaw.ScanEntries(ctx, key)
+}
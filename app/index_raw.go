@@ -3,37 +3,77 @@ package main
 import (
 	"context"
 	"fmt"
+	"iter"
+	"slices"
 	"strings"
 )
 
 // IndexRawImpl implements IndexRaw interface for raw SQLite index operations
 type IndexRawImpl struct {
-	dbRaw          DatabaseRaw
-	name           string
-	rootPage       int
-	indexedColumns []string // columns that this index covers
-	tableName      string   // table this index belongs to
+	dbRaw      DatabaseRaw
+	name       string
+	rootPage   int
+	keyColumns []IndexKeyColumn // columns that this index covers, in key order
+	partial    bool             // the index has a WHERE clause and holds only some rows
+	unique     bool             // no two entries share a key
+	tableName  string           // table this index belongs to
 }
 
 // NewIndexRaw creates a new raw index instance
 func NewIndexRaw(dbRaw DatabaseRaw, name string, rootPage int, schema *SchemaRecord) *IndexRawImpl {
-	indexedColumns := parseIndexColumns(schema.SQL)
+	keyColumns, partial := parseIndexDefinition(schema.SQL)
+	for i := range keyColumns {
+		keyColumns[i].encoding = TextEncoding(dbRaw.GetHeader().TextEncoding)
+	}
+	fields := strings.Fields(schema.SQL)
 	tableName := parseIndexTableName(schema.SQL)
 
 	return &IndexRawImpl{
-		dbRaw:          dbRaw,
-		name:           name,
-		rootPage:       rootPage,
-		indexedColumns: indexedColumns,
-		tableName:      tableName,
+		dbRaw:      dbRaw,
+		name:       name,
+		rootPage:   rootPage,
+		keyColumns: keyColumns,
+		partial:    partial,
+		unique:     len(fields) > 1 && strings.EqualFold(fields[1], "UNIQUE"),
+		tableName:  tableName,
+	}
+}
+
+// NewAutoIndexRaw creates a raw index SQLite built for a PRIMARY KEY or UNIQUE
+// constraint; its schema row has no SQL, so the key columns come from the table
+func NewAutoIndexRaw(dbRaw DatabaseRaw, name string, rootPage int, schema *SchemaRecord, keyColumns []IndexKeyColumn) *IndexRawImpl {
+	for i := range keyColumns {
+		keyColumns[i].encoding = TextEncoding(dbRaw.GetHeader().TextEncoding)
+	}
+	return &IndexRawImpl{
+		dbRaw:      dbRaw,
+		name:       name,
+		rootPage:   rootPage,
+		keyColumns: keyColumns,
+		unique:     true,
+		tableName:  strings.ToLower(schema.TblName),
+	}
+}
+
+// inheritCollations gives key columns without a COLLATE clause the collation
+// declared by their table column, which is what SQLite builds the index with
+func (ir *IndexRawImpl) inheritCollations(columns []Column) {
+	for i, key := range ir.keyColumns {
+		if key.Name == "" || key.Collation != "" {
+			continue
+		}
+		for _, col := range columns {
+			if strings.EqualFold(col.Name, key.Name) {
+				ir.keyColumns[i].Collation = col.Collation
+			}
+		}
 	}
 }
 
 // ReadAllCells reads all cells from the index B-tree using B-tree abstraction
 func (ir *IndexRawImpl) ReadAllCells(ctx context.Context) ([]Cell, error) {
 	// Use B-tree abstraction for traversal
-	btree := NewBTree(ir.dbRaw, ir.rootPage, BTreeTypeIndex)
-	cells, err := btree.TraverseAll(ctx)
+	cells, err := ir.btree().TraverseAll(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("traverse index %s B-tree: %w", ir.name, err)
 	}
@@ -41,6 +81,31 @@ func (ir *IndexRawImpl) ReadAllCells(ctx context.Context) ([]Cell, error) {
 	return cells, nil
 }
 
+// Cells streams the index's cells in key order
+func (ir *IndexRawImpl) Cells(ctx context.Context) iter.Seq2[*Cell, error] {
+	return func(yield func(*Cell, error) bool) {
+		for cell, err := range ir.btree().Cells(ctx) {
+			if err != nil {
+				yield(nil, fmt.Errorf("walk index %s B-tree: %w", ir.name, err))
+				return
+			}
+			if !yield(cell, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Cursor returns a cursor over the index's B-tree, keyed by IndexKey tuples
+func (ir *IndexRawImpl) Cursor(ctx context.Context) *BTreeCursor {
+	return ir.btree().Cursor(ctx)
+}
+
+// btree returns the B-tree rooted at the index's root page
+func (ir *IndexRawImpl) btree() *BTree {
+	return NewIndexBTree(ir.dbRaw, ir.rootPage, ir.keyColumns)
+}
+
 // GetRootPage returns the root page number
 func (ir *IndexRawImpl) GetRootPage() int {
 	return ir.rootPage
@@ -54,8 +119,7 @@ func (ir *IndexRawImpl) GetName() string {
 // SearchKeys searches for entries with the given key value using B-tree search
 func (ir *IndexRawImpl) SearchKeys(ctx context.Context, key interface{}) ([]IndexEntry, error) {
 	// Use B-tree search to find matching entries
-	btree := NewBTree(ir.dbRaw, ir.rootPage, BTreeTypeIndex)
-	cells, err := btree.Search(ctx, key)
+	cells, err := ir.btree().Search(ctx, key)
 	if err != nil {
 		return nil, fmt.Errorf("search index %s for key %v: %w", ir.name, key, err)
 	}
@@ -77,9 +141,56 @@ func (ir *IndexRawImpl) SearchKeys(ctx context.Context, key interface{}) ([]Inde
 	return entries, nil
 }
 
-// GetIndexedColumns returns the columns covered by this index
+// ScanEntries streams the index entries in key order, starting at the first entry
+// whose key is not smaller than from, or at the first entry when from is nil.
+// from may be a single value or an IndexKey prefix.
+func (ir *IndexRawImpl) ScanEntries(ctx context.Context, from interface{}) iter.Seq2[*IndexEntry, error] {
+	return func(yield func(*IndexEntry, error) bool) {
+		cursor := ir.Cursor(ctx)
+		if from == nil {
+			cursor.First()
+		} else {
+			cursor.Seek(from)
+		}
+		for ; cursor.Valid(); cursor.Next() {
+			entry, err := ir.cellToIndexEntry(*cursor.Cell())
+			if err != nil {
+				yield(nil, fmt.Errorf("scan index %s: %w", ir.name, err))
+				return
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			yield(nil, fmt.Errorf("scan index %s: %w", ir.name, err))
+		}
+	}
+}
+
+// GetIndexedColumns returns the names of the columns covered by this index;
+// expression key columns have an empty name
 func (ir *IndexRawImpl) GetIndexedColumns() []string {
-	return ir.indexedColumns
+	names := make([]string, len(ir.keyColumns))
+	for i, key := range ir.keyColumns {
+		names[i] = key.Name
+	}
+	return names
+}
+
+// GetKeyColumns returns the key columns of the index with their sort order and collation
+func (ir *IndexRawImpl) GetKeyColumns() []IndexKeyColumn {
+	return ir.keyColumns
+}
+
+// IsPartial reports whether the index has a WHERE clause
+func (ir *IndexRawImpl) IsPartial() bool {
+	return ir.partial
+}
+
+// IsUnique reports whether the index was created with CREATE UNIQUE INDEX
+func (ir *IndexRawImpl) IsUnique() bool {
+	return ir.unique
 }
 
 // cellToIndexEntry converts a Cell to an index entry
@@ -88,72 +199,134 @@ func (ir *IndexRawImpl) cellToIndexEntry(cell Cell) (*IndexEntry, error) {
 	// For simplicity, we'll assume the first values are the indexed columns
 	// and use the cell's rowid
 
-	if len(cell.Record.RecordBody.Values) == 0 {
+	if len(cell.Record.SerialTypes) == 0 {
 		return nil, fmt.Errorf("empty index entry")
 	}
 
 	entry := &IndexEntry{
-		Keys:  make([]Value, len(ir.indexedColumns)),
+		Keys:  make([]Value, len(ir.keyColumns)),
 		Rowid: int64(cell.Rowid), // Convert uint64 to int64
 	}
 
-	// Extract key values from record body
-	for i, rawValue := range cell.Record.RecordBody.Values {
-		if i >= len(ir.indexedColumns) {
-			break
+	// Extract key values from the leading columns of the record
+	values := cell.Record.ColumnValues()
+	for i := range entry.Keys {
+		if i < len(values) {
+			entry.Keys[i] = values[i]
+		} else {
+			entry.Keys[i] = NewSQLiteValue(0, nil)
 		}
-
-		// Convert raw value to SQLite value
-		var serialType uint64 = 1 // Default to integer
-		if i < len(cell.Record.RecordHeader.SerialTypes) {
-			serialType = cell.Record.RecordHeader.SerialTypes[i]
-		}
-
-		data := ConvertToBytes(rawValue)
-		entry.Keys[i] = NewSQLiteValue(serialType, data)
 	}
 
 	return entry, nil
 }
 
-// matchesKey checks if a value matches the search key
-func (ir *IndexRawImpl) matchesKey(value Value, key interface{}) bool {
-	valueData := value.Raw()
-	if valueData == nil {
-		return key == nil
+// parseIndexDefinition extracts the key columns of a CREATE INDEX statement and
+// whether it is a partial index
+func parseIndexDefinition(sql string) ([]IndexKeyColumn, bool) {
+	tokens := tokenizeSQL(sql)
+	on := slices.IndexFunc(tokens, func(token sqlToken) bool { return token.depth == 0 && token.text == "ON" })
+	if on < 0 {
+		return nil, false
 	}
-
-	valueStr := string(valueData)
-	keyStr := fmt.Sprintf("%v", key)
-
-	return valueStr == keyStr
+	open := strings.IndexByte(sql[tokens[on].end:], '(')
+	if open < 0 {
+		return nil, false
+	}
+	columns, end := parseKeyList(sql, tokens[on].end+open)
+	if end < 0 {
+		return nil, false
+	}
+	partial := slices.ContainsFunc(tokenizeSQL(sql[end+1:]), func(token sqlToken) bool {
+		return token.depth == 0 && token.text == "WHERE"
+	})
+	return columns, partial
 }
 
-// parseIndexColumns extracts the indexed columns from CREATE INDEX SQL
-func parseIndexColumns(sql string) []string {
-	// Parse the SQL to extract column names
-	// This is a simplified parser - in practice, you might want to use a proper SQL parser
-
-	// Remove extra whitespace and convert to uppercase for parsing
-	cleanSQL := strings.ToUpper(strings.TrimSpace(sql))
-
-	// Find the part between parentheses
-	start := strings.Index(cleanSQL, "(")
-	end := strings.LastIndex(cleanSQL, ")")
+// parseKeyList parses the parenthesized list of key columns that opens at offset
+// open of sql, returning the offset of its closing parenthesis, or -1 when the
+// list is not closed
+func parseKeyList(sql string, open int) ([]IndexKeyColumn, int) {
+	// split the list at its top-level commas
+	var items []string
+	depth, start, end := 0, open+1, -1
+	for i := open; i < len(sql) && end < 0; i++ {
+		// skip over quoted names and literals
+		if i > open && (sql[i] == '"' || sql[i] == '`' || sql[i] == '[' || sql[i] == '\'') {
+			closing := sql[i]
+			if closing == '[' {
+				closing = ']'
+			}
+			if next := strings.IndexByte(sql[i+1:], closing); next >= 0 {
+				i += next + 1
+			}
+			continue
+		}
+		switch {
+		case sql[i] == '(':
+			depth++
+		case sql[i] == ')':
+			depth--
+			if depth == 0 {
+				items = append(items, sql[start:i])
+				end = i
+			}
+		case sql[i] == ',' && depth == 1:
+			items = append(items, sql[start:i])
+			start = i + 1
+		}
+	}
+	if end < 0 {
+		return nil, -1
+	}
 
-	if start == -1 || end == -1 || start >= end {
-		return []string{}
+	columns := make([]IndexKeyColumn, len(items))
+	for i, item := range items {
+		columns[i] = parseIndexKeyColumn(item)
 	}
+	return columns, end
+}
 
-	columnsPart := cleanSQL[start+1 : end]
-	columns := strings.Split(columnsPart, ",")
+// parseIndexKeyColumn parses one term of an index key list:
+// a column or expression, optionally followed by COLLATE name and ASC or DESC
+func parseIndexKeyColumn(item string) IndexKeyColumn {
+	var key IndexKeyColumn
+	tokens := tokenizeSQL(item)
+	end := len(item)
+	for i := len(tokens) - 1; i >= 0 && tokens[i].depth == 0; i-- {
+		switch {
+		case tokens[i].text == "ASC" || tokens[i].text == "DESC":
+			key.Desc = tokens[i].text == "DESC"
+			end = tokens[i].start
+			continue
+		case i > 0 && tokens[i-1].text == "COLLATE":
+			key.Collation = item[tokens[i].start:tokens[i].end]
+			end = tokens[i-1].start
+			i--
+			continue
+		}
+		break
+	}
 
-	// Clean up column names
-	for i, col := range columns {
-		columns[i] = strings.TrimSpace(col)
+	name := strings.TrimSpace(item[:end])
+	if unquoted, ok := unquoteIdentifier(name); ok {
+		key.Name = unquoted
+	} else if name != "" && strings.IndexFunc(name, func(r rune) bool { return r < 0x80 && !isIdentifierChar(byte(r)) }) < 0 {
+		key.Name = name
 	}
+	return key
+}
 
-	return columns
+// unquoteIdentifier strips the quotes of a quoted identifier
+func unquoteIdentifier(name string) (string, bool) {
+	if len(name) < 2 {
+		return name, false
+	}
+	first, last := name[0], name[len(name)-1]
+	if first == '"' && last == '"' || first == '`' && last == '`' || first == '[' && last == ']' {
+		return name[1 : len(name)-1], true
+	}
+	return name, false
 }
 
 // parseIndexTableName extracts the table name from CREATE INDEX SQL
@@ -0,0 +1,782 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// fromTable is one table of a FROM clause
+type fromTable struct {
+	table   Table
+	name    string   // alias, or the table name, used to qualify its columns
+	columns []Column // the table's own columns, positioned within its own rows
+	offset  int      // position of the table's first column in joined rows
+}
+
+// joinKind distinguishes inner joins (including CROSS and comma joins) from LEFT joins
+type joinKind int
+
+const (
+	joinInner joinKind = iota
+	joinLeft
+)
+
+// fromJoin describes how a table is joined onto the rows of the tables before it
+type fromJoin struct {
+	kind joinKind
+	on   []sqlparser.Expr // conjuncts of the ON clause, including USING equalities
+}
+
+// fromClause is a FROM clause flattened into a left-deep chain of joins.
+// Joined rows hold the columns of every table side by side, in FROM order.
+type fromClause struct {
+	tables  []*fromTable
+	joins   []fromJoin // joins[i] attaches tables[i+1]
+	columns []Column   // every column, positioned within joined rows
+}
+
+// resolveFrom looks up the tables of a FROM clause and lays out their columns
+func (engine *SqliteEngine) resolveFrom(ctx context.Context, exprs sqlparser.TableExprs) (*fromClause, error) {
+	from := &fromClause{}
+	for _, expr := range exprs {
+		if err := engine.addTableExpr(ctx, from, expr); err != nil {
+			return nil, err
+		}
+	}
+	if len(from.tables) == 0 {
+		return nil, fmt.Errorf("could not extract table name from SELECT statement")
+	}
+	return from, nil
+}
+
+// addTableExpr appends a FROM item; items separated by commas are cross joined
+func (engine *SqliteEngine) addTableExpr(ctx context.Context, from *fromClause, expr sqlparser.TableExpr) error {
+	switch e := expr.(type) {
+	case *sqlparser.AliasedTableExpr:
+		return engine.addTable(ctx, from, e, joinInner)
+	case *sqlparser.ParenTableExpr:
+		// Only a leading parenthesized join can be flattened without changing its meaning
+		if len(from.tables) > 0 && len(e.Exprs) > 1 {
+			return fmt.Errorf("parenthesized joins are not supported")
+		}
+		for _, inner := range e.Exprs {
+			if err := engine.addTableExpr(ctx, from, inner); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *sqlparser.JoinTableExpr:
+		return engine.addJoin(ctx, from, e)
+	default:
+		return fmt.Errorf("unsupported FROM clause item: %T", expr)
+	}
+}
+
+// addJoin appends the right-hand table of a JOIN and records its join condition
+func (engine *SqliteEngine) addJoin(ctx context.Context, from *fromClause, join *sqlparser.JoinTableExpr) error {
+	if err := engine.addTableExpr(ctx, from, join.LeftExpr); err != nil {
+		return err
+	}
+	right, ok := join.RightExpr.(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return fmt.Errorf("parenthesized joins are not supported")
+	}
+
+	kind := joinInner
+	natural := false
+	switch join.Join {
+	case sqlparser.JoinStr, sqlparser.StraightJoinStr:
+	case sqlparser.LeftJoinStr:
+		kind = joinLeft
+	case sqlparser.NaturalJoinStr:
+		natural = true
+	case sqlparser.NaturalLeftJoinStr:
+		kind, natural = joinLeft, true
+	default:
+		return fmt.Errorf("RIGHT and FULL OUTER JOINs are not supported")
+	}
+	if err := engine.addTable(ctx, from, right, kind); err != nil {
+		return err
+	}
+
+	if join.Condition.On != nil {
+		last := &from.joins[len(from.joins)-1]
+		last.on = append(last.on, splitConjuncts(join.Condition.On)...)
+	}
+
+	using := join.Condition.Using
+	if natural {
+		// A natural join uses every column name the new table shares with the ones before it
+		table := from.tables[len(from.tables)-1]
+		for _, col := range table.columns {
+			if !col.IsRowid && from.findColumn(col.Name, len(from.tables)-1) != nil {
+				using = append(using, sqlparser.NewColIdent(col.Name))
+			}
+		}
+	}
+	return from.addUsing(using)
+}
+
+// addTable appends a table to the FROM clause, joined onto the tables before it with kind
+func (engine *SqliteEngine) addTable(ctx context.Context, from *fromClause, expr *sqlparser.AliasedTableExpr, kind joinKind) error {
+	tableName, ok := expr.Expr.(sqlparser.TableName)
+	if !ok {
+		return fmt.Errorf("subqueries in FROM are not supported")
+	}
+	table, err := engine.db.GetTable(ctx, tableName.Name.String())
+	if err != nil {
+		return err
+	}
+	schema, err := table.GetSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := tableName.Name.String()
+	if !expr.As.IsEmpty() {
+		name = expr.As.String()
+	}
+	entry := &fromTable{table: table, name: name, offset: len(from.columns)}
+	// rows read through the FROM clause carry the rowid after the table's columns;
+	// a WITHOUT ROWID table gets a nameless placeholder that no name resolves to
+	rowid := Column{Name: "rowid", Type: "INTEGER", Index: len(schema), Hidden: true, IsRowid: true}
+	if table.WithoutRowid() {
+		rowid = Column{Index: len(schema), Hidden: true}
+	}
+	schema = append(slices.Clone(schema), rowid)
+	for _, col := range schema {
+		col.Table = name
+		entry.columns = append(entry.columns, col)
+		col.Index += entry.offset
+		from.columns = append(from.columns, col)
+	}
+
+	if len(from.tables) > 0 {
+		from.joins = append(from.joins, fromJoin{kind: kind})
+	}
+	from.tables = append(from.tables, entry)
+	return nil
+}
+
+// addUsing turns USING (x, ...) into ON equalities between the new table and the
+// leftmost earlier table with each column, and hides the new table's copy of x
+func (from *fromClause) addUsing(using sqlparser.Columns) error {
+	if len(using) == 0 {
+		return nil
+	}
+	rightIndex := len(from.tables) - 1
+	right := from.tables[rightIndex]
+	join := &from.joins[rightIndex-1]
+
+	for _, ident := range using {
+		left := from.findColumn(ident.String(), rightIndex)
+		var rightCol *Column
+		for i := range right.columns {
+			if !right.columns[i].IsRowid && strings.EqualFold(right.columns[i].Name, ident.String()) {
+				rightCol = &from.columns[right.offset+i]
+			}
+		}
+		if left == nil || rightCol == nil {
+			return fmt.Errorf("cannot join using column %s - column not present in both tables", ident.String())
+		}
+
+		rightCol.Hidden = true
+		join.on = append(join.on, &sqlparser.ComparisonExpr{
+			Operator: sqlparser.EqualStr,
+			Left:     qualifiedColumn(left.Table, left.Name),
+			Right:    qualifiedColumn(rightCol.Table, rightCol.Name),
+		})
+	}
+	return nil
+}
+
+// findColumn returns the first visible column named name among the first count tables
+func (from *fromClause) findColumn(name string, count int) *Column {
+	end := len(from.columns)
+	if count < len(from.tables) {
+		end = from.tables[count].offset
+	}
+	for i := range from.columns[:end] {
+		if strings.EqualFold(from.columns[i].Name, name) && !from.columns[i].Hidden {
+			return &from.columns[i]
+		}
+	}
+	return nil
+}
+
+// tableOf returns the index of the table that holds the joined-row column index
+func (from *fromClause) tableOf(index int) int {
+	for i := len(from.tables) - 1; i > 0; i-- {
+		if index >= from.tables[i].offset {
+			return i
+		}
+	}
+	return 0
+}
+
+// referencedTables returns the set of tables an expression reads columns from.
+// ok is false when a column cannot be resolved; compiling the expression reports why.
+func (from *fromClause) referencedTables(expr sqlparser.Expr) (tables map[int]bool, ok bool) {
+	tables = make(map[int]bool)
+	compiler := NewExprCompiler(from.columns)
+	ok = true
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if colName, isCol := node.(*sqlparser.ColName); isCol {
+			col, err := compiler.resolveColumn(colName)
+			if err != nil {
+				ok = false
+				return false, nil
+			}
+			tables[from.tableOf(col.Index)] = true
+		}
+		return true, nil
+	}, expr)
+	return tables, ok
+}
+
+// nullExtended reports whether rows of table i can be NULL-filled by a LEFT join
+func (from *fromClause) nullExtended(i int) bool {
+	return i > 0 && from.joins[i-1].kind == joinLeft
+}
+
+// qualifiedColumn builds the column reference "table.name"
+func qualifiedColumn(table, name string) *sqlparser.ColName {
+	return &sqlparser.ColName{
+		Name:      sqlparser.NewColIdent(name),
+		Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent(table)},
+	}
+}
+
+// splitConjuncts splits an expression into the terms of its top-level ANDs
+func splitConjuncts(expr sqlparser.Expr) []sqlparser.Expr {
+	switch e := expr.(type) {
+	case nil:
+		return nil
+	case *sqlparser.AndExpr:
+		return append(splitConjuncts(e.Left), splitConjuncts(e.Right)...)
+	case *sqlparser.ParenExpr:
+		if _, ok := e.Expr.(*sqlparser.AndExpr); ok {
+			return splitConjuncts(e.Expr)
+		}
+	}
+	return []sqlparser.Expr{expr}
+}
+
+// joinPlan holds the conditions of a join query sorted by where they are evaluated
+type joinPlan struct {
+	from   *fromClause
+	scan   [][]sqlparser.Expr // conditions on a single table, applied while scanning it
+	join   [][]sqlparser.Expr // join[i]: conditions checked on each candidate row of join i
+	after  [][]sqlparser.Expr // after[i]: WHERE terms checked once tables 0..i are joined
+	before []sqlparser.Expr   // WHERE terms that read no table at all
+}
+
+// planJoin pushes each WHERE and ON term down to the earliest point it can be evaluated.
+// Terms on one table filter that table's scan unless a LEFT join NULL-fills it; WHERE
+// terms of inner joins become join conditions so they can drive index and hash lookups.
+func planJoin(from *fromClause, where sqlparser.Expr) *joinPlan {
+	n := len(from.tables)
+	plan := &joinPlan{
+		from:  from,
+		scan:  make([][]sqlparser.Expr, n),
+		join:  make([][]sqlparser.Expr, n),
+		after: make([][]sqlparser.Expr, n),
+	}
+
+	for i, join := range from.joins {
+		right := i + 1
+		for _, term := range join.on {
+			tables, ok := from.referencedTables(term)
+			if ok && len(tables) == 1 && tables[right] {
+				plan.scan[right] = append(plan.scan[right], term)
+			} else {
+				plan.join[right] = append(plan.join[right], term)
+			}
+		}
+	}
+
+	for _, term := range splitConjuncts(where) {
+		tables, ok := from.referencedTables(term)
+		last := n - 1
+		if ok {
+			last = -1
+			for t := range tables {
+				last = max(last, t)
+			}
+		}
+		switch {
+		case last < 0:
+			plan.before = append(plan.before, term)
+		case len(tables) == 1 && !from.nullExtended(last):
+			plan.scan[last] = append(plan.scan[last], term)
+		case last > 0 && !from.nullExtended(last):
+			plan.join[last] = append(plan.join[last], term)
+		default:
+			plan.after[last] = append(plan.after[last], term)
+		}
+	}
+	return plan
+}
+
+// joinSource streams the rows of a multi-table FROM clause that satisfy where
+func (engine *SqliteEngine) joinSource(ctx context.Context, from *fromClause, where sqlparser.Expr) (RowSource, []*PlanNode, error) {
+	plan := planJoin(from, where)
+
+	compiler := NewExprCompiler(from.columns)
+	source, step, err := engine.tableSource(ctx, from.tables[0], plan.scan[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	steps := []*PlanNode{step}
+	if len(plan.before) > 0 {
+		predicate, err := compiler.CompilePredicate(andAll(plan.before))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error compiling WHERE condition: %v", err)
+		}
+		source = filterSource(source, predicate, "WHERE")
+	}
+
+	for i := range from.tables {
+		if i > 0 {
+			var step *PlanNode
+			if source, step, err = engine.joinStep(ctx, plan, i, source); err != nil {
+				return nil, nil, err
+			}
+			steps = append(steps, step)
+		}
+		if len(plan.after[i]) > 0 {
+			predicate, err := compiler.CompilePredicate(andAll(plan.after[i]))
+			if err != nil {
+				return nil, nil, fmt.Errorf("error compiling WHERE condition: %v", err)
+			}
+			source = filterSource(source, predicate, "WHERE")
+		}
+	}
+	return source, steps, nil
+}
+
+// tableSource reads one table of a join, filtered by the conditions pushed down to
+// it. The optimizer plans the read like a single-table query with those
+// conditions, so it may search a rowid or index range instead of scanning.
+func (engine *SqliteEngine) tableSource(ctx context.Context, table *fromTable, conditions []sqlparser.Expr) (RowSource, *PlanNode, error) {
+	step := &PlanNode{Detail: "SCAN " + table.name}
+	if len(conditions) == 0 {
+		return rowidSource(table.table.Scan(ctx)), step, nil
+	}
+
+	var source RowSource
+	optimizer := NewQueryOptimizer(engine.db)
+	plan, err := optimizer.OptimizeSelect(tableQuery(table, conditions))
+	if err == nil && (plan.UseIndex || plan.UseRowid) {
+		step = plan.Describe(table.name)
+		if source, err = optimizer.ExecutePlan(ctx, plan); err != nil {
+			return nil, nil, fmt.Errorf("error executing optimized query: %v", err)
+		}
+	} else {
+		source = table.table.Scan(ctx)
+	}
+
+	predicate, err := NewExprCompiler(table.columns).CompilePredicate(andAll(conditions))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error compiling condition on table %s: %v", table.name, err)
+	}
+	return filterSource(rowidSource(source), predicate, "WHERE"), step, nil
+}
+
+// tableQuery builds "SELECT * FROM table WHERE conditions", the single-table query
+// the optimizer plans the read of a join's table from. Selecting every column
+// keeps it from choosing an index that covers only the conditions.
+func tableQuery(table *fromTable, conditions []sqlparser.Expr) *sqlparser.Select {
+	return &sqlparser.Select{
+		SelectExprs: sqlparser.SelectExprs{&sqlparser.StarExpr{}},
+		From: sqlparser.TableExprs{&sqlparser.AliasedTableExpr{
+			Expr: sqlparser.TableName{Name: sqlparser.NewTableIdent(table.table.GetName())},
+		}},
+		Where: sqlparser.NewWhere(sqlparser.WhereStr, andAll(conditions)),
+	}
+}
+
+// andAll combines terms with AND
+func andAll(terms []sqlparser.Expr) sqlparser.Expr {
+	expr := terms[0]
+	for _, term := range terms[1:] {
+		expr = &sqlparser.AndExpr{Left: expr, Right: term}
+	}
+	return expr
+}
+
+// equiJoinKey is an equality between an expression over the joined table and one over earlier tables
+type equiJoinKey struct {
+	right sqlparser.Expr
+	left  sqlparser.Expr
+	first bool // the joined table's expression is the left operand of the equality
+}
+
+// equiJoinKeys finds the equality conditions of join i that can drive a lookup
+func equiJoinKeys(from *fromClause, i int, conditions []sqlparser.Expr) []equiJoinKey {
+	var keys []equiJoinKey
+	for _, term := range conditions {
+		comparison, ok := term.(*sqlparser.ComparisonExpr)
+		if !ok || comparison.Operator != sqlparser.EqualStr {
+			continue
+		}
+		leftTables, okLeft := from.referencedTables(comparison.Left)
+		rightTables, okRight := from.referencedTables(comparison.Right)
+		if !okLeft || !okRight {
+			continue
+		}
+		switch {
+		case onlyTable(leftTables, i) && beforeTable(rightTables, i):
+			keys = append(keys, equiJoinKey{right: comparison.Left, left: comparison.Right, first: true})
+		case onlyTable(rightTables, i) && beforeTable(leftTables, i):
+			keys = append(keys, equiJoinKey{right: comparison.Right, left: comparison.Left})
+		}
+	}
+	return keys
+}
+
+func onlyTable(tables map[int]bool, i int) bool {
+	return len(tables) == 1 && tables[i]
+}
+
+func beforeTable(tables map[int]bool, i int) bool {
+	if len(tables) == 0 {
+		return false
+	}
+	for t := range tables {
+		if t >= i {
+			return false
+		}
+	}
+	return true
+}
+
+// joinMatcher produces the rows of the joined table that may match a row of the earlier tables
+type joinMatcher func(left *Row) ([]*Row, error)
+
+// joinStep joins table i onto source. Each strategy may return extra candidates;
+// the full join condition is checked on every combined row.
+//
+//   - index nested loop: an equality on the table's rowid alias or on the leading
+//     column of one of its indexes is looked up per outer row
+//   - hash join: other equalities build a hash table over the table once
+//   - nested loop: without equalities every outer row is paired with every row
+func (engine *SqliteEngine) joinStep(ctx context.Context, plan *joinPlan, i int, source RowSource) (RowSource, *PlanNode, error) {
+	from := plan.from
+	table := from.tables[i]
+
+	var condition Predicate
+	if len(plan.join[i]) > 0 {
+		var err error
+		if condition, err = NewExprCompiler(from.columns).CompilePredicate(andAll(plan.join[i])); err != nil {
+			return nil, nil, fmt.Errorf("error compiling join condition: %v", err)
+		}
+	}
+
+	keys := equiJoinKeys(from, i, plan.join[i])
+	matcher, step, err := engine.indexMatcher(ctx, from, i, keys, plan.scan[i])
+	if err != nil {
+		return nil, nil, err
+	}
+	if matcher == nil {
+		if matcher, step, err = engine.scanMatcher(ctx, from, i, keys, plan.scan[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	nulls := make([]Value, len(table.columns))
+	for j := range nulls {
+		nulls[j] = NewNullValue()
+	}
+	outer := from.joins[i-1].kind == joinLeft
+	if outer {
+		step.Detail += " LEFT-JOIN"
+	}
+
+	return func(yield func(*Row, error) bool) {
+		for left, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			candidates, err := matcher(left)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			matched := false
+			for _, right := range candidates {
+				row := &Row{Values: append(append(make([]Value, 0, len(left.Values)+len(right.Values)), left.Values...), right.Values...)}
+				if condition != nil {
+					ok, err := condition(row)
+					if err != nil {
+						yield(nil, fmt.Errorf("error evaluating join condition: %v", err))
+						return
+					}
+					if !ok {
+						continue
+					}
+				}
+				matched = true
+				if !yield(row, nil) {
+					return
+				}
+			}
+
+			if outer && !matched {
+				row := &Row{Values: append(append(make([]Value, 0, len(left.Values)+len(nulls)), left.Values...), nulls...)}
+				if !yield(row, nil) {
+					return
+				}
+			}
+		}
+	}, step, nil
+}
+
+// indexMatcher looks up join candidates through the rowid or an index of table i,
+// or returns nil when no equality can use one. As in SQLite, an index serves an
+// equality on its leading column when it orders keys by the comparison's collation
+// and the comparison's affinity leaves the column's values as they are stored;
+// the probe value gets that affinity.
+func (engine *SqliteEngine) indexMatcher(ctx context.Context, from *fromClause, i int, keys []equiJoinKey, scan []sqlparser.Expr) (joinMatcher, *PlanNode, error) {
+	table := from.tables[i]
+	indexes, err := table.table.GetIndexes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, key := range keys {
+		colName, ok := key.right.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		col, err := NewExprCompiler(table.columns).resolveColumn(colName)
+		if err != nil {
+			continue
+		}
+		probe, err := NewExprCompiler(from.columns).Compile(key.left)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error compiling join condition: %v", err)
+		}
+		affinity := comparisonAffinity(probe.Affinity, AffinityForType(col.Type))
+
+		var index Index
+		rowid := col.IsRowid || col.RowidAlias
+		if !rowid && indexAffinityOK(affinity, AffinityForType(col.Type)) {
+			collation := col.Collation
+			if name, explicit, ok := operandCollation(key.left, from.columns); explicit || ok && !key.first {
+				collation = name
+			}
+			for _, candidate := range indexes {
+				keys := candidate.GetKeyColumns()
+				if !candidate.IsPartial() && len(keys) > 0 && strings.EqualFold(keys[0].Name, col.Name) && sameCollation(keys[0].Collation, collation) {
+					index = candidate
+					break
+				}
+			}
+		}
+		if !rowid && index == nil {
+			continue
+		}
+
+		var filter Predicate
+		if len(scan) > 0 {
+			if filter, err = NewExprCompiler(table.columns).CompilePredicate(andAll(scan)); err != nil {
+				return nil, nil, fmt.Errorf("error compiling condition on table %s: %v", table.name, err)
+			}
+		}
+		step := &PlanNode{Detail: fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (rowid=?)", table.name)}
+		if _, ok := index.(*primaryKeyIndex); ok {
+			step.Detail = fmt.Sprintf("SEARCH %s USING PRIMARY KEY (%s=?)", table.name, col.Name)
+		} else if !rowid {
+			step.Detail = fmt.Sprintf("SEARCH %s USING INDEX %s (%s=?)", table.name, index.GetName(), col.Name)
+		}
+
+		return func(left *Row) ([]*Row, error) {
+			value, err := probe.Eval(left)
+			if err != nil || StorageClassOf(value) == StorageClassNull {
+				return nil, err
+			}
+
+			var rows []*Row
+			if rowid {
+				value = ApplyAffinity(value, AffinityInteger)
+				if StorageClassOf(value) != StorageClassInteger {
+					return nil, nil
+				}
+				id, _ := value.Int64()
+				row, err := table.table.GetRowByRowid(ctx, id)
+				if errors.Is(err, ErrRowNotFound) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, withRowid(row))
+			} else {
+				entries, err := index.SearchByKey(ctx, ApplyAffinity(value, affinity))
+				if err != nil {
+					return nil, err
+				}
+				for _, entry := range entries {
+					row := entry.Row
+					if row == nil {
+						if row, err = table.table.GetRowByRowid(ctx, entry.Rowid); err != nil {
+							return nil, err
+						}
+					}
+					rows = append(rows, withRowid(row))
+				}
+			}
+			return filterRows(rows, filter)
+		}, step, nil
+	}
+	return nil, nil, nil
+}
+
+// indexAffinityOK reports whether an index on a column with the given affinity can
+// serve a comparison with the given affinity. Comparing as TEXT or as numbers
+// converts the column's values unless they already have that affinity.
+func indexAffinityOK(comparison, column Affinity) bool {
+	switch {
+	case comparison == AffinityNone || comparison == AffinityBlob:
+		return true
+	case comparison == AffinityText:
+		return column == AffinityText
+	default:
+		return isNumericAffinity(column)
+	}
+}
+
+// operandCollation returns the collation an operand brings to a comparison: the
+// name of an explicit COLLATE, or a column's declared collation. ok is false for
+// other expressions, which bring none.
+func operandCollation(expr sqlparser.Expr, columns []Column) (name string, explicit, ok bool) {
+	switch e := expr.(type) {
+	case *sqlparser.CollateExpr:
+		return e.Charset, true, true
+	case *sqlparser.ParenExpr:
+		return operandCollation(e.Expr, columns)
+	case *sqlparser.ColName:
+		if col, err := NewExprCompiler(columns).resolveColumn(e); err == nil {
+			return col.Collation, false, true
+		}
+	}
+	return "", false, false
+}
+
+// scanMatcher reads table i once, on first use, and matches candidates from memory:
+// through a hash table on the equi-join keys when there are any, otherwise all rows.
+// SQLite's closest plan is an automatic index, which is how the hash table is described.
+func (engine *SqliteEngine) scanMatcher(ctx context.Context, from *fromClause, i int, keys []equiJoinKey, scan []sqlparser.Expr) (joinMatcher, *PlanNode, error) {
+	table := from.tables[i]
+	source, step, err := engine.tableSource(ctx, table, scan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buildKeys := make([]*CompiledExpr, len(keys))
+	probeKeys := make([]*CompiledExpr, len(keys))
+	terms := make([]string, len(keys))
+	for k, key := range keys {
+		if buildKeys[k], err = NewExprCompiler(table.columns).Compile(key.right); err != nil {
+			return nil, nil, fmt.Errorf("error compiling join condition: %v", err)
+		}
+		if probeKeys[k], err = NewExprCompiler(from.columns).Compile(key.left); err != nil {
+			return nil, nil, fmt.Errorf("error compiling join condition: %v", err)
+		}
+		terms[k] = sqlparser.String(key.right) + "=?"
+		if colName, ok := key.right.(*sqlparser.ColName); ok {
+			terms[k] = colName.Name.String() + "=?"
+		}
+	}
+	if len(keys) > 0 {
+		step = &PlanNode{Detail: fmt.Sprintf("SEARCH %s USING AUTOMATIC COVERING INDEX (%s)", table.name, strings.Join(terms, " AND "))}
+	}
+
+	var rows []*Row
+	var buckets map[string][]*Row
+	loaded := false
+	load := func() error {
+		loaded = true
+		if len(keys) > 0 {
+			buckets = make(map[string][]*Row)
+		}
+		for row, err := range source {
+			if err != nil {
+				return err
+			}
+			if buckets == nil {
+				rows = append(rows, row)
+				continue
+			}
+			hash, ok, err := hashJoinKey(row, buildKeys)
+			if err != nil {
+				return err
+			}
+			if ok {
+				buckets[hash] = append(buckets[hash], row)
+			}
+		}
+		return nil
+	}
+
+	return func(left *Row) ([]*Row, error) {
+		if !loaded {
+			if err := load(); err != nil {
+				return nil, err
+			}
+		}
+		if buckets == nil {
+			return rows, nil
+		}
+		hash, ok, err := hashJoinKey(left, probeKeys)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return buckets[hash], nil
+	}, step, nil
+}
+
+// hashJoinKey hashes the join key values of a row. Numeric text hashes like the
+// number and text is case-folded, so values that compare equal under any affinity
+// or built-in collation share a bucket; ok is false if a key is NULL and cannot match.
+func hashJoinKey(row *Row, keys []*CompiledExpr) (string, bool, error) {
+	var hash strings.Builder
+	for _, key := range keys {
+		value, err := key.Eval(row)
+		if err != nil {
+			return "", false, fmt.Errorf("error evaluating join key: %v", err)
+		}
+		if StorageClassOf(value) == StorageClassNull {
+			return "", false, nil
+		}
+		part := distinctKey(ApplyAffinity(value, AffinityNumeric))
+		fmt.Fprintf(&hash, "%d:%s", len(part), part)
+	}
+	return hash.String(), true, nil
+}
+
+// filterRows keeps the rows that satisfy predicate; a nil predicate keeps them all
+func filterRows(rows []*Row, predicate Predicate) ([]*Row, error) {
+	if predicate == nil {
+		return rows, nil
+	}
+	kept := rows[:0]
+	for _, row := range rows {
+		ok, err := predicate(row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// journalMagic starts each header of a rollback journal
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// journalHeaderSize is the part of a journal header that holds data; the header
+// takes up a whole sector
+const journalHeaderSize = 28
+
+// Journal is a hot rollback journal, left behind by a writer that crashed in the
+// middle of a transaction. It holds the original images of the pages the writer
+// changed, which bring the database back to its state before the transaction.
+type Journal struct {
+	Path         string
+	DatabaseSize int            // size of the database in pages before the transaction
+	pages        map[int][]byte // original page images by page number
+}
+
+// OpenHotJournal reads the rollback journal of the database at dbPath. It returns
+// nil without an error when there is none or it is not hot: empty, as
+// journal_mode=TRUNCATE leaves it, or with a zeroed header, as PERSIST does.
+//
+// The journal is a series of segments, each a header followed by page records:
+// the page number, the page image and a checksum. Each header starts on a sector
+// boundary and gives the number of records after it, or -1 for as many as the
+// file holds. A record with a bad checksum was never completely written, so it
+// and everything after it are ignored, as SQLite does.
+func OpenHotJournal(dbPath string, pageSize int) (*Journal, error) {
+	path := dbPath + "-journal"
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	if len(data) < journalHeaderSize || !bytes.Equal(data[:8], journalMagic) {
+		return nil, nil
+	}
+
+	journal := &Journal{Path: path, DatabaseSize: int(binary.BigEndian.Uint32(data[16:])), pages: make(map[int][]byte)}
+	if journalPageSize := int(binary.BigEndian.Uint32(data[24:])); journalPageSize != pageSize {
+		return nil, fmt.Errorf("journal page size %d differs from database page size %d", journalPageSize, pageSize)
+	}
+	recordSize := 4 + pageSize + 4
+	for offset := 0; offset+journalHeaderSize <= len(data) && bytes.Equal(data[offset:offset+8], journalMagic); {
+		records := int(binary.BigEndian.Uint32(data[offset+8:]))
+		nonce := binary.BigEndian.Uint32(data[offset+12:])
+		sectorSize := int(binary.BigEndian.Uint32(data[offset+20:]))
+		if sectorSize < 512 || sectorSize > 65536 || sectorSize&(sectorSize-1) != 0 {
+			break
+		}
+		offset += sectorSize
+		if records == 0xffffffff {
+			records = (len(data) - offset) / recordSize
+		}
+		for ; records > 0 && offset+recordSize <= len(data); records-- {
+			pageNum := int(binary.BigEndian.Uint32(data[offset:]))
+			page := data[offset+4 : offset+4+pageSize]
+			if pageNum == 0 || binary.BigEndian.Uint32(data[offset+4+pageSize:]) != journalChecksum(page, nonce) {
+				return journal, nil
+			}
+			// the first image of a page is the one from before the transaction
+			if _, ok := journal.pages[pageNum]; !ok {
+				journal.pages[pageNum] = page
+			}
+			offset += recordSize
+		}
+		offset = (offset + sectorSize - 1) / sectorSize * sectorSize
+	}
+	return journal, nil
+}
+
+// journalChecksum computes the checksum of a page record: the header's nonce
+// plus every 200th byte of the page, counting back from 200 bytes before its end
+func journalChecksum(page []byte, nonce uint32) uint32 {
+	sum := nonce
+	for i := len(page) - 200; i > 0; i -= 200 {
+		sum += uint32(page[i])
+	}
+	return sum
+}
+
+// Page returns the original image of a page the transaction changed
+func (j *Journal) Page(pageNum int) ([]byte, bool) {
+	page, ok := j.pages[pageNum]
+	return page, ok
+}
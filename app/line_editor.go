@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by ReadLine when Ctrl-C abandons the line
+var errInterrupted = errors.New("interrupted")
+
+// LineEditor reads lines typed on a terminal in raw mode, echoing them itself so
+// that the cursor can move within the line and earlier lines can be recalled.
+// It understands the usual readline keys: arrows, Home/End, Delete, Backspace and
+// Ctrl-A, B, D, E, F, K, L, N, P, U and W.
+type LineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	History []string // earlier lines, oldest first
+}
+
+// NewLineEditor creates a line editor reading keys from in and echoing to out
+func NewLineEditor(in io.Reader, out io.Writer) *LineEditor {
+	return &LineEditor{in: bufio.NewReader(in), out: out}
+}
+
+// ReadLine shows prompt and reads a line. It returns io.EOF for Ctrl-D on an
+// empty line and errInterrupted for Ctrl-C.
+func (e *LineEditor) ReadLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	recalled := len(e.History) // the history entry shown; len(History) is the new line
+	var draft []rune           // the new line, kept while browsing the history
+
+	recall := func(i int) {
+		if i < 0 || i > len(e.History) || i == recalled {
+			return
+		}
+		if recalled == len(e.History) {
+			draft = line
+		}
+		recalled = i
+		if i == len(e.History) {
+			line = draft
+		} else {
+			line = []rune(e.History[i])
+		}
+		pos = len(line)
+	}
+
+	e.refresh(prompt, line, pos)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 2: // Ctrl-B
+			pos = max(pos-1, 0)
+		case 6: // Ctrl-F
+			pos = min(pos+1, len(line))
+		case 8, 127: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 11: // Ctrl-K
+			line = line[:pos]
+		case 21: // Ctrl-U
+			line = append([]rune(nil), line[pos:]...)
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			recall(recalled - 1)
+		case 14: // Ctrl-N
+			recall(recalled + 1)
+		case 27:
+			switch e.readEscape() {
+			case "A":
+				recall(recalled - 1)
+			case "B":
+				recall(recalled + 1)
+			case "C":
+				pos = min(pos+1, len(line))
+			case "D":
+				pos = max(pos-1, 0)
+			case "H", "1~", "7~":
+				pos = 0
+			case "F", "4~", "8~":
+				pos = len(line)
+			case "3~":
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		e.refresh(prompt, line, pos)
+	}
+}
+
+// readEscape reads the rest of an escape sequence after ESC: the final letter of
+// ESC [ X and ESC O X, or the number and tilde of ESC [ n ~
+func (e *LineEditor) readEscape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return ""
+	}
+	var sequence []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		sequence = append(sequence, r)
+		if r < '0' || r > '9' {
+			return string(sequence)
+		}
+	}
+}
+
+// refresh redraws the line after the prompt and puts the cursor at pos
+func (e *LineEditor) refresh(prompt string, line []rune, pos int) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", prompt, string(line))
+	if column := utf8.RuneCountInString(prompt) + pos; column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
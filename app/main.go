@@ -9,8 +9,8 @@ import (
 
 // runProgram handles the core logic, separated from main for testability
 func runProgram(args []string) error {
-	if len(args) < 3 {
-		fmt.Println("Usage: your_program.sh <database_file> <command>")
+	if len(args) < 2 {
+		fmt.Println("Usage: your_program.sh <database_file> [command]")
 		return fmt.Errorf("insufficient arguments")
 	}
 
@@ -27,12 +27,17 @@ func runProgram(args []string) error {
 	}
 	defer engine.Close()
 
+	// Without a command, read commands interactively
+	if len(args) == 2 {
+		return NewShell(engine, os.Stdin, os.Stdout, os.Stderr).Run()
+	}
+
 	// Extract command arguments
 	var command string
 	var sqlArgs string
 
 	if len(args) > 2 && args[2][0] == '.' {
-		command = args[2]
+		command, sqlArgs, _ = strings.Cut(strings.Join(args[2:], " "), " ")
 	} else {
 		sqlArgs = strings.Join(args[2:], " ")
 		command = "sql"
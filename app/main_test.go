@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+
+	"github.com/xwb1989/sqlparser"
 )
 
 func TestMainFunctionality(t *testing.T) {
@@ -23,12 +31,17 @@ func TestMainFunctionality(t *testing.T) {
 		{
 			name:     "dbinfo command",
 			args:     []string{"test", dbPath, ".dbinfo"},
-			contains: []string{"database page size:", "number of tables:"},
+			contains: []string{"database page size:  4096", "write format:        1", "database page count: 4\n", "text encoding:       1 (utf8)", "number of tables:    3", "number of indexes:   0"},
 		},
 		{
 			name:     "tables command",
 			args:     []string{"test", dbPath, ".tables"},
-			contains: []string{"sqlite_sequence"},
+			contains: []string{"apples oranges"},
+		},
+		{
+			name:     "schema command",
+			args:     []string{"test", dbPath, ".schema"},
+			contains: []string{"CREATE TABLE apples\n(\n\tid integer primary key autoincrement,", "\n);\nCREATE TABLE sqlite_sequence(name,seq);\nCREATE TABLE oranges\n("},
 		},
 		{
 			name:     "sql select count(*)",
@@ -206,19 +219,23 @@ func TestSQLiteParsingIntegration(t *testing.T) {
 
 // Test the record body serialization functionality
 func TestRecordBodySerialization(t *testing.T) {
-	// Create a record body with known values
-	rb := &RecordBody{
-		Values: []interface{}{
-			[]byte("table"),
-			[]byte("test_table"),
-			[]byte("test_table"),
-			[]byte{5},
-			[]byte("CREATE TABLE test_table(id INTEGER, name TEXT)"),
+	// Create a record with known values
+	sql := "CREATE TABLE test_table(id INTEGER, name TEXT)"
+	record := &Record{
+		RecordHeader: RecordHeader{SerialTypes: []uint64{23, 33, 33, 1, uint64(13 + 2*len(sql))}},
+		RecordBody: RecordBody{
+			Values: []interface{}{
+				[]byte("table"),
+				[]byte("test_table"),
+				[]byte("test_table"),
+				[]byte{5},
+				[]byte(sql),
+			},
 		},
 	}
 
 	// Test parsing
-	schema := rb.ParseAsSchema()
+	schema := record.ParseAsSchema()
 	if schema == nil {
 		t.Fatal("Failed to parse schema")
 	}
@@ -236,17 +253,1616 @@ func TestRecordBodySerialization(t *testing.T) {
 	if schema.RootPage != 5 {
 		t.Errorf("RootPage = %v, want %v", schema.RootPage, 5)
 	}
-	if schema.SQL != "CREATE TABLE test_table(id INTEGER, name TEXT)" {
+	if schema.SQL != sql {
 		t.Errorf("SQL = %v, want expected CREATE statement", schema.SQL)
 	}
 
 	// Test union field is set
-	if rb.Schema != schema {
+	if record.Schema != schema {
 		t.Errorf("Union field not set correctly")
 	}
 
 	// Test identification
-	if !rb.IsSchemaRecord() {
+	if !record.IsSchemaRecord() {
 		t.Errorf("IsSchemaRecord() should return true")
 	}
+
+	// The body of an autoindex row leaves out its NULL sql column
+	autoindex := &Record{
+		RecordHeader: RecordHeader{SerialTypes: []uint64{23, 53, 13 + 2*1, 1, 0}},
+		RecordBody:   RecordBody{Values: []interface{}{[]byte("index"), []byte("sqlite_autoindex_t_1"), []byte("t"), []byte{3}}},
+	}
+	schema = autoindex.ParseAsSchema()
+	if schema == nil || schema.Name != "sqlite_autoindex_t_1" || schema.TblName != "t" || schema.RootPage != 3 || schema.SQL != "" {
+		t.Errorf("autoindex row: got %+v", schema)
+	}
+}
+
+// Test the local payload size computation used for overflow pages
+func TestLocalPayloadSize(t *testing.T) {
+	const usableSize = 4096
+
+	tests := []struct {
+		name        string
+		payloadSize int
+		maxLocal    int
+		want        int
+	}{
+		{"table leaf fits on page", 100, tableLeafMaxLocal(usableSize), 100},
+		{"table leaf spills with K local bytes", 5000, tableLeafMaxLocal(usableSize), 908},
+		{"index cell spills with M local bytes", 2010, indexMaxLocal(usableSize), 489},
+		{"index cell at threshold", 1002, indexMaxLocal(usableSize), 1002},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := localPayloadSize(tt.payloadSize, usableSize, tt.maxLocal)
+			if got != tt.want {
+				t.Errorf("localPayloadSize(%d) = %d, want %d", tt.payloadSize, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test REAL decoding and sqlite3-compatible rendering
+func TestRealValueString(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{1.0, "1.0"},
+		{0.1, "0.1"},
+		{-2.5, "-2.5"},
+		{100.0, "100.0"},
+		{1e20, "1.0e+20"},
+		{1.5e-7, "1.5e-07"},
+		{3.14159265358979323, "3.14159265358979"},
+		{123456789012345678.0, "1.23456789012346e+17"},
+	}
+
+	for _, tt := range tests {
+		value := NewRealValue(tt.value)
+		if got := value.String(); got != tt.want {
+			t.Errorf("NewRealValue(%v).String() = %q, want %q", tt.value, got, tt.want)
+		}
+		if f, err := value.Float64(); err != nil || f != tt.value {
+			t.Errorf("NewRealValue(%v).Float64() = %v, %v", tt.value, f, err)
+		}
+	}
+}
+
+// Test typed comparisons with storage class ordering, affinity and NULL handling
+func TestCompareOperands(t *testing.T) {
+	tests := []struct {
+		name          string
+		left, right   Value
+		leftAffinity  Affinity
+		rightAffinity Affinity
+		operator      string
+		want          Truth
+	}{
+		{"integers compare numerically", NewIntegerValue(10), NewIntegerValue(9), AffinityInteger, AffinityNone, ">", TruthTrue},
+		{"integer equals real", NewIntegerValue(10), NewRealValue(10.0), AffinityInteger, AffinityNone, "=", TruthTrue},
+		{"numeric affinity converts text literal", NewIntegerValue(10), NewTextValue("10"), AffinityInteger, AffinityNone, "=", TruthTrue},
+		{"text affinity converts numeric literal", NewTextValue("9"), NewIntegerValue(10), AffinityText, AffinityNone, ">", TruthTrue},
+		{"no affinity keeps storage classes", NewIntegerValue(10), NewTextValue("10"), AffinityBlob, AffinityNone, "=", TruthFalse},
+		{"numbers sort before text", NewRealValue(1e9), NewTextValue("1"), AffinityNone, AffinityNone, "<", TruthTrue},
+		{"NULL comparison is unknown", NewNullValue(), NewIntegerValue(1), AffinityInteger, AffinityNone, "=", TruthNull},
+		{"real 2^63 exceeds every integer", NewRealValue(1 << 63), NewIntegerValue(math.MaxInt64), AffinityNone, AffinityNone, ">", TruthTrue},
+		{"integer is below real 2^63", NewIntegerValue(math.MaxInt64), NewRealValue(1 << 63), AffinityNone, AffinityNone, "<", TruthTrue},
+		{"real below -2^63 is below every integer", NewRealValue(-1 << 64), NewIntegerValue(math.MinInt64), AffinityNone, AffinityNone, "<", TruthTrue},
+		{"real -2^63 equals the smallest integer", NewRealValue(-1 << 63), NewIntegerValue(math.MinInt64), AffinityNone, AffinityNone, "=", TruthTrue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CompareOperands(tt.left, tt.right, tt.leftAffinity, tt.rightAffinity, nil, tt.operator)
+			if err != nil {
+				t.Fatalf("CompareOperands returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CompareOperands(%v %s %v) = %v, want %v", tt.left, tt.operator, tt.right, got, tt.want)
+			}
+		})
+	}
+
+	if TruthNull.And(TruthFalse) != TruthFalse || TruthNull.Or(TruthTrue) != TruthTrue || TruthNull.Not() != TruthNull {
+		t.Errorf("three-valued logic does not follow SQL semantics")
+	}
+}
+
+// Test the compiled expression evaluator against a single row
+func TestExprCompiler(t *testing.T) {
+	schema := []Column{
+		{Name: "name", Type: "text", Index: 0},
+		{Name: "qty", Type: "integer", Index: 1},
+		{Name: "price", Type: "real", Index: 2},
+	}
+	row := &Row{Values: []Value{NewTextValue("Fuji"), NewIntegerValue(10), NewNullValue()}}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"qty * 2 + 1", "21"},
+		{"-qty", "-10"},
+		{"qty / 4", "2"},
+		{"qty / 4.0", "2.5"},
+		{"name || '-' || qty", "Fuji-10"},
+		{"QTY > 9 and (name = 'Fuji' or price > 1)", "1"},
+		{"price > 1 or qty < 5", ""},
+		{"not (price > 1)", ""},
+		{"price is null and qty between 5 and 10", "1"},
+		{"name like 'fu%' and qty in (1, 10)", "1"},
+		{"qty = '10'", "1"},
+		{"name || '^'", "Fuji^"},
+	}
+
+	compiler := NewExprCompiler(schema)
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sql, err := rewriteSQLiteQuery("select 1 from t where " + tt.expr)
+			if err != nil {
+				t.Fatalf("rewrite %q: %v", tt.expr, err)
+			}
+			stmt, err := sqlparser.Parse(sql)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.expr, err)
+			}
+			compiled, err := compiler.Compile(stmt.(*sqlparser.Select).Where.Expr)
+			if err != nil {
+				t.Fatalf("compile %q: %v", tt.expr, err)
+			}
+			value, err := compiled.Eval(row)
+			if err != nil {
+				t.Fatalf("eval %q: %v", tt.expr, err)
+			}
+			if got := value.String(); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+
+	// "^" stands in for "||", so a "^" in the query itself is not an operator
+	if _, err := rewriteSQLiteQuery("select 1 ^ 2"); err == nil {
+		t.Errorf("rewriteSQLiteQuery accepted a bare ^")
+	}
+}
+
+// Test ORDER BY with NULLS FIRST/LAST, collations, LIMIT and OFFSET over an in-memory row source
+func TestOrderByLimit(t *testing.T) {
+	schema := []Column{
+		{Name: "name", Type: "text", Index: 0, Collation: "nocase"},
+		{Name: "score", Type: "real", Index: 1},
+	}
+	rows := []Row{
+		{Values: []Value{NewTextValue("bob"), NewRealValue(3.5)}},
+		{Values: []Value{NewTextValue("Alice"), NewNullValue()}},
+		{Values: []Value{NewTextValue("carol"), NewIntegerValue(2)}},
+		{Values: []Value{NewTextValue("alice"), NewIntegerValue(7)}},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"select name from t order by name", []string{"Alice", "alice", "bob", "carol"}},
+		{"select name from t order by name collate binary", []string{"Alice", "alice", "bob", "carol"}},
+		{"select name from t order by name collate binary desc", []string{"carol", "bob", "alice", "Alice"}},
+		{"select name from t order by score", []string{"Alice", "carol", "bob", "alice"}},
+		{"select name from t order by score nulls last", []string{"carol", "bob", "alice", "Alice"}},
+		{"select name from t order by score desc", []string{"alice", "bob", "carol", "Alice"}},
+		{"select name from t order by score desc nulls first limit 2", []string{"Alice", "alice"}},
+		{"select name as n from t order by n desc limit 2 offset 1", []string{"bob", "Alice"}},
+		{"select name from t limit 1, 2", []string{"Alice", "carol"}},
+		{"select name from t limit -1 offset 3", []string{"alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			sql, err := rewriteSQLiteQuery(tt.query)
+			if err != nil {
+				t.Fatalf("rewrite %q: %v", tt.query, err)
+			}
+			sql, nullsOrder := stripNullsOrdering(sql)
+			stmt, err := sqlparser.Parse(sql)
+			if err != nil {
+				t.Fatalf("parse %q: %v", sql, err)
+			}
+			sel := stmt.(*sqlparser.Select)
+			aliased := sel.SelectExprs[0].(*sqlparser.AliasedExpr)
+			columns := []resultColumn{{expr: aliased.Expr, alias: aliased.As.String()}}
+
+			compiler := NewExprCompiler(schema)
+			source := rowsSource(rows)
+			if len(sel.OrderBy) > 0 {
+				keys, err := compileOrderBy(sel.OrderBy, nullsOrder, compiler, columns)
+				if err != nil {
+					t.Fatalf("compile ORDER BY: %v", err)
+				}
+				source = sortSource(source, keys)
+			}
+			limit, offset, err := evaluateLimit(sel.Limit)
+			if err != nil {
+				t.Fatalf("evaluate LIMIT: %v", err)
+			}
+
+			var got []string
+			for row, err := range limitSource(source, limit, offset) {
+				if err != nil {
+					t.Fatalf("iterate: %v", err)
+				}
+				got = append(got, row.Values[0].String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// a scan on score DESC, then the rowid
+	sortedBy := []sortedColumn{{index: 1, desc: true}, {index: 2}}
+	order := append(schema, Column{Name: "rowid", Index: 2, IsRowid: true})
+	for query, want := range map[string]bool{
+		"select name from t order by score desc":                true,
+		"select name from t order by score desc nulls last":     true,
+		"select name from t order by score desc nulls first":    false,
+		"select name from t order by score":                     false,
+		"select name from t order by score desc, rowid":         true,
+		"select name from t order by score desc, rowid desc":    false,
+		"select score as s from t order by s desc":              true,
+		"select name from t order by score collate nocase desc": false,
+		"select name from t order by name":                      false,
+	} {
+		sql, nullsOrder := stripNullsOrdering(query)
+		stmt, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		sel := stmt.(*sqlparser.Select)
+		aliased := sel.SelectExprs[0].(*sqlparser.AliasedExpr)
+		columns := []resultColumn{{expr: aliased.Expr, alias: aliased.As.String()}}
+		if got := inSortOrder(sel.OrderBy, nullsOrder, columns, order, sortedBy); got != want {
+			t.Errorf("%s: in sort order = %v, want %v", query, got, want)
+		}
+	}
+
+	// rows already in ORDER BY order are not sorted, so a LIMIT stops the scan in
+	// the first leaf; the second leaf is garbage and fails the query when read
+	dbPath := writeTestDatabase(t,
+		[][]byte{tableLeafCell(1, "table", "t", "t", 2, "CREATE TABLE t(id INTEGER PRIMARY KEY, v TEXT)")},
+		buildPage(0x05, 4, []byte{0, 0, 0, 3, 2}),
+		buildPage(0x0d, 0, tableLeafCell(1, nil, "a"), tableLeafCell(2, nil, "b")),
+		make([]byte, 512))
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	var out bytes.Buffer
+	engine.out = &out
+	for query, want := range map[string]string{
+		"select v from t order by id limit 1":              "a\n",
+		"select v from t where id > 1 order by id limit 1": "b\n",
+	} {
+		out.Reset()
+		if err := engine.ExecuteCommand("sql", query); err != nil || out.String() != want {
+			t.Errorf("%s: got %q, %v; want %q", query, out.String(), err, want)
+		}
+	}
+	if err := engine.ExecuteCommand("sql", "select v from t order by v limit 1"); err == nil {
+		t.Error("sorting by v read no further than the first leaf")
+	}
+}
+
+// Test aggregate functions with SQLite's NULL, DISTINCT and overflow semantics
+func TestAggregates(t *testing.T) {
+	schema := []Column{
+		{Name: "a", Type: "integer", Index: 0},
+		{Name: "b", Type: "text", Index: 1},
+	}
+	rows := []Row{
+		{Values: []Value{NewIntegerValue(1), NewTextValue("x")}},
+		{Values: []Value{NewIntegerValue(2), NewTextValue("y")}},
+		{Values: []Value{NewNullValue(), NewTextValue("x")}},
+		{Values: []Value{NewIntegerValue(4), NewNullValue()}},
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"count(*)", "4"},
+		{"count(a)", "3"},
+		{"count(distinct b)", "2"},
+		{"sum(a)", "7"},
+		{"sum(a) / 2.0", "3.5"},
+		{"total(b)", "0.0"},
+		{"avg(a)", "2.33333333333333"},
+		{"min(a) || '-' || max(b)", "1-y"},
+		{"group_concat(b)", "x,y,x"},
+		{"group_concat(distinct b)", "x,y"},
+		{"group_concat(b, ';')", "x;y;x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sql, err := rewriteSQLiteQuery("select " + tt.expr + " from t")
+			if err != nil {
+				t.Fatalf("rewrite %q: %v", tt.expr, err)
+			}
+			stmt, err := sqlparser.Parse(sql)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.expr, err)
+			}
+			aggregates := NewAggregateSet(len(schema))
+			compiled, err := NewAggregateCompiler(schema, aggregates).Compile(stmt.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr)
+			if err != nil {
+				t.Fatalf("compile %q: %v", tt.expr, err)
+			}
+			for row, err := range aggregateSource(rowsSource(rows), aggregates) {
+				if err != nil {
+					t.Fatalf("aggregate %q: %v", tt.expr, err)
+				}
+				value, err := compiled.Eval(row)
+				if err != nil {
+					t.Fatalf("eval %q: %v", tt.expr, err)
+				}
+				if got := value.String(); got != tt.want {
+					t.Errorf("%s = %q, want %q", tt.expr, got, tt.want)
+				}
+			}
+		})
+	}
+
+	overflow := NewAggregateSet(0)
+	call := &AggregateCall{Name: "sum", newAccumulator: func() Accumulator { return &sumAccumulator{mode: "sum"} }}
+	overflow.add(call)
+	accumulator := overflow.newAccumulators()[0]
+	accumulator.Step([]Value{NewIntegerValue(math.MaxInt64)})
+	accumulator.Step([]Value{NewIntegerValue(1)})
+	if _, err := accumulator.Result(); err == nil {
+		t.Errorf("sum() overflow did not report an error")
+	}
+	// a REAL added after the overflow makes the sum approximate instead
+	accumulator.Step([]Value{NewRealValue(1.5)})
+	if result, err := accumulator.Result(); err != nil || result.Type() != ValueTypeFloat64 {
+		t.Errorf("sum() overflow then REAL: got %v, %v", result, err)
+	}
+
+	// REAL sums are compensated, where adding in float64 loses the 1.0 or drifts
+	for _, tt := range []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{1e100, 1.0, -1e100}, 1.0},
+		{[]float64{0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1}, 1.0},
+	} {
+		for _, mode := range []string{"sum", "total", "avg"} {
+			accumulator := &sumAccumulator{mode: mode}
+			for _, f := range tt.values {
+				accumulator.Step([]Value{NewRealValue(f)})
+			}
+			want := tt.want
+			if mode == "avg" {
+				want /= float64(len(tt.values))
+			}
+			if result, err := accumulator.Result(); err != nil || result.String() != NewRealValue(want).String() {
+				t.Errorf("%s(%v) = %v, %v; want %v", mode, tt.values, result, err, want)
+			} else if f, _ := result.Float64(); f != want {
+				t.Errorf("%s(%v) = %v, want exactly %v", mode, tt.values, f, want)
+			}
+		}
+	}
+	if _, err := NewExprCompiler(schema).Compile(&sqlparser.FuncExpr{Name: sqlparser.NewColIdent("count")}); err == nil {
+		t.Errorf("aggregate outside an aggregate context compiled without error")
+	}
+}
+
+// Test GROUP BY grouping with both the hash and the streaming strategies
+func TestGroupSource(t *testing.T) {
+	schema := []Column{
+		{Name: "color", Type: "text", Index: 0, Collation: "nocase"},
+		{Name: "qty", Type: "integer", Index: 1},
+	}
+	rows := []Row{
+		{Values: []Value{NewTextValue("red"), NewIntegerValue(1)}},
+		{Values: []Value{NewTextValue("Red"), NewIntegerValue(2)}},
+		{Values: []Value{NewNullValue(), NewIntegerValue(3)}},
+		{Values: []Value{NewTextValue("green"), NewIntegerValue(4)}},
+		{Values: []Value{NewNullValue(), NewIntegerValue(5)}},
+	}
+	sorted := []Row{rows[2], rows[4], rows[3], rows[0], rows[1]}
+
+	stmt, err := sqlparser.Parse("select color, sum(qty), count(*) as n from t group by color having n > 1")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sel := stmt.(*sqlparser.Select)
+
+	for _, ordered := range []bool{false, true} {
+		aggregates := NewAggregateSet(len(schema))
+		compiler := NewAggregateCompiler(schema, aggregates)
+		var projections []*CompiledExpr
+		var columns []resultColumn
+		for _, selectExpr := range sel.SelectExprs {
+			aliased := selectExpr.(*sqlparser.AliasedExpr)
+			compiled, err := compiler.Compile(aliased.Expr)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			projections = append(projections, compiled)
+			columns = append(columns, resultColumn{expr: aliased.Expr, alias: aliased.As.String()})
+		}
+		having, err := compiler.WithAliases(columns).CompilePredicate(sel.Having.Expr)
+		if err != nil {
+			t.Fatalf("compile HAVING: %v", err)
+		}
+		_, keys, err := groupByTerms(sel.GroupBy, schema, nil)
+		if err != nil {
+			t.Fatalf("compile GROUP BY: %v", err)
+		}
+
+		input := rows
+		if ordered {
+			input = sorted
+		}
+		var got []string
+		for row, err := range filterSource(groupSource(rowsSource(input), keys, aggregates, ordered), having, "HAVING") {
+			if err != nil {
+				t.Fatalf("group: %v", err)
+			}
+			color, _ := projections[0].Eval(row)
+			sum, _ := projections[1].Eval(row)
+			got = append(got, color.String()+"|"+sum.String())
+		}
+		if want := "|8,red|3"; strings.Join(got, ",") != want {
+			t.Errorf("ordered=%v: got %q, want %q", ordered, strings.Join(got, ","), want)
+		}
+	}
+}
+
+func TestPlanJoin(t *testing.T) {
+	from := &fromClause{
+		tables: []*fromTable{{name: "e", offset: 0}, {name: "d", offset: 2}},
+		joins:  []fromJoin{{kind: joinLeft}},
+		columns: []Column{
+			{Name: "name", Index: 0, Table: "e"},
+			{Name: "dept", Index: 1, Table: "e"},
+			{Name: "id", Index: 2, Table: "d"},
+			{Name: "city", Index: 3, Table: "d"},
+		},
+	}
+
+	stmt, err := sqlparser.Parse("select * from e left join d on e.dept = d.id and d.city = 'rome' where e.name > 'a' and d.city is null and 1 = 1")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sel := stmt.(*sqlparser.Select)
+	from.joins[0].on = splitConjuncts(sel.From[0].(*sqlparser.JoinTableExpr).Condition.On)
+
+	plan := planJoin(from, sel.Where.Expr)
+	placed := func(terms []sqlparser.Expr) string {
+		var parts []string
+		for _, term := range terms {
+			parts = append(parts, sqlparser.String(term))
+		}
+		return strings.Join(parts, " and ")
+	}
+
+	// WHERE terms on the NULL-extended side of a LEFT join must wait for the padding
+	checks := []struct{ name, got, want string }{
+		{"scan[0]", placed(plan.scan[0]), "e.name > 'a'"},
+		{"scan[1]", placed(plan.scan[1]), "d.city = 'rome'"},
+		{"join[1]", placed(plan.join[1]), "e.dept = d.id"},
+		{"after[1]", placed(plan.after[1]), "d.city is null"},
+		{"before", placed(plan.before), "1 = 1"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s: got %q, want %q", check.name, check.got, check.want)
+		}
+	}
+
+	keys := equiJoinKeys(from, 1, plan.join[1])
+	if len(keys) != 1 || sqlparser.String(keys[0].right) != "d.id" || sqlparser.String(keys[0].left) != "e.dept" || keys[0].first {
+		t.Errorf("equi-join keys: got %+v", keys)
+	}
+
+	// an index on the joined column serves the equality unless comparing converts
+	// the column's values
+	affinities := []struct {
+		outer, column Affinity
+		want          bool
+	}{
+		{AffinityInteger, AffinityInteger, true},
+		{AffinityText, AffinityInteger, true},
+		{AffinityInteger, AffinityText, false},
+		{AffinityText, AffinityText, true},
+		{AffinityNone, AffinityText, true},
+		{AffinityBlob, AffinityReal, true},
+	}
+	for _, a := range affinities {
+		if got := indexAffinityOK(comparisonAffinity(a.outer, a.column), a.column); got != a.want {
+			t.Errorf("index on %v column for %v operand: got %v, want %v", a.column, a.outer, got, a.want)
+		}
+	}
+}
+
+// memoryPages is a DatabaseRaw over hand-built pages
+type memoryPages map[int][]byte
+
+func (m memoryPages) ReadPage(ctx context.Context, pageNum int) ([]byte, error) {
+	if page, ok := m[pageNum]; ok {
+		return page, nil
+	}
+	return nil, fmt.Errorf("page %d not found", pageNum)
+}
+func (m memoryPages) GetPageSize() int                                    { return 512 }
+func (m memoryPages) GetUsableSize() int                                  { return 512 }
+func (m memoryPages) ReadSchemaTable(ctx context.Context) ([]Cell, error) { return nil, nil }
+func (m memoryPages) Close() error                                        { return nil }
+func (m memoryPages) PageCacheStats() PageCacheStats                      { return PageCacheStats{} }
+func (m memoryPages) GetHeader() *DatabaseHeader                          { return &DatabaseHeader{PageSize: 512} }
+func (m memoryPages) FileSize() (int64, error)                            { return int64(512 * len(m)), nil }
+func (m memoryPages) Snapshots() []WALSnapshot                            { return nil }
+func (m memoryPages) UseSnapshot(frame int) error                         { return fmt.Errorf("no wal") }
+
+// buildPage lays out a b-tree page with its cells packed at the end
+func buildPage(pageType byte, rightmost uint32, cells ...[]byte) []byte {
+	page := make([]byte, 512)
+	page[0] = pageType
+	pointers := 8
+	if pageType == 0x02 || pageType == 0x05 {
+		binary.BigEndian.PutUint32(page[8:], rightmost)
+		pointers = 12
+	}
+	binary.BigEndian.PutUint16(page[3:], uint16(len(cells)))
+	end := len(page)
+	for i, cell := range cells {
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[pointers+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(page[5:], uint16(end))
+	return page
+}
+
+// testRecord encodes a record of NULLs, small integers and text
+func testRecord(values ...any) []byte {
+	header, body := []byte{byte(len(values) + 1)}, []byte{}
+	for _, value := range values {
+		switch value := value.(type) {
+		case nil:
+			header = append(header, 0)
+		case int:
+			header, body = append(header, 1), append(body, byte(value))
+		case string:
+			header, body = append(header, byte(13+2*len(value))), append(body, value...)
+		}
+	}
+	return append(header, body...)
+}
+
+// tableLeafCell builds a table leaf cell holding a record
+func tableLeafCell(rowid byte, values ...any) []byte {
+	payload := testRecord(values...)
+	return append([]byte{byte(len(payload)), rowid}, payload...)
+}
+
+// indexLeafCell builds an index leaf cell holding a record
+func indexLeafCell(values ...any) []byte {
+	payload := testRecord(values...)
+	return append([]byte{byte(len(payload))}, payload...)
+}
+
+// writeTestDatabase writes a database of 512-byte pages whose schema table holds
+// the given cells, followed by pages 2, 3, ..., and returns its path
+func writeTestDatabase(t *testing.T, schema [][]byte, pages ...[]byte) string {
+	schemaPage := buildPage(0x0d, 0, schema...)
+	page1 := make([]byte, 512)
+	copy(page1, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page1[16:], 512)
+	copy(page1[18:], []byte{1, 1, 0, 64, 32, 32})
+	binary.BigEndian.PutUint32(page1[28:], uint32(len(pages)+1))
+	binary.BigEndian.PutUint32(page1[44:], 4)
+	binary.BigEndian.PutUint32(page1[56:], 1)
+	// the b-tree header follows the database header; the cells stay where they are
+	copy(page1[100:], schemaPage[:8+2*len(schema)])
+	if content := int(binary.BigEndian.Uint16(schemaPage[5:])); content >= 108+2*len(schema) {
+		copy(page1[content:], schemaPage[content:])
+	} else {
+		t.Fatal("schema cells overlap the database header")
+	}
+
+	dbPath := t.TempDir() + "/test.db"
+	if err := os.WriteFile(dbPath, slices.Concat(append([][]byte{page1}, pages...)...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dbPath
+}
+
+func TestBTreeCursor(t *testing.T) {
+	tableCell := func(rowid byte) []byte { return []byte{3, rowid, 2, 1, rowid * 10} }
+	indexRecord := func(key string, rowid byte) []byte {
+		return append([]byte{3, byte(13 + 2*len(key)), 1}, append([]byte(key), rowid)...)
+	}
+	indexCell := func(key string, rowid byte) []byte {
+		record := indexRecord(key, rowid)
+		return append([]byte{byte(len(record))}, record...)
+	}
+
+	pages := memoryPages{
+		2: buildPage(0x05, 4, []byte{0, 0, 0, 3, 2}),
+		3: buildPage(0x0d, 0, tableCell(1), tableCell(2)),
+		4: buildPage(0x0d, 0, tableCell(3), tableCell(4)),
+		5: buildPage(0x02, 7, append([]byte{0, 0, 0, 6}, indexCell("c", 3)...)),
+		6: buildPage(0x0a, 0, indexCell("a", 1), indexCell("b", 2)),
+		7: buildPage(0x0a, 0, indexCell("d", 4), indexCell("e", 5)),
+	}
+	ctx := context.Background()
+
+	collect := func(cursor *BTreeCursor, key func(*Cell) string) string {
+		var keys []string
+		for ; cursor.Valid(); cursor.Next() {
+			keys = append(keys, key(cursor.Cell()))
+		}
+		if err := cursor.Err(); err != nil {
+			t.Fatalf("cursor: %v", err)
+		}
+		return strings.Join(keys, ",")
+	}
+	rowid := func(cell *Cell) string { return fmt.Sprint(cell.Rowid) }
+	indexKey := func(cell *Cell) string { return cell.Record.ColumnValues()[0].String() + rowid(cell) }
+
+	table := NewBTree(pages, 2, BTreeTypeTable)
+	index := NewBTree(pages, 5, BTreeTypeIndex)
+	tests := []struct {
+		name   string
+		cursor *BTreeCursor
+		seek   BTreeKey
+		key    func(*Cell) string
+		want   string
+	}{
+		{"table first", table.Cursor(ctx), nil, rowid, "1,2,3,4"},
+		{"table seek", table.Cursor(ctx), uint64(2), rowid, "2,3,4"},
+		{"table seek past leaf", table.Cursor(ctx), uint64(3), rowid, "3,4"},
+		{"table seek past end", table.Cursor(ctx), uint64(9), rowid, ""},
+		{"index first", index.Cursor(ctx), nil, indexKey, "a1,b2,c3,d4,e5"},
+		{"index seek interior entry", index.Cursor(ctx), "c", indexKey, "c3,d4,e5"},
+		{"index seek between", index.Cursor(ctx), "bb", indexKey, "c3,d4,e5"},
+	}
+	for _, tt := range tests {
+		if tt.seek == nil {
+			tt.cursor.First()
+		} else {
+			tt.cursor.Seek(tt.seek)
+		}
+		if got := collect(tt.cursor, tt.key); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// stopping a scan early must not touch the pages after it
+	delete(pages, 4)
+	for cell, err := range table.Cells(ctx) {
+		if err != nil {
+			t.Fatalf("early stop read past the first leaf: %v", err)
+		}
+		if cell.Rowid == 2 {
+			break
+		}
+	}
+}
+
+func TestPageCache(t *testing.T) {
+	cache := NewPageCache(2)
+	cache.Put(1, []byte{1})
+	cache.Put(2, []byte{2})
+	if _, ok := cache.Get(1); !ok {
+		t.Fatal("page 1 should be cached")
+	}
+	cache.Put(3, []byte{3}) // evicts page 2, the least recently used
+
+	if _, ok := cache.Get(2); ok {
+		t.Error("page 2 should have been evicted")
+	}
+	for _, pageNum := range []int{1, 3} {
+		if data, ok := cache.Get(pageNum); !ok || data[0] != byte(pageNum) {
+			t.Errorf("page %d: got %v, %v", pageNum, data, ok)
+		}
+	}
+
+	want := PageCacheStats{Hits: 3, Misses: 1, Evictions: 1, Pages: 2, Capacity: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("stats: got %+v, want %+v", got, want)
+	}
+
+	disabled := NewPageCache(0)
+	disabled.Put(1, []byte{1})
+	if _, ok := disabled.Get(1); ok {
+		t.Error("a zero-sized cache should not keep pages")
+	}
+
+	dbPath := "../sample.db"
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		t.Skip("sample.db not found")
+	}
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	var out bytes.Buffer
+	engine.out = &out
+	if err := engine.ExecuteCommand(".stats", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Page cache hits:") {
+		t.Errorf(".stats output: got %q", out.String())
+	}
+}
+
+func TestIndexRangeNarrowing(t *testing.T) {
+	describe := func(ranges []IndexRange) string {
+		var parts []string
+		for _, r := range ranges {
+			part := "("
+			if r.Lower != nil {
+				part = map[bool]string{true: "[", false: "("}[r.Lower.Inclusive] + r.Lower.Value.String()
+			}
+			part += ","
+			if r.Upper != nil {
+				part += r.Upper.Value.String() + map[bool]string{true: "]", false: ")"}[r.Upper.Inclusive]
+			} else {
+				part += ")"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	tests := []struct {
+		column Column
+		where  string
+		want   string
+	}{
+		{Column{Name: "n", Type: "integer"}, "n > 5 and n < '10'", "(5,10)"},
+		{Column{Name: "n", Type: "integer"}, "3 <= n and n >= 4 and n between 1 and 8", "[4,8]"},
+		{Column{Name: "n", Type: "integer"}, "n = 7 and m < 2", "[7,7]"},
+		{Column{Name: "s", Type: "text"}, "s like 'a1%'", "[A1,A2) [a1,a2)"},
+		{Column{Name: "s", Type: "text"}, "s like 'ab%' and s > 'Ab'", "(Ab,AC) (Ab,Ac) [aB,aC) [ab,ac)"},
+		{Column{Name: "n", Type: "integer"}, "n like 'a%'", "(,)"},
+	}
+	for _, tt := range tests {
+		stmt, err := sqlparser.Parse("select * from t where " + tt.where)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.where, err)
+		}
+		choice := &indexChoice{ranges: []IndexRange{{}}}
+		for _, term := range splitConjuncts(stmt.(*sqlparser.Select).Where.Expr) {
+			choice.narrow(term, &tt.column)
+		}
+		if got := describe(choice.ranges); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestRowidPlanning(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: "INTEGER", Index: 0, IsPrimaryKey: true, RowidAlias: true},
+		{Name: "oid", Type: "TEXT", Index: 1},
+		{Name: "rowid", Type: "INTEGER", Index: 2, Hidden: true, IsRowid: true},
+	}
+
+	compiler := NewExprCompiler(columns)
+	row := &Row{Values: []Value{NewIntegerValue(7), NewTextValue("shadow"), NewIntegerValue(7)}}
+	for name, want := range map[string]string{"rowid": "7", "_rowid_": "7", "oid": "shadow"} {
+		expr, err := compiler.Compile(&sqlparser.ColName{Name: sqlparser.NewColIdent(name)})
+		if err != nil {
+			t.Fatalf("compile %s: %v", name, err)
+		}
+		if got, _ := expr.Eval(row); got.String() != want {
+			t.Errorf("%s: got %s, want %s", name, got.String(), want)
+		}
+	}
+
+	stmt, err := sqlparser.Parse("select * from t where id >= 10 and _rowid_ < 20.5 and oid = 3")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// oid names the real column here, so only id and _rowid_ narrow the rowid range
+	choice := rowidChoice(splitConjuncts(stmt.(*sqlparser.Select).Where.Expr), columns[:2])
+	if len(choice.ranges) != 1 || choice.column != "id" {
+		t.Fatalf("unexpected choice %+v", choice)
+	}
+	r := choice.ranges[0]
+	if r.Lower == nil || r.Lower.Value.String() != "10" || !r.Lower.Inclusive || r.Upper == nil || r.Upper.Value.String() != "20.5" {
+		t.Errorf("got range %+v %+v", r.Lower, r.Upper)
+	}
+
+	for _, tt := range []struct {
+		bound *IndexBound
+		want  int64
+		ok    bool
+	}{
+		{nil, math.MinInt64, true},
+		{&IndexBound{Value: NewRealValue(-2.5)}, -3, true},
+		{&IndexBound{Value: NewTextValue("a")}, 0, false},
+	} {
+		if got, ok := rowidSeekKey(tt.bound); got != tt.want || ok != tt.ok {
+			t.Errorf("rowidSeekKey(%+v) = %d, %v; want %d, %v", tt.bound, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRowidAliasDetection(t *testing.T) {
+	for _, tt := range []struct {
+		sql   string
+		alias string // name of the rowid alias column, empty for none
+	}{
+		{"CREATE TABLE t(id integer primary key, b text)", "id"},
+		{"CREATE TABLE t(id INTEGER PRIMARY KEY AUTOINCREMENT, b text)", "id"},
+		{`CREATE TABLE t("id" INTEGER NOT NULL PRIMARY KEY ASC, b text)`, "id"},
+		{"CREATE TABLE t(id integer constraint pk primary key on conflict replace, b text)", "id"},
+		{"CREATE TABLE t(b text, id integer, primary key(id desc))", "id"},
+		{"CREATE TABLE t(id INTEGER PRIMARY KEY DESC, b text)", ""},
+		{"CREATE TABLE t(id int primary key, b text)", ""},
+		{"CREATE TABLE t(id integer(10) primary key, b text)", ""},
+		{"CREATE TABLE t(id integer, b integer, primary key(id, b))", ""},
+		{"CREATE TABLE t(id integer, b text)", ""},
+	} {
+		columns, err := parseTableSchema(tt.sql)
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		var alias string
+		for _, col := range columns {
+			if col.RowidAlias {
+				alias = col.Name
+			}
+		}
+		if alias != tt.alias {
+			t.Errorf("%s: got alias %q, want %q", tt.sql, alias, tt.alias)
+		}
+	}
+}
+
+func TestCompositeIndexKeys(t *testing.T) {
+	for _, tt := range []struct {
+		sql     string
+		want    []IndexKeyColumn
+		partial bool
+	}{
+		{"CREATE INDEX i ON t (country, city)", []IndexKeyColumn{{Name: "country"}, {Name: "city"}}, false},
+		{`CREATE UNIQUE INDEX i ON "t"("size range" DESC, b COLLATE nocase ASC)`,
+			[]IndexKeyColumn{{Name: "size range", Desc: true}, {Name: "b", Collation: "nocase"}}, false},
+		{"CREATE INDEX i ON t(lower(a), substr(b, 1, 2) desc) WHERE a > 0",
+			[]IndexKeyColumn{{}, {Desc: true}}, true},
+	} {
+		got, partial := parseIndexDefinition(tt.sql)
+		if !slices.Equal(got, tt.want) || partial != tt.partial {
+			t.Errorf("%s: got %+v, %v; want %+v, %v", tt.sql, got, partial, tt.want, tt.partial)
+		}
+	}
+
+	// (n, name DESC COLLATE NOCASE): numbers before text, names descending ignoring case
+	columns := []IndexKeyColumn{{Name: "n"}, {Name: "name", Desc: true, Collation: "NOCASE"}}
+	keys := []IndexKey{
+		{NewTextValue("1"), NewTextValue("a"), NewIntegerValue(1)},
+		{NewIntegerValue(10), NewTextValue("a"), NewIntegerValue(2)},
+		{NewIntegerValue(2), NewTextValue("B"), NewIntegerValue(3)},
+		{NewRealValue(2), NewTextValue("a"), NewIntegerValue(4)},
+		{NewIntegerValue(2), NewTextValue("b"), NewIntegerValue(5)},
+		{NewNullValue(), NewTextValue("z"), NewIntegerValue(6)},
+	}
+	slices.SortFunc(keys, func(a, b IndexKey) int { return compareIndexKeys(columns, a, b) })
+	var order []string
+	for _, key := range keys {
+		order = append(order, key[2].String())
+	}
+	if got := strings.Join(order, ","); got != "6,3,5,4,2,1" {
+		t.Errorf("key order: got %s", got)
+	}
+
+	// a prefix compares equal to the keys that start with it
+	if c := compareIndexKeys(columns, keys[1], IndexKey{NewIntegerValue(2), NewTextValue("B")}); c != 0 {
+		t.Errorf("prefix comparison: got %d", c)
+	}
+}
+
+// keyColumnsIndex is an Index that only describes its key columns
+type keyColumnsIndex struct {
+	Index
+	keys []IndexKeyColumn
+}
+
+func (i keyColumnsIndex) GetKeyColumns() []IndexKeyColumn { return i.keys }
+
+func TestCoveringIndex(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: "INTEGER", Index: 0, IsPrimaryKey: true, RowidAlias: true},
+		{Name: "name", Type: "TEXT", Index: 1},
+		{Name: "country", Type: "TEXT", Index: 2},
+		{Name: "score", Type: "REAL", Index: 3},
+	}
+	index := keyColumnsIndex{keys: []IndexKeyColumn{{Name: "country"}, {Name: "score"}}}
+
+	for query, want := range map[string]bool{
+		"select id from t where country = 'x'":                           true,
+		"select rowid, score from t where country = 'x' order by score":  true,
+		"select count(*) from t where country > 'x'":                     true,
+		"select * from t where country = 'x'":                            false,
+		"select id from t where country = 'x' and name = 'y'":            false,
+		"select country from t where country = 'x' group by name":        false,
+		"select name + country from t where country = 'x'":               false,
+		"select count(*) from t where country = 'x' and name <> country": false,
+	} {
+		stmt, err := sqlparser.Parse(query)
+		if err != nil {
+			t.Fatalf("parse %s: %v", query, err)
+		}
+		if got := coversQuery(stmt.(*sqlparser.Select), index, columns); got != want {
+			t.Errorf("%s: covering = %v, want %v", query, got, want)
+		}
+	}
+
+	row, err := coveringRows(index, columns)(&IndexEntry{Keys: []Value{NewTextValue("x"), NewIntegerValue(3)}, Rowid: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, value := range row.Values {
+		got = append(got, value.String())
+	}
+	// the integral REAL key is read back as REAL
+	if strings.Join(got, "|") != "42||x|3.0" || row.Rowid != 42 {
+		t.Errorf("covering row: got %v, rowid %d", got, row.Rowid)
+	}
+}
+
+func TestQueryPlanFormat(t *testing.T) {
+	steps := []*PlanNode{
+		{Detail: "SCAN e", Children: []*PlanNode{{Detail: "A"}, {Detail: "B"}}},
+		{Detail: "USE TEMP B-TREE FOR ORDER BY"},
+	}
+	want := "QUERY PLAN\n|--SCAN e\n|  |--A\n|  `--B\n`--USE TEMP B-TREE FOR ORDER BY\n"
+	if got := FormatQueryPlan(steps); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	point := IndexRange{Lower: &IndexBound{Value: NewIntegerValue(5), Inclusive: true}, Upper: &IndexBound{Value: NewIntegerValue(5), Inclusive: true}}
+	above := IndexRange{Equal: []Value{NewTextValue("FR")}, Lower: &IndexBound{Value: NewTextValue("a")}}
+	for _, tt := range []struct {
+		plan QueryPlan
+		want string
+	}{
+		{QueryPlan{}, "SCAN t"},
+		{QueryPlan{UseRowid: true, Ranges: []IndexRange{point}}, "SEARCH t USING INTEGER PRIMARY KEY (rowid=?)"},
+		{QueryPlan{UseIndex: true, IndexName: "idx_n", IndexColumns: []string{"n"}, Ranges: []IndexRange{point, point}}, "SEARCH t USING INDEX idx_n (n=?)"},
+		{QueryPlan{UseIndex: true, Covering: true, IndexName: "idx_cc", IndexColumns: []string{"country", "name"}, Ranges: []IndexRange{above}}, "SEARCH t USING COVERING INDEX idx_cc (country=? AND name>?)"},
+	} {
+		if got := tt.plan.Describe("t").Detail; got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	for sql, want := range map[string]string{
+		"explain query plan select 1":   "select 1",
+		"EXPLAIN\nQUERY  PLAN select 1": "select 1",
+		"explain select 1":              "explain select 1",
+		"select explain":                "select explain",
+	} {
+		got, _ := cutExplainQueryPlan(sql)
+		if got != want {
+			t.Errorf("cutExplainQueryPlan(%q) = %q, want %q", sql, got, want)
+		}
+	}
+
+	// the ORDER BY step is listed only when a sort runs
+	dbPath := writeTestDatabase(t,
+		[][]byte{
+			tableLeafCell(1, "table", "t", "t", 2, "CREATE TABLE t(id INTEGER PRIMARY KEY, v TEXT)"),
+			tableLeafCell(2, "index", "iv", "t", 3, "CREATE INDEX iv ON t(v)"),
+		},
+		buildPage(0x0d, 0, tableLeafCell(1, nil, "b"), tableLeafCell(2, nil, "a")),
+		buildPage(0x0a, 0, indexLeafCell("a", 2), indexLeafCell("b", 1)))
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	var out bytes.Buffer
+	engine.out = &out
+	for query, want := range map[string]string{
+		"select v from t order by id":               "`--SCAN t\n",
+		"select v from t where v > 'a' order by v":  "`--SEARCH t USING COVERING INDEX iv (v>?)\n",
+		"select v from t order by v":                "|--SCAN t\n`--USE TEMP B-TREE FOR ORDER BY\n",
+		"select v from t where v > 'a' order by id": "|--SEARCH t USING COVERING INDEX iv (v>?)\n`--USE TEMP B-TREE FOR ORDER BY\n",
+	} {
+		out.Reset()
+		if err := engine.ExecuteCommand("sql", "explain query plan "+query); err != nil || out.String() != "QUERY PLAN\n"+want {
+			t.Errorf("%s: got %q, %v; want %q", query, out.String(), err, want)
+		}
+	}
+}
+
+func TestPlannerStatistics(t *testing.T) {
+	tableCell := func(rowid byte) []byte { return []byte{3, rowid, 2, 1, rowid * 10} }
+	pages := memoryPages{
+		2: buildPage(0x05, 4, []byte{0, 0, 0, 3, 2}),
+		3: buildPage(0x0d, 0, tableCell(1), tableCell(2)),
+		4: buildPage(0x0d, 0, tableCell(3), tableCell(4)),
+	}
+	entries, depth, perLeaf, err := NewBTree(pages, 2, BTreeTypeTable).Cursor(context.Background()).Estimate()
+	if err != nil || entries != 4 || depth != 2 || perLeaf != 2 {
+		t.Errorf("Estimate: got %v entries, depth %d, %v per leaf, %v", entries, depth, perLeaf, err)
+	}
+
+	if got := statNumbers("30000 4286 268 unordered"); !slices.Equal(got, []float64{30000, 4286, 268}) {
+		t.Errorf("statNumbers: got %v", got)
+	}
+
+	keys := []IndexKeyColumn{{Name: "n"}}
+	sample := func(n, lt, eq int64) IndexSample {
+		return IndexSample{Key: IndexKey{NewIntegerValue(n), NewIntegerValue(1)}, Lt: []float64{float64(lt), 0}, Eq: []float64{float64(eq), 1}}
+	}
+	indexStats := &IndexStats{Rows: 1000, RowsPerKey: []float64{7}, Samples: []IndexSample{sample(10, 0, 100), sample(20, 100, 50), sample(30, 500, 500)}}
+	point := func(n int64) IndexRange {
+		bound := &IndexBound{Value: NewIntegerValue(n), Inclusive: true}
+		return IndexRange{Lower: bound, Upper: bound}
+	}
+	for _, tt := range []struct {
+		r    IndexRange
+		want float64
+	}{
+		{point(20), 50},
+		{point(25), 7},
+		{IndexRange{Lower: &IndexBound{Value: NewIntegerValue(20), Inclusive: true}, Upper: &IndexBound{Value: NewIntegerValue(30)}}, 400},
+		{IndexRange{Lower: &IndexBound{Value: NewIntegerValue(30)}}, 500},
+	} {
+		if got := indexStats.rangeRows(tt.r, keys); got != tt.want {
+			t.Errorf("rangeRows(%+v) = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+
+	// a key matching half the table costs more through the index than a scan
+	index := keyColumnsIndex{keys: keys}
+	stats := &TableStats{Rows: 200000, Depth: 3, PerPage: 100, Indexes: map[string]*IndexStats{
+		"idx": {Rows: 200000, Depth: 2, PerPage: 200},
+	}}
+	choice := &indexChoice{index: "idx", ranges: []IndexRange{point(1)}}
+	for perKey, cheaper := range map[float64]bool{100000: false, 10: true} {
+		stats.Indexes["idx"].RowsPerKey = []float64{perKey}
+		if got := choice.cost(stats, index) < stats.scanCost(); got != cheaper {
+			t.Errorf("%v rows per key: index cheaper = %v, want %v", perKey, got, cheaper)
+		}
+	}
+	if got := estimatedRowsPerKey(uniqueIndex{index}, 1000); !slices.Equal(got, []float64{1}) {
+		t.Errorf("unique rows per key: got %v", got)
+	}
+}
+
+// uniqueIndex is an Index reporting itself unique
+type uniqueIndex struct{ Index }
+
+func (uniqueIndex) IsUnique() bool { return true }
+
+func TestShell(t *testing.T) {
+	statements, rest := splitStatements("select 1; select ';' -- a;\n, [x;y] /* ; */ from t;\nselect 2")
+	if !slices.Equal(statements, []string{"select 1", "select ';' -- a;\n, [x;y] /* ; */ from t"}) || rest != "\nselect 2" {
+		t.Errorf("splitStatements: got %q, rest %q", statements, rest)
+	}
+
+	var out bytes.Buffer
+	editor := NewLineEditor(strings.NewReader("abc\x1b[D\x1b[DX\r\x1b[A\x01Y\x05\x7fZ\rwords two\x17\x15kept\r\x03\x04"), &out)
+	for _, want := range []string{"aXbc", "YaXbZ", "kept"} {
+		line, err := editor.ReadLine("> ")
+		if err != nil || line != want {
+			t.Errorf("ReadLine: got %q, %v; want %q", line, err, want)
+		}
+		editor.History = append(editor.History, line)
+	}
+	if _, err := editor.ReadLine("> "); err != errInterrupted {
+		t.Errorf("Ctrl-C: got %v", err)
+	}
+	if _, err := editor.ReadLine("> "); err != io.EOF {
+		t.Errorf("Ctrl-D: got %v", err)
+	}
+
+	dbPath := "../sample.db"
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		t.Skip("sample.db not found")
+	}
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	var errOut bytes.Buffer
+	shell := NewShell(engine, strings.NewReader(".bogus\nselect name from nowhere\n  where 1;\n.quit\n.never\n"), &out, &errOut)
+	shell.historyPath = ""
+	if err := shell.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := errOut.String(); got != "Error: unknown command: .bogus\nError: table not found: nowhere\n" {
+		t.Errorf("shell errors: got %q", got)
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	rows := [][]Value{
+		{NewIntegerValue(1), NewTextValue(`say "hi", ok`), NewRealValue(1.5), NewBlobValue([]byte{0, 0xff, 'A'})},
+		{NewIntegerValue(2), NewTextValue("Zoë"), NewNullValue(), NewBlobValue(nil)},
+	}
+	columns := []string{"id", "name", "score", "data"}
+	format := func(configure func(*OutputSettings)) string {
+		settings := DefaultOutputSettings()
+		configure(&settings)
+		var out bytes.Buffer
+		formatter := NewOutputFormatter(&out, settings)
+		if err := formatter.Begin(columns); err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := formatter.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := formatter.End(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	mode := func(name string) func(*OutputSettings) {
+		return func(s *OutputSettings) {
+			if err := s.SetMode(name, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		configure func(*OutputSettings)
+		want      string
+	}{
+		{"list", func(s *OutputSettings) { s.NullValue = "NULL"; s.Headers = true }, "id|name|score|data\n1|say \"hi\", ok|1.5|\x00\xffA\n2|Zoë|NULL|\n"},
+		{"csv", mode("csv"), "1,\"say \"\"hi\"\", ok\",1.5,\"\x00\xffA\"\r\n2,\"Zoë\",,\"\"\r\n"},
+		{"quote", mode("quote"), "1,'say \"hi\", ok',1.5,X'00ff41'\n2,'Zoë',NULL,X''\n"},
+		{"json", mode("json"), "[{\"id\":1,\"name\":\"say \\\"hi\\\", ok\",\"score\":1.5,\"data\":\"\\u0000\\u00ffA\"},\n{\"id\":2,\"name\":\"Zoë\",\"score\":null,\"data\":\"\"}]\n"},
+		{"insert", mode("insert"), "INSERT INTO \"table\" VALUES(1,'say \"hi\", ok',1.5,X'00ff41');\nINSERT INTO \"table\" VALUES(2,'Zoë',NULL,X'');\n"},
+		{"line", mode("line"), "   id = 1\n name = say \"hi\", ok\nscore = 1.5\n data = \x00\xffA\n\n   id = 2\n name = Zoë\nscore = \n data = \n"},
+	}
+	for _, tt := range tests {
+		if got := format(tt.configure); got != tt.want {
+			t.Errorf("%s mode: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	rows = rows[1:]
+	if got, want := format(mode("markdown")), "| id | name | score | data |\n|----|------|-------|------|\n| 2  | Zoë  |       |      |\n"; got != want {
+		t.Errorf("markdown mode: got %q, want %q", got, want)
+	}
+	if got, want := format(mode("box")), "┌────┬──────┬───────┬──────┐\n│ id │ name │ score │ data │\n├────┼──────┼───────┼──────┤\n│ 2  │ Zoë  │       │      │\n└────┴──────┴───────┴──────┘\n"; got != want {
+		t.Errorf("box mode: got %q, want %q", got, want)
+	}
+
+	rows = nil
+	if got := format(mode("json")); got != "" {
+		t.Errorf("json mode without rows: got %q", got)
+	}
+	if got := commandArgs(`a 'b\tc' "d\te" f\n`); !slices.Equal(got, []string{"a", `b\tc`, "d\te", "f\n"}) {
+		t.Errorf("commandArgs: got %q", got)
+	}
+
+	dbPath := "../sample.db"
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		t.Skip("sample.db not found")
+	}
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	// the dot commands write to the engine's writer too
+	for _, command := range []string{".tables", ".indexes", ".schema"} {
+		var out bytes.Buffer
+		engine.out = &out
+		if err := engine.ExecuteCommand(command, ""); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+		if out.Len() == 0 {
+			t.Errorf("%s wrote nothing to the engine's writer", command)
+		}
+	}
+}
+
+func TestWAL(t *testing.T) {
+	original, err := os.ReadFile("../sample.db")
+	if err != nil {
+		t.Skip("sample.db not found")
+	}
+	pageSize := int(binary.BigEndian.Uint16(original[16:]))
+	dir := t.TempDir()
+	dbPath := dir + "/wal.db"
+	if err := os.WriteFile(dbPath, original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// writeWAL writes a log whose frames hold page 1 with the given user versions;
+	// sizes[i] is the commit size of frame i, 0 for none
+	writeWAL := func(versions []uint32, sizes []uint32, corrupt int) {
+		header := make([]byte, walHeaderSize)
+		binary.BigEndian.PutUint32(header[0:], walMagicBigEndian)
+		binary.BigEndian.PutUint32(header[4:], 3007000)
+		binary.BigEndian.PutUint32(header[8:], uint32(pageSize))
+		binary.BigEndian.PutUint32(header[16:], 0x1234)
+		binary.BigEndian.PutUint32(header[20:], 0x5678)
+		s0, s1 := walChecksum(header[:24], true, 0, 0)
+		binary.BigEndian.PutUint32(header[24:], s0)
+		binary.BigEndian.PutUint32(header[28:], s1)
+		wal := header
+		for i, version := range versions {
+			frame := make([]byte, walFrameHeaderSize+pageSize)
+			copy(frame[walFrameHeaderSize:], original[:pageSize])
+			binary.BigEndian.PutUint32(frame[walFrameHeaderSize+60:], version)
+			binary.BigEndian.PutUint32(frame[0:], 1)
+			binary.BigEndian.PutUint32(frame[4:], sizes[i])
+			copy(frame[8:16], header[16:24])
+			s0, s1 = walChecksum(frame[:8], true, s0, s1)
+			s0, s1 = walChecksum(frame[walFrameHeaderSize:], true, s0, s1)
+			if i == corrupt {
+				s0++
+			}
+			binary.BigEndian.PutUint32(frame[16:], s0)
+			binary.BigEndian.PutUint32(frame[20:], s1)
+			wal = append(wal, frame...)
+		}
+		if err := os.WriteFile(dbPath+"-wal", wal, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	userVersion := func() uint32 {
+		db, err := NewDatabaseRaw(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		page, err := db.ReadPage(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.BigEndian.Uint32(page[60:]); got != db.GetHeader().UserVersion {
+			t.Errorf("header user version %d, page 1 has %d", db.GetHeader().UserVersion, got)
+		}
+		return db.GetHeader().UserVersion
+	}
+
+	pages := uint32(len(original) / pageSize)
+	writeWAL([]uint32{5, 7, 9}, []uint32{pages, pages, 0}, -1)
+	if got := userVersion(); got != 7 {
+		t.Errorf("committed frames: got user version %d, want 7", got)
+	}
+	db, err := NewDatabaseRaw(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots := db.Snapshots()
+	if len(snapshots) != 2 || snapshots[0].Frame != 1 || snapshots[1].Frame != 2 || !snapshots[1].Current || snapshots[0].Current {
+		t.Errorf("snapshots: got %+v", snapshots)
+	}
+	if err := db.UseSnapshot(1); err != nil || db.GetHeader().UserVersion != 5 {
+		t.Errorf("snapshot 1: got user version %d, %v", db.GetHeader().UserVersion, err)
+	}
+	if err := db.UseSnapshot(3); err == nil {
+		t.Errorf("snapshot of an uncommitted frame: no error")
+	}
+	if err := db.UseSnapshot(0); err != nil || db.GetHeader().UserVersion != 7 {
+		t.Errorf("latest snapshot: got user version %d, %v", db.GetHeader().UserVersion, err)
+	}
+	db.Close()
+
+	// once a checkpoint has copied frame 2 into the database file, commit 1 is gone
+	shm := make([]byte, 136)
+	binary.BigEndian.PutUint32(shm[32:], 0x1234)
+	binary.BigEndian.PutUint32(shm[36:], 0x5678)
+	binary.NativeEndian.PutUint32(shm[96:], 2)
+	if err := os.WriteFile(dbPath+"-shm", shm, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = NewDatabaseRaw(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UseSnapshot(1); err == nil {
+		t.Errorf("snapshot before the checkpoint: no error")
+	}
+	db.Close()
+	os.Remove(dbPath + "-shm")
+
+	writeWAL([]uint32{5, 7}, []uint32{pages, pages}, 1)
+	if got := userVersion(); got != 5 {
+		t.Errorf("frames after a bad checksum: got user version %d, want 5", got)
+	}
+	writeWAL(nil, nil, -1)
+	if got := userVersion(); got != 0 {
+		t.Errorf("empty log: got user version %d, want 0", got)
+	}
+}
+
+func TestHotJournal(t *testing.T) {
+	original, err := os.ReadFile("../sample.db")
+	if err != nil {
+		t.Skip("sample.db not found")
+	}
+	pageSize := int(binary.BigEndian.Uint16(original[16:]))
+	dbPath := t.TempDir() + "/journal.db"
+
+	// a crashed writer changed the user version on page 1 after journaling the page
+	changed := slices.Clone(original)
+	binary.BigEndian.PutUint32(changed[60:], 42)
+	if err := os.WriteFile(dbPath, changed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeJournal := func(checksumDelta uint32) {
+		journal := make([]byte, 512)
+		copy(journal, journalMagic)
+		binary.BigEndian.PutUint32(journal[8:], 1)
+		binary.BigEndian.PutUint32(journal[12:], 0xabcd)
+		binary.BigEndian.PutUint32(journal[16:], uint32(len(original)/pageSize))
+		binary.BigEndian.PutUint32(journal[20:], 512)
+		binary.BigEndian.PutUint32(journal[24:], uint32(pageSize))
+		journal = binary.BigEndian.AppendUint32(journal, 1)
+		journal = append(journal, original[:pageSize]...)
+		journal = binary.BigEndian.AppendUint32(journal, journalChecksum(original[:pageSize], 0xabcd)+checksumDelta)
+		if err := os.WriteFile(dbPath+"-journal", journal, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := func(level ValidationLevel) (uint32, []error, error) {
+		var warnings []error
+		db, err := NewDatabaseRaw(dbPath, WithValidation(level), WithLogger(func(err error) { warnings = append(warnings, err) }))
+		if err != nil {
+			return 0, warnings, err
+		}
+		defer db.Close()
+		page, err := db.ReadPage(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.BigEndian.Uint32(page[60:]); got != db.GetHeader().UserVersion {
+			t.Errorf("header user version %d, page 1 has %d", db.GetHeader().UserVersion, got)
+		}
+		return db.GetHeader().UserVersion, warnings, nil
+	}
+
+	writeJournal(0)
+	if version, warnings, err := open(ValidationBasic); err != nil || version != 0 || len(warnings) != 1 || !errors.Is(warnings[0], ErrHotJournal) {
+		t.Errorf("basic validation: got user version %d, warnings %v, error %v", version, warnings, err)
+	}
+	if version, warnings, err := open(ValidationNone); err != nil || version != 0 || len(warnings) != 0 {
+		t.Errorf("no validation: got user version %d, warnings %v, error %v", version, warnings, err)
+	}
+	if _, _, err := open(ValidationStrict); !errors.Is(err, ErrHotJournal) {
+		t.Errorf("strict validation: got error %v", err)
+	}
+
+	// a record with a bad checksum was never fully written
+	writeJournal(1)
+	if version, _, err := open(ValidationBasic); err != nil || version != 42 {
+		t.Errorf("torn record: got user version %d, error %v", version, err)
+	}
+
+	// journal_mode=PERSIST zeroes the header once the transaction commits
+	if err := os.WriteFile(dbPath+"-journal", make([]byte, 512), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if version, warnings, err := open(ValidationStrict); err != nil || version != 42 || len(warnings) != 0 {
+		t.Errorf("persisted journal: got user version %d, warnings %v, error %v", version, warnings, err)
+	}
+}
+
+func TestTextEncoding(t *testing.T) {
+	// "hé" and a character outside the BMP, stored as UTF-16le and UTF-16be
+	le := []byte{0x68, 0x00, 0xe9, 0x00, 0x01, 0xd8, 0x00, 0xdc}
+	be := []byte{0x00, 0x68, 0x00, 0xe9, 0xd8, 0x01, 0xdc, 0x00}
+	for _, tt := range []struct {
+		encoding TextEncoding
+		data     []byte
+	}{
+		{TextEncodingUTF16LE, le},
+		{TextEncodingUTF16BE, be},
+		{TextEncodingUTF16LE, append(slices.Clone(le), 0x00)}, // an odd trailing byte is dropped
+	} {
+		if got := string(tt.encoding.Decode(tt.data)); got != "hé\U00010400" {
+			t.Errorf("%s: decoded %q", tt.encoding, got)
+		}
+	}
+
+	// a record's TEXT values arrive as UTF-8, with their serial types adjusted
+	payload := append([]byte{0x03, byte(13 + 2*len(le)), 0x01}, append(le, 0x07)...)
+	record, err := parseRecord(payload, TextEncodingUTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := record.ColumnValues()
+	if len(values) != 2 || values[0].Type() != ValueTypeText || values[0].String() != "hé\U00010400" || values[1].String() != "7" {
+		t.Errorf("record values: got %v", values)
+	}
+
+	// BINARY compares the stored bytes: UTF-16le puts U+0100 before U+2000 before
+	// U+01FF, and UTF-16be puts U+10400 before U+E000
+	words := []string{"ab\u01ff", "ab\U00010400", "ab\u2000", "ab\ue000", "ab\u0100", "ab", "abc"}
+	for _, tt := range []struct {
+		encoding TextEncoding
+		want     string
+	}{
+		{TextEncodingUTF8, "ab abc ab\u0100 ab\u01ff ab\u2000 ab\ue000 ab\U00010400"},
+		{TextEncodingUTF16LE, "ab ab\u0100 ab\u2000 ab\ue000 ab\U00010400 abc ab\u01ff"},
+		{TextEncodingUTF16BE, "ab abc ab\u0100 ab\u01ff ab\u2000 ab\U00010400 ab\ue000"},
+	} {
+		columns := []IndexKeyColumn{{Name: "w", encoding: tt.encoding}}
+		keys := make([]IndexKey, len(words))
+		for i, word := range words {
+			keys[i] = IndexKey{NewTextValue(word)}
+		}
+		slices.SortFunc(keys, func(a, b IndexKey) int { return compareIndexKeys(columns, a, b) })
+		var order []string
+		for _, key := range keys {
+			order = append(order, key[0].String())
+		}
+		if got := strings.Join(order, " "); got != tt.want {
+			t.Errorf("%s order: got %q", tt.encoding, got)
+		}
+	}
+
+	// NOCASE compares UTF-8 in every encoding
+	if c := (IndexKeyColumn{Collation: "NOCASE", encoding: TextEncodingUTF16LE}).collation()("ab\u01ff", "AB\u2000"); c >= 0 {
+		t.Errorf("nocase comparison: got %d", c)
+	}
+}
+
+func TestWithoutRowid(t *testing.T) {
+	for _, tt := range []struct {
+		sql  string
+		want []IndexKeyColumn
+		ok   bool
+	}{
+		{"CREATE TABLE kv(k TEXT PRIMARY KEY DESC, v INT) WITHOUT ROWID", []IndexKeyColumn{{Name: "k", Desc: true}}, true},
+		{`CREATE TABLE p(a INT, "b" TEXT, CONSTRAINT pk PRIMARY KEY ("b" COLLATE nocase, a DESC)) without rowid`,
+			[]IndexKeyColumn{{Name: "b", Collation: "nocase"}, {Name: "a", Desc: true}}, true},
+		{"CREATE TABLE t(a INTEGER PRIMARY KEY, b TEXT)", nil, false},
+	} {
+		got, ok := withoutRowidKey(tt.sql)
+		if !slices.Equal(got, tt.want) || ok != tt.ok {
+			t.Errorf("%s: got %+v, %v; want %+v, %v", tt.sql, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// records hold the primary key (b, a DESC) first, then c
+	cell := func(b string, a, c byte) []byte {
+		record := append([]byte{4, byte(13 + 2*len(b)), 1, 1}, append([]byte(b), a, c)...)
+		return append([]byte{byte(len(record))}, record...)
+	}
+	pages := memoryPages{2: buildPage(0x0a, 0, cell("x", 2, 20), cell("x", 1, 10), cell("y", 5, 50))}
+	schema := &SchemaRecord{Type: "table", Name: "pair", TblName: "pair", RootPage: 2,
+		SQL: "CREATE TABLE pair(a INT, b TEXT, c INT, PRIMARY KEY(b, a DESC)) WITHOUT ROWID"}
+	key, _ := withoutRowidKey(schema.SQL)
+	table := NewWithoutRowidTable(pages, schema, key, TextEncodingUTF8)
+	ctx := context.Background()
+
+	format := func(row *Row) string {
+		var values []string
+		for _, value := range row.Values {
+			values = append(values, value.String())
+		}
+		return strings.Join(values, "|")
+	}
+	var rows []string
+	for row, err := range table.Scan(ctx) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, format(row))
+	}
+	if got := strings.Join(rows, ","); got != "2|x|20,1|x|10,5|y|50" {
+		t.Errorf("scan: got %q", got)
+	}
+
+	entries, err := table.primaryKey.SearchByKey(ctx, IndexKey{NewTextValue("x"), NewIntegerValue(1)})
+	if err != nil || len(entries) != 1 || format(entries[0].Row) != "1|x|10" {
+		t.Errorf("primary key lookup: got %+v, %v", entries, err)
+	}
+	rows = nil
+	for entry, err := range table.primaryKey.ScanFrom(ctx, IndexKey{NewTextValue("x"), NewIntegerValue(1)}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, format(entry.Row))
+	}
+	if got := strings.Join(rows, ","); got != "1|x|10,5|y|50" {
+		t.Errorf("primary key range: got %q", got)
+	}
+
+	if _, err := table.GetRowByRowid(ctx, 1); err == nil {
+		t.Error("rowid lookup in a WITHOUT ROWID table succeeded")
+	}
+}
+
+func TestDBInfoAutoindex(t *testing.T) {
+	// the primary key index of CREATE TABLE t(k TEXT PRIMARY KEY, v INT) is listed
+	// in the schema with a NULL sql column
+	dbPath := writeTestDatabase(t,
+		[][]byte{
+			tableLeafCell(1, "table", "t", "t", 2, "CREATE TABLE t(k TEXT PRIMARY KEY, v INT)"),
+			tableLeafCell(2, "index", "sqlite_autoindex_t_1", "t", 3, nil),
+		},
+		buildPage(0x0d, 0, tableLeafCell(1, "k1", 10), tableLeafCell(2, "k2", 20)),
+		buildPage(0x0a, 0, indexLeafCell("k1", 1), indexLeafCell("k2", 2)))
+
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	var out bytes.Buffer
+	engine.out = &out
+	if err := engine.ExecuteCommand(".dbinfo", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "number of tables:    1\n") || !strings.Contains(out.String(), "number of indexes:   1\n") {
+		t.Errorf(".dbinfo: got %q", out.String())
+	}
+}
+
+func TestAutoindex(t *testing.T) {
+	for _, tt := range []struct {
+		sql, rowidAlias string
+		want            [][]IndexKeyColumn
+	}{
+		{"CREATE TABLE t(a TEXT UNIQUE, b TEXT PRIMARY KEY DESC, c INT, UNIQUE(c, a COLLATE nocase), UNIQUE(a))", "",
+			[][]IndexKeyColumn{{{Name: "a"}}, {{Name: "b", Desc: true}}, {{Name: "c"}, {Name: "a", Collation: "nocase"}}}},
+		{"CREATE TABLE u(id INTEGER PRIMARY KEY, x TEXT UNIQUE)", "id", [][]IndexKeyColumn{{{Name: "x"}}}},
+		{"CREATE TABLE w(k TEXT, v TEXT UNIQUE, PRIMARY KEY(k)) WITHOUT ROWID", "", [][]IndexKeyColumn{{{Name: "v"}}, {{Name: "k"}}}},
+	} {
+		if got := autoindexKeys(tt.sql, tt.rowidAlias); !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("%s: got %+v, want %+v", tt.sql, got, tt.want)
+		}
+	}
+
+	// the composite primary key of p(x, y) gets sqlite_autoindex_p_1
+	dbPath := writeTestDatabase(t,
+		[][]byte{
+			tableLeafCell(1, "table", "p", "p", 2, "CREATE TABLE p(x TEXT, y INT, v INT, PRIMARY KEY(x, y))"),
+			tableLeafCell(2, "index", "sqlite_autoindex_p_1", "p", 3, nil),
+		},
+		buildPage(0x0d, 0, tableLeafCell(1, "a", 2, 10), tableLeafCell(2, "a", 1, 20), tableLeafCell(3, "b", 1, 30)),
+		buildPage(0x0a, 0, indexLeafCell("a", 1, 2), indexLeafCell("a", 2, 1), indexLeafCell("b", 1, 3)))
+	engine, err := NewSqliteEngine(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	// the planner is given the index, keyed by the primary key columns
+	ctx := context.Background()
+	indexes, err := engine.db.GetTableIndexes(ctx, "p")
+	if err != nil || len(indexes) != 1 || !indexes[0].IsUnique() ||
+		!slices.Equal(indexes[0].GetKeyColumns(), []IndexKeyColumn{{Name: "x", encoding: TextEncodingUTF8}, {Name: "y", encoding: TextEncodingUTF8}}) {
+		t.Fatalf("table indexes: got %v, %v", indexes, err)
+	}
+	entries, err := indexes[0].SearchByKey(ctx, IndexKey{NewTextValue("a"), NewIntegerValue(2)})
+	if err != nil || len(entries) != 1 || entries[0].Rowid != 1 {
+		t.Errorf("index lookup: got %+v, %v", entries, err)
+	}
+	var out bytes.Buffer
+	engine.out = &out
+	if err := engine.ExecuteCommand("sql", "select v from p where x = 'a' and y = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "20\n" {
+		t.Errorf("lookup: got %q", out.String())
+	}
 }
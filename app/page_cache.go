@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// PageCacheStats holds the counters of a page cache
+type PageCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Pages     int // pages currently cached
+	Capacity  int
+}
+
+// PageCache is a fixed-size LRU cache of database pages, safe for concurrent use.
+// Cached pages are shared between readers and must not be modified.
+type PageCache struct {
+	mu       sync.Mutex
+	capacity int
+	pages    map[int]*list.Element
+	order    *list.List // most recently used page at the front
+	stats    PageCacheStats
+}
+
+// cachedPage is the value stored in the LRU list
+type cachedPage struct {
+	pageNum int
+	data    []byte
+}
+
+// NewPageCache creates a page cache holding up to capacity pages.
+// A capacity of zero or less disables caching; lookups still count as misses.
+func NewPageCache(capacity int) *PageCache {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &PageCache{
+		capacity: capacity,
+		pages:    make(map[int]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// Get returns a cached page and marks it as most recently used
+func (pc *PageCache) Get(pageNum int) ([]byte, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	element, ok := pc.pages[pageNum]
+	if !ok {
+		pc.stats.Misses++
+		return nil, false
+	}
+	pc.stats.Hits++
+	pc.order.MoveToFront(element)
+	return element.Value.(*cachedPage).data, true
+}
+
+// Put adds a page to the cache, evicting the least recently used page when full
+func (pc *PageCache) Put(pageNum int, data []byte) {
+	if pc.capacity == 0 {
+		return
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	// another reader may have loaded the same page concurrently
+	if element, ok := pc.pages[pageNum]; ok {
+		element.Value.(*cachedPage).data = data
+		pc.order.MoveToFront(element)
+		return
+	}
+
+	if pc.order.Len() >= pc.capacity {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.pages, oldest.Value.(*cachedPage).pageNum)
+		pc.stats.Evictions++
+	}
+	pc.pages[pageNum] = pc.order.PushFront(&cachedPage{pageNum: pageNum, data: data})
+}
+
+// Clear drops every cached page, for when the pages a reader sees change
+func (pc *PageCache) Clear() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.pages = make(map[int]*list.Element, pc.capacity)
+	pc.order.Init()
+}
+
+// Stats returns a snapshot of the cache counters
+func (pc *PageCache) Stats() PageCacheStats {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	stats := pc.stats
+	stats.Pages = pc.order.Len()
+	stats.Capacity = pc.capacity
+	return stats
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PlanNode is one step of a query plan, described in the words SQLite's
+// EXPLAIN QUERY PLAN uses. Steps run in order; children are nested under
+// their parent step.
+type PlanNode struct {
+	Detail   string
+	Children []*PlanNode
+}
+
+// FormatQueryPlan renders plan steps as a tree in the sqlite3 shell's format
+func FormatQueryPlan(steps []*PlanNode) string {
+	var b strings.Builder
+	b.WriteString("QUERY PLAN\n")
+	writePlanNodes(&b, steps, "")
+	return b.String()
+}
+
+func writePlanNodes(b *strings.Builder, nodes []*PlanNode, indent string) {
+	for i, node := range nodes {
+		branch, nested := "|--", "|  "
+		if i == len(nodes)-1 {
+			branch, nested = "`--", "   "
+		}
+		b.WriteString(indent + branch + node.Detail + "\n")
+		writePlanNodes(b, node.Children, indent+nested)
+	}
+}
+
+// Describe returns the plan step of the table scan a plan executes, for the
+// table called name in the query
+func (p *QueryPlan) Describe(name string) *PlanNode {
+	switch {
+	case p.UseRowid:
+		return &PlanNode{Detail: fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (%s)", name, rangeConstraints(nil, "rowid", p.Ranges))}
+	case p.UseIndex:
+		index := "INDEX " + p.IndexName
+		switch {
+		case p.IndexName == p.TableName:
+			// the primary key of a WITHOUT ROWID table goes by the table's name
+			index = "PRIMARY KEY"
+		case p.Covering:
+			index = "COVERING INDEX " + p.IndexName
+		}
+		var equal []string
+		var column string
+		if len(p.Ranges) > 0 {
+			n := min(len(p.Ranges[0].Equal), len(p.IndexColumns))
+			equal = p.IndexColumns[:n]
+			if n < len(p.IndexColumns) {
+				column = p.IndexColumns[n]
+			}
+		}
+		return &PlanNode{Detail: fmt.Sprintf("SEARCH %s USING %s (%s)", name, index, rangeConstraints(equal, column, p.Ranges))}
+	default:
+		return &PlanNode{Detail: "SCAN " + name}
+	}
+}
+
+// rangeConstraints lists the key constraints of a range scan the way SQLite
+// does: equalities on the leading columns, then the bounds of the next column
+func rangeConstraints(equal []string, column string, ranges []IndexRange) string {
+	var terms []string
+	for _, name := range equal {
+		terms = append(terms, name+"=?")
+	}
+
+	var point, lower, upper bool
+	for _, r := range ranges {
+		_, isPoint := r.point()
+		point = point || isPoint
+		lower = lower || !isPoint && r.Lower != nil
+		upper = upper || !isPoint && r.Upper != nil
+	}
+	switch {
+	case column == "":
+	case point && !lower && !upper:
+		terms = append(terms, column+"=?")
+	default:
+		if lower {
+			terms = append(terms, column+">?")
+		}
+		if upper {
+			terms = append(terms, column+"<?")
+		}
+	}
+	return strings.Join(terms, " AND ")
+}
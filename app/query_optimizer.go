@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/xwb1989/sqlparser"
 )
@@ -27,9 +28,9 @@ func (qo *QueryOptimizer) OptimizeSelect(query *sqlparser.Select) (*QueryPlan, e
 		UseIndex:  false,
 	}
 
-	// Extract table name
+	// Extract table name; joins are planned by the engine, not here
 	tableName := ""
-	if len(query.From) > 0 {
+	if len(query.From) == 1 {
 		if tableExpr, ok := query.From[0].(*sqlparser.AliasedTableExpr); ok {
 			if table, ok := tableExpr.Expr.(sqlparser.TableName); ok {
 				tableName = table.Name.String()
@@ -57,158 +58,669 @@ func (qo *QueryOptimizer) OptimizeSelect(query *sqlparser.Select) (*QueryPlan, e
 	}
 
 	// Try to find an index that can be used
-	indexName, indexValue, err := qo.analyzeWhereClause(query.Where.Expr, table, ctx)
+	choice, err := qo.analyzeWhereClause(query, table, ctx)
 	if err != nil {
 		plan.UseIndex = false
 		return plan, nil // Not an error, just can't use index
 	}
 
-	if indexName != "" {
-		plan.UseIndex = true
-		plan.IndexName = indexName
-		plan.IndexValue = indexValue
+	if choice != nil {
+		plan.UseRowid = choice.rowid
+		plan.UseIndex = !choice.rowid
+		plan.IndexName = choice.index
+		plan.IndexColumn = choice.column
+		plan.IndexColumns = choice.order
+		plan.IndexDesc = choice.desc
+		plan.Ranges = choice.ranges
+		switch {
+		case choice.equality:
+			plan.IndexValue = choice.ranges[0].Lower.Value
+		case len(choice.ranges[0].Equal) > 0:
+			plan.IndexValue = choice.ranges[0].Equal[0]
+		}
+		plan.Covering = choice.covering
 	}
 
 	return plan, nil
 }
 
-// analyzeWhereClause analyzes the WHERE clause to find potential index usage
-func (qo *QueryOptimizer) analyzeWhereClause(expr sqlparser.Expr, table Table, ctx context.Context) (string, interface{}, error) {
-	switch node := expr.(type) {
-	case *sqlparser.ComparisonExpr:
-		if node.Operator == "=" {
-			// Check if left side is a column name
-			if colName, ok := node.Left.(*sqlparser.ColName); ok {
-				columnName := colName.Name.String()
-
-				// Get available indexes for this table
-				indexes, err := table.GetIndexes(ctx)
-				if err != nil {
-					return "", nil, err
-				}
-
-				// Check if there's an index on this column
-				for _, index := range indexes {
-					// Get index schema to check column
-					schema, err := index.GetSchema(ctx)
-					if err != nil {
-						continue
-					}
-
-					// Check if the index contains this column (iterate over all columns)
-					for _, col := range schema {
-						if strings.EqualFold(col.Name, columnName) { // Case-insensitive comparison
-							// Extract the value to search for
-							if val, ok := node.Right.(*sqlparser.SQLVal); ok {
-								value := string(val.Val)
-								return index.GetName(), value, nil
-							}
-						}
-					}
-				}
+// coversQuery reports whether every column the query reads is a key column of
+// the index or the rowid, so that its rows can be built from the index entries
+// without looking up the table rows
+func coversQuery(query *sqlparser.Select, index Index, columns []Column) bool {
+	if _, ok := index.(*primaryKeyIndex); ok {
+		return true // its entries are the rows
+	}
+	for _, expr := range query.SelectExprs {
+		if _, ok := expr.(*sqlparser.StarExpr); !ok {
+			continue
+		}
+		for _, col := range columns {
+			if !col.Hidden && !col.IsRowid && !coversColumn(col.Name, index, columns) {
+				return false
 			}
 		}
 	}
-
-	return "", nil, nil
+	covered := true
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		// Walk goes on to the siblings of a node that returns false, so a
+		// column that is not covered must not be overwritten by a later one
+		if colName, ok := node.(*sqlparser.ColName); ok && !coversColumn(colName.Name.String(), index, columns) {
+			covered = false
+		}
+		return covered, nil
+	}, query.SelectExprs, query.Where, query.GroupBy, query.Having, query.OrderBy)
+	return covered
 }
 
-// ExecutePlan executes an optimized query plan
-func (qo *QueryOptimizer) ExecutePlan(plan *QueryPlan, selectQuery *sqlparser.Select) ([]Row, error) {
-	ctx := context.Background()
-	table, err := qo.database.GetTable(ctx, plan.TableName)
-	if err != nil {
-		return nil, err
+// coversColumn reports whether an index entry holds the value of the named column
+func coversColumn(name string, index Index, columns []Column) bool {
+	for _, col := range columns {
+		if !strings.EqualFold(col.Name, name) {
+			continue
+		}
+		if col.RowidAlias {
+			return true
+		}
+		return slices.ContainsFunc(index.GetKeyColumns(), func(key IndexKeyColumn) bool {
+			return strings.EqualFold(key.Name, name)
+		})
 	}
+	// the rowid ends every index entry
+	return isRowidName(name)
+}
 
-	if !plan.UseIndex {
-		// No index optimization - return error instead of fallback
-		return nil, fmt.Errorf("no index optimization available for query")
+// indexChoice is a candidate index scan found in a WHERE clause
+type indexChoice struct {
+	index    string // empty when scanning the table B-tree by rowid
+	rowid    bool
+	column   string
+	order    []string     // columns the scan delivers rows sorted on
+	desc     []bool       // whether each order column comes in descending order
+	ranges   []IndexRange // disjoint key ranges in index order
+	equality bool
+	covering bool // the index holds every column the query reads
+}
+
+// selectivity scores how far the WHERE terms narrow a scan, zero when they do not
+// narrow it at all: each equality on a leading key column counts most, then an
+// equality lookup beats a bounded range, which beats a range open on one side
+func (c *indexChoice) selectivity() int {
+	score := 0
+	if len(c.ranges) > 0 {
+		score = 3 * len(c.ranges[0].Equal)
+	}
+	if c.equality {
+		return score + 3
 	}
+	best := 0
+	for _, r := range c.ranges {
+		switch {
+		case r.Lower != nil && r.Upper != nil:
+			best = max(best, 2)
+		case r.Lower != nil || r.Upper != nil:
+			best = max(best, 1)
+		}
+	}
+	return score + best
+}
 
-	// Use index-optimized query with timing
-	return qo.executeIndexQueryWithTiming(table, plan, selectQuery, ctx)
+// point returns the key a choice is limited to, when its ranges hold a single key
+func (c *indexChoice) point() (Value, bool) {
+	if len(c.ranges) != 1 {
+		return nil, false
+	}
+	return c.ranges[0].point()
 }
 
-// executeIndexQueryWithTiming executes a query using an index with detailed timing
-func (qo *QueryOptimizer) executeIndexQueryWithTiming(table Table, plan *QueryPlan, selectQuery *sqlparser.Select, ctx context.Context) ([]Row, error) {
-	// 	overallStart := time.Now()
-	// fmt.Printf("[TIMING] Starting index query execution\n")
+// cost estimates the pages a scan reads: for each range, the pages down to its
+// first entry and the leaf pages holding its entries, plus a table lookup for
+// each entry of an index that does not cover the query
+func (c *indexChoice) cost(stats *TableStats, index Index) float64 {
+	if c.rowid {
+		total := 0.0
+		for _, r := range c.ranges {
+			total += float64(stats.Depth) + stats.rangeRows(r)/stats.PerPage
+		}
+		return total
+	}
+	indexStats, ok := stats.Indexes[c.index]
+	if !ok {
+		return math.Inf(1)
+	}
+	total := 0.0
+	for _, r := range c.ranges {
+		rows := indexStats.rangeRows(r, index.GetKeyColumns())
+		total += float64(indexStats.Depth) + rows/indexStats.PerPage
+		if !c.covering {
+			total += rows * float64(stats.Depth)
+		}
+	}
+	return total
+}
 
-	// Get the index with timing
-	// 	indexLookupStart := time.Now()
+// analyzeWhereClause chooses how to read the rows of a query. Top-level AND terms
+// comparing key columns with constants narrow each index's scan: equalities on a
+// leading run of key columns, then ranges on the key column after them. The scan
+// estimated to read the fewest pages wins, which may be the full table scan,
+// returned as a nil choice.
+func (qo *QueryOptimizer) analyzeWhereClause(query *sqlparser.Select, table Table, ctx context.Context) (*indexChoice, error) {
+	// Get available indexes for this table
 	indexes, err := table.GetIndexes(ctx)
 	if err != nil {
 		return nil, err
 	}
+	columns, err := table.GetSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := qo.database.GetTableStats(ctx, table.GetName())
+	if err != nil {
+		return nil, err
+	}
+	terms := splitConjuncts(query.Where.Expr)
 
-	var targetIndex Index
+	var best *indexChoice
+	bestCost := stats.scanCost()
+	var candidates []*indexChoice
+	if !table.WithoutRowid() {
+		candidates = append(candidates, rowidChoice(terms, columns))
+	}
 	for _, index := range indexes {
-		if index.GetName() == plan.IndexName {
-			targetIndex = index
+		// A partial index lacks the rows its WHERE clause excludes
+		if index.IsPartial() {
+			continue
+		}
+		choice := indexScanChoice(index, columns, terms)
+		choice.covering = coversQuery(query, index, columns)
+		candidates = append(candidates, choice)
+	}
+	for _, choice := range candidates {
+		if choice.selectivity() == 0 {
+			continue
+		}
+		index, _ := table.GetIndexByName(choice.index)
+		if cost := choice.cost(stats, index); cost < bestCost {
+			best, bestCost = choice, cost
+		}
+	}
+	return best, nil
+}
+
+// indexScanChoice narrows a scan of index with the WHERE terms on its key columns
+func indexScanChoice(index Index, columns []Column, terms []sqlparser.Expr) *indexChoice {
+	choice := &indexChoice{index: index.GetName(), ranges: []IndexRange{{}}}
+
+	var equal []Value
+	for _, key := range index.GetKeyColumns() {
+		column := keyTableColumn(key, columns)
+		if column == nil {
 			break
 		}
+		choice.order = append(choice.order, column.Name)
+		choice.desc = append(choice.desc, key.Desc)
+		if choice.column == "" {
+			choice.column = column.Name
+		}
+		if len(choice.order) > len(equal)+1 {
+			continue // only the column after the equalities can be narrowed
+		}
+
+		step := &indexChoice{ranges: []IndexRange{{Desc: key.Desc, Collation: key.collation()}}}
+		for _, term := range terms {
+			step.narrow(term, column)
+		}
+		if value, ok := step.point(); ok {
+			equal = append(equal, value)
+			continue
+		}
+		if step.selectivity() > 0 {
+			if key.Desc {
+				// the ranges are in ascending key order, the index is not
+				slices.Reverse(step.ranges)
+			}
+			choice.ranges = step.ranges
+		}
 	}
 
-	if targetIndex == nil {
-		return nil, fmt.Errorf("index %s not found", plan.IndexName)
+	for i := range choice.ranges {
+		choice.ranges[i].Equal = equal
 	}
+	return choice
+}
 
-	// 	indexLookupDuration := time.Since(indexLookupStart)
-	// fmt.Printf("[TIMING] Index lookup took: %v\n", indexLookupDuration)
+// keyTableColumn returns the table column an index key column holds, or nil when
+// the key is an expression or compares with another collation than the column,
+// which WHERE terms on the column use
+func keyTableColumn(key IndexKeyColumn, columns []Column) *Column {
+	if key.Name == "" {
+		return nil
+	}
+	for i := range columns {
+		if strings.EqualFold(columns[i].Name, key.Name) {
+			if !sameCollation(columns[i].Collation, key.Collation) {
+				return nil
+			}
+			return &columns[i]
+		}
+	}
+	return nil
+}
+
+// sameCollation reports whether two collation names select the same collating sequence
+func sameCollation(a, b string) bool {
+	if a == "" {
+		a = "BINARY"
+	}
+	if b == "" {
+		b = "BINARY"
+	}
+	return strings.EqualFold(a, b)
+}
+
+// rowidChoice narrows a scan of the table B-tree with the WHERE terms on the rowid,
+// named rowid, oid or _rowid_ (unless a real column takes the name) or through a
+// rowid alias column
+func rowidChoice(terms []sqlparser.Expr, columns []Column) *indexChoice {
+	choice := &indexChoice{rowid: true, ranges: []IndexRange{{}}}
+
+	var names []string
+	for _, name := range []string{"rowid", "oid", "_rowid_"} {
+		shadowed := false
+		for _, col := range columns {
+			shadowed = shadowed || strings.EqualFold(col.Name, name)
+		}
+		if !shadowed {
+			names = append(names, name)
+		}
+	}
+	for _, col := range columns {
+		if col.RowidAlias {
+			choice.column = col.Name
+			names = append(names, col.Name)
+		}
+	}
+
+	for _, name := range names {
+		column := &Column{Name: name, Type: "INTEGER"}
+		for _, term := range terms {
+			choice.narrow(term, column)
+		}
+	}
+	return choice
+}
 
-	// Use the index to find matching rowids with timing
-	// 	indexSearchStart := time.Now()
-	// fmt.Printf("[TIMING] Starting index search for key: %v\n", plan.IndexValue)
-	indexEntries, err := targetIndex.SearchByKey(ctx, plan.IndexValue)
-	// 	indexSearchDuration := time.Since(indexSearchStart)
-	// fmt.Printf("[TIMING] Index search took: %v (found %d entries)\n", indexSearchDuration, len(indexEntries))
+// narrow tightens the scan ranges with a WHERE term on the index column, if it is one
+func (c *indexChoice) narrow(term sqlparser.Expr, column *Column) {
+	affinity := AffinityForType(column.Type)
+	var bound IndexRange
+	switch node := term.(type) {
+	case *sqlparser.ComparisonExpr:
+		operator := node.Operator
+		value, ok := boundValue(node.Left, node.Right, column)
+		if !ok {
+			// a constant on the left compares the other way round
+			if value, ok = boundValue(node.Right, node.Left, column); !ok {
+				return
+			}
+			operator = flippedOperator(operator)
+		}
+		if operator == sqlparser.LikeStr {
+			if sameCollation(column.Collation, "") {
+				c.narrowLike(node, value, affinity)
+			}
+			return
+		}
+		value = ApplyAffinity(value, affinity)
+		switch operator {
+		case sqlparser.EqualStr:
+			bound = IndexRange{Lower: &IndexBound{Value: value, Inclusive: true}, Upper: &IndexBound{Value: value, Inclusive: true}}
+			c.equality = true
+		case sqlparser.GreaterThanStr:
+			bound.Lower = &IndexBound{Value: value}
+		case sqlparser.GreaterEqualStr:
+			bound.Lower = &IndexBound{Value: value, Inclusive: true}
+		case sqlparser.LessThanStr:
+			bound.Upper = &IndexBound{Value: value}
+		case sqlparser.LessEqualStr:
+			bound.Upper = &IndexBound{Value: value, Inclusive: true}
+		default:
+			return
+		}
+	case *sqlparser.RangeCond:
+		if node.Operator != sqlparser.BetweenStr {
+			return
+		}
+		from, okFrom := boundValue(node.Left, node.From, column)
+		to, okTo := boundValue(node.Left, node.To, column)
+		if !okFrom || !okTo {
+			return
+		}
+		bound.Lower = &IndexBound{Value: ApplyAffinity(from, affinity), Inclusive: true}
+		bound.Upper = &IndexBound{Value: ApplyAffinity(to, affinity), Inclusive: true}
+	default:
+		return
+	}
+	c.intersect([]IndexRange{bound})
+}
+
+// maxLikeLetters caps the letters of a LIKE prefix whose case variants are scanned
+const maxLikeLetters = 8
+
+// narrowLike turns "column LIKE 'prefix%'" into ranges. LIKE ignores ASCII case
+// while the index is in BINARY order, so each upper/lower case spelling of the
+// prefix gets its own range; past maxLikeLetters letters the prefix is cut short,
+// which widens the ranges and leaves the rest to the WHERE filter.
+func (c *indexChoice) narrowLike(node *sqlparser.ComparisonExpr, pattern Value, affinity Affinity) {
+	if node.Escape != nil || affinity != AffinityText || StorageClassOf(pattern) != StorageClassText {
+		return
+	}
+	text := pattern.String()
+	prefix := text[:strings.IndexAny(text+"%", "%_")]
+
+	letters := 0
+	for i := 0; i < len(prefix); i++ {
+		if ch := prefix[i] | 0x20; ch >= 'a' && ch <= 'z' {
+			if letters == maxLikeLetters {
+				prefix = prefix[:i]
+				break
+			}
+			letters++
+		}
+	}
+	if prefix == "" {
+		return
+	}
+
+	// Upper case letters sort first, so counting up through the bits of mask
+	// from all-upper to all-lower produces the spellings in index order
+	variants := make([]IndexRange, 0, 1<<letters)
+	for mask := 0; mask < 1<<letters; mask++ {
+		spelling := []byte(prefix)
+		bit := letters - 1
+		for i, ch := range spelling {
+			if lower := ch | 0x20; lower >= 'a' && lower <= 'z' {
+				spelling[i] = lower &^ 0x20
+				if mask&(1<<bit) != 0 {
+					spelling[i] = lower
+				}
+				bit--
+			}
+		}
+		variant := IndexRange{Lower: &IndexBound{Value: NewTextValue(string(spelling)), Inclusive: true}}
+		// every string starting with the spelling sorts before the spelling with its last byte incremented
+		if last := len(spelling) - 1; spelling[last] < 0xff {
+			spelling[last]++
+			variant.Upper = &IndexBound{Value: NewTextValue(string(spelling))}
+		}
+		variants = append(variants, variant)
+	}
+	c.intersect(variants)
+}
+
+// intersect narrows the scan to the parts of its ranges that lie in one of the given ranges
+func (c *indexChoice) intersect(ranges []IndexRange) {
+	var result []IndexRange
+	for _, current := range c.ranges {
+		for _, r := range ranges {
+			narrowed := current
+			narrowed.narrowLower(r.Lower)
+			narrowed.narrowUpper(r.Upper)
+			result = append(result, narrowed)
+		}
+	}
+	c.ranges = result
+}
+
+// narrowLower keeps the tighter of the current and the new lower bound
+func (r *IndexRange) narrowLower(bound *IndexBound) {
+	if bound == nil {
+		return
+	}
+	if r.Lower == nil {
+		r.Lower = bound
+		return
+	}
+	if cmp := r.compare(bound.Value, r.Lower.Value); cmp > 0 || cmp == 0 && !bound.Inclusive {
+		r.Lower = bound
+	}
+}
+
+// narrowUpper keeps the tighter of the current and the new upper bound
+func (r *IndexRange) narrowUpper(bound *IndexBound) {
+	if bound == nil {
+		return
+	}
+	if r.Upper == nil {
+		r.Upper = bound
+		return
+	}
+	if cmp := r.compare(bound.Value, r.Upper.Value); cmp < 0 || cmp == 0 && !bound.Inclusive {
+		r.Upper = bound
+	}
+}
+
+// boundValue evaluates value when columnExpr names column and value is a constant
+func boundValue(columnExpr, value sqlparser.Expr, column *Column) (Value, bool) {
+	colName, ok := columnExpr.(*sqlparser.ColName)
+	if !ok || !strings.EqualFold(colName.Name.String(), column.Name) {
+		return nil, false
+	}
+	// an explicit collation overrides the column's, which orders the index
+	if _, ok := value.(*sqlparser.CollateExpr); ok {
+		return nil, false
+	}
+	// compiling without a schema rejects anything that reads a column
+	compiled, err := NewExprCompiler(nil).Compile(value)
 	if err != nil {
-		// No fallback - return the error
-		return nil, fmt.Errorf("index search failed: %w", err)
+		return nil, false
+	}
+	result, err := compiled.Eval(&Row{})
+	if err != nil || StorageClassOf(result) == StorageClassNull {
+		return nil, false
 	}
+	return result, true
+}
 
-	// If no entries found, return empty result
-	if len(indexEntries) == 0 {
-		return []Row{}, nil
+// flippedOperator returns the operator that compares the operands in swapped order
+func flippedOperator(operator string) string {
+	switch operator {
+	case sqlparser.LessThanStr:
+		return sqlparser.GreaterThanStr
+	case sqlparser.LessEqualStr:
+		return sqlparser.GreaterEqualStr
+	case sqlparser.GreaterThanStr:
+		return sqlparser.LessThanStr
+	case sqlparser.GreaterEqualStr:
+		return sqlparser.LessEqualStr
+	case sqlparser.LikeStr:
+		return "" // the pattern must be on the right
 	}
+	return operator
+}
 
-	// Extract rowids from index entries using parallel fetching with timing
-	// 	rowFetchStart := time.Now()
-	// fmt.Printf("[TIMING] Starting parallel row fetching for %d entries\n", len(indexEntries))
-	matchingRows := qo.fetchRowsParallel(ctx, table, indexEntries)
-	// 	rowFetchDuration := time.Since(rowFetchStart)
-	// fmt.Printf("[TIMING] Parallel row fetching took: %v (fetched %d rows)\n", rowFetchDuration, len(matchingRows))
+// ExecutePlan streams the rows in the key ranges of a plan, in index order for
+// index plans and rowid order for rowid plans. The ranges may hold rows that fail
+// the WHERE clause, which the caller still has to apply.
+func (qo *QueryOptimizer) ExecutePlan(ctx context.Context, plan *QueryPlan) (RowSource, error) {
+	table, err := qo.database.GetTable(ctx, plan.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.UseRowid {
+		return rowidRangeSource(ctx, table, plan.Ranges), nil
+	}
+	if !plan.UseIndex {
+		// No index optimization - return error instead of fallback
+		return nil, fmt.Errorf("no index optimization available for query")
+	}
 
-	// Apply any additional filtering from the WHERE clause if needed with timing
-	if selectQuery.Where != nil {
-		// 		filterStart := time.Now()
-		// fmt.Printf("[TIMING] Starting WHERE clause filtering\n")
-		schema, err := table.GetSchema(ctx)
+	index, ok := table.GetIndexByName(plan.IndexName)
+	if !ok {
+		return nil, fmt.Errorf("index %s not found", plan.IndexName)
+	}
+	row := func(entry *IndexEntry) (*Row, error) {
+		return table.GetRowByRowid(ctx, entry.Rowid)
+	}
+	if plan.Covering {
+		columns, err := table.GetSchema(ctx)
 		if err != nil {
 			return nil, err
 		}
+		row = coveringRows(index, columns)
+	}
+	return indexRangeSource(ctx, index, plan.Ranges, row), nil
+}
 
-		var finalRows []Row
-		for _, row := range matchingRows {
-			match, err := evaluateWhereClause(selectQuery.Where.Expr, row, schema)
-			if err != nil {
-				continue // Skip rows with evaluation errors
+// coveringRows builds rows from the entries of a covering index instead of reading
+// the table. Columns the index does not hold are left NULL; the query does not
+// read them.
+func coveringRows(index Index, columns []Column) func(entry *IndexEntry) (*Row, error) {
+	positions := make([]int, len(index.GetKeyColumns()))
+	for i, key := range index.GetKeyColumns() {
+		positions[i] = -1
+		for _, col := range columns {
+			if key.Name != "" && strings.EqualFold(col.Name, key.Name) {
+				positions[i] = col.Index
 			}
-			if match {
-				finalRows = append(finalRows, row)
+		}
+	}
+
+	return func(entry *IndexEntry) (*Row, error) {
+		if entry.Row != nil {
+			return entry.Row, nil
+		}
+		values := make([]Value, len(columns))
+		for i, col := range columns {
+			values[i] = NewNullValue()
+			if col.RowidAlias {
+				values[i] = NewIntegerValue(entry.Rowid)
+			}
+		}
+		for i, position := range positions {
+			if position >= 0 && i < len(entry.Keys) {
+				values[position] = columnValue(entry.Keys[i], columns[position])
 			}
 		}
-		// 		filterDuration := time.Since(filterStart)
-		// fmt.Printf("[TIMING] WHERE clause filtering took: %v (filtered to %d rows)\n", filterDuration, len(finalRows))
-		// 		overallDuration := time.Since(overallStart)
-		// fmt.Printf("[TIMING] Overall query execution took: %v\n", overallDuration)
-		return finalRows, nil
+		return &Row{Values: values, Rowid: entry.Rowid}, nil
 	}
+}
+
+// rowidRangeSource streams the rows of table whose rowid lies in one of the ranges,
+// in rowid order. A single rowid is a point lookup; any other range seeks the table
+// B-tree to its lower bound and scans up to its upper bound.
+func rowidRangeSource(ctx context.Context, table Table, ranges []IndexRange) RowSource {
+	return func(yield func(*Row, error) bool) {
+		for _, r := range ranges {
+			start, ok := rowidSeekKey(r.Lower)
+			if !ok {
+				continue
+			}
+
+			if r.Lower != nil && r.Upper != nil && r.Lower.Inclusive && r.Upper.Inclusive &&
+				StorageClassOf(r.Lower.Value) == StorageClassInteger && CompareValues(r.Lower.Value, r.Upper.Value) == 0 {
+				row, err := table.GetRowByRowid(ctx, start)
+				if errors.Is(err, ErrRowNotFound) {
+					continue
+				}
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if !yield(row, nil) {
+					return
+				}
+				continue
+			}
 
-	// 	overallDuration := time.Since(overallStart)
-	// fmt.Printf("[TIMING] Overall query execution took: %v\n", overallDuration)
-	return matchingRows, nil
+			for row, err := range table.ScanFrom(ctx, start) {
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				key := NewIntegerValue(row.Rowid)
+				if !r.Contains(key) {
+					if r.past(key) {
+						break
+					}
+					continue
+				}
+				if !yield(row, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// rowidSeekKey returns the rowid to seek to for a lower bound. ok is false when no
+// rowid can satisfy the bound, because TEXT and BLOB sort after every number.
+func rowidSeekKey(bound *IndexBound) (int64, bool) {
+	if bound == nil {
+		return math.MinInt64, true
+	}
+	switch StorageClassOf(bound.Value) {
+	case StorageClassInteger:
+		rowid, _ := bound.Value.Int64()
+		return rowid, true
+	case StorageClassReal:
+		f, _ := bound.Value.Float64()
+		switch {
+		case f >= math.MaxInt64:
+			return 0, false
+		case f <= math.MinInt64:
+			return math.MinInt64, true
+		}
+		return int64(math.Floor(f)), true
+	}
+	return 0, false
+}
+
+// indexRangeSource streams the rows whose index key lies in one of the ranges,
+// in index order. For each range the cursor seeks straight to the equality prefix
+// and the first bound in index order, and the scan stops at the first key past
+// the range. row produces the row of each matching entry.
+func indexRangeSource(ctx context.Context, index Index, ranges []IndexRange, row func(entry *IndexEntry) (*Row, error)) RowSource {
+	columns := index.GetKeyColumns()
+	return func(yield func(*Row, error) bool) {
+		for _, r := range ranges {
+			seek := IndexKey(slices.Clip(r.Equal))
+			if start := r.start(); start != nil {
+				seek = append(seek, start.Value)
+			}
+			entries := index.Scan(ctx)
+			if len(seek) > 0 {
+				entries = index.ScanFrom(ctx, seek)
+			}
+			for entry, err := range entries {
+				if err != nil {
+					yield(nil, fmt.Errorf("index search failed: %w", err))
+					return
+				}
+				if compareIndexKeys(columns, entry.Keys, r.Equal) != 0 {
+					break // past the equality prefix
+				}
+				if len(r.Equal) < len(entry.Keys) {
+					if key := entry.Keys[len(r.Equal)]; !r.Contains(key) {
+						if r.past(key) {
+							break
+						}
+						continue
+					}
+				}
+
+				row, err := row(entry)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if !yield(row, nil) {
+					return
+				}
+			}
+		}
+	}
 }
 
 // // executeFullTableScan executes a query with full table scan (fallback)
@@ -254,116 +766,6 @@ func (qo *QueryOptimizer) executeIndexQueryWithTiming(table Table, plan *QueryPl
 // 	return filteredRows, nil
 // }
 
-// evaluateWhereClause evaluates a WHERE clause condition for a row
-func evaluateWhereClause(expr sqlparser.Expr, row Row, schema []Column) (bool, error) {
-	switch node := expr.(type) {
-	case *sqlparser.ComparisonExpr:
-		return evaluateComparison(node, row, schema)
-	case *sqlparser.AndExpr:
-		left, err := evaluateWhereClause(node.Left, row, schema)
-		if err != nil {
-			return false, err
-		}
-		if !left {
-			return false, nil // Short-circuit AND
-		}
-		return evaluateWhereClause(node.Right, row, schema)
-	case *sqlparser.OrExpr:
-		left, err := evaluateWhereClause(node.Left, row, schema)
-		if err != nil {
-			return false, err
-		}
-		if left {
-			return true, nil // Short-circuit OR
-		}
-		return evaluateWhereClause(node.Right, row, schema)
-	case *sqlparser.ParenExpr:
-		return evaluateWhereClause(node.Expr, row, schema)
-	default:
-		return false, fmt.Errorf("unsupported WHERE expression type: %T", expr)
-	}
-}
-
-// evaluateComparison evaluates a comparison expression
-func evaluateComparison(comp *sqlparser.ComparisonExpr, row Row, schema []Column) (bool, error) {
-	// Get column name and value
-	colName, ok := comp.Left.(*sqlparser.ColName)
-	if !ok {
-		return false, fmt.Errorf("left side of comparison must be a column name")
-	}
-
-	// Find column index
-	columnName := colName.Name.String()
-	columnIndex := -1
-	for _, col := range schema {
-		if col.Name == columnName {
-			columnIndex = col.Index
-			break
-		}
-	}
-	if columnIndex == -1 {
-		return false, fmt.Errorf("column '%s' not found", columnName)
-	}
-
-	// Get row value
-	rowValue, err := row.Get(columnIndex)
-	if err != nil {
-		return false, fmt.Errorf("error getting row value: %v", err)
-	}
-
-	// Get comparison value
-	compValue, err := extractComparisonValue(comp.Right)
-	if err != nil {
-		return false, fmt.Errorf("error extracting comparison value: %v", err)
-	}
-
-	// Perform comparison
-	return compareValues(rowValue, compValue, comp.Operator)
-}
-
-// extractComparisonValue extracts a value from a comparison operand
-func extractComparisonValue(expr sqlparser.Expr) (interface{}, error) {
-	switch v := expr.(type) {
-	case *sqlparser.SQLVal:
-		switch v.Type {
-		case sqlparser.StrVal:
-			return string(v.Val), nil
-		case sqlparser.IntVal:
-			return string(v.Val), nil
-		case sqlparser.FloatVal:
-			return string(v.Val), nil
-		default:
-			return string(v.Val), nil
-		}
-	default:
-		return nil, fmt.Errorf("unsupported comparison value type: %T", expr)
-	}
-}
-
-// compareValues compares two values using the given operator
-func compareValues(left, right interface{}, operator string) (bool, error) {
-	// Convert both values to strings for comparison (simplified)
-	leftStr := fmt.Sprintf("%v", left)
-	rightStr := fmt.Sprintf("%v", right)
-
-	switch operator {
-	case "=":
-		return leftStr == rightStr, nil
-	case "!=", "<>":
-		return leftStr != rightStr, nil
-	case "<":
-		return leftStr < rightStr, nil
-	case ">":
-		return leftStr > rightStr, nil
-	case "<=":
-		return leftStr <= rightStr, nil
-	case ">=":
-		return leftStr >= rightStr, nil
-	default:
-		return false, fmt.Errorf("unsupported comparison operator: %s", operator)
-	}
-}
-
 // parseIntValue parses an integer value from bytes
 // func parseIntValue(val []byte) (int, error) {
 // 	str := string(val)
@@ -372,108 +774,78 @@ func compareValues(left, right interface{}, operator string) (bool, error) {
 // 	return result, err
 // }
 
-// fetchRowsParallel fetches rows by rowid in parallel using goroutines
-func (qo *QueryOptimizer) fetchRowsParallel(ctx context.Context, table Table, indexEntries []IndexEntry) []Row {
-	// 	setupStart := time.Now()
-	// Create a context with 3-second timeout
-	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
-
-	// Use a worker pool pattern with limited concurrency
-	maxWorkers := 10 // Limit concurrent fetches to avoid overwhelming the system
-	if len(indexEntries) < maxWorkers {
-		maxWorkers = len(indexEntries)
-	}
-	// 	setupDuration := time.Since(setupStart)
-	// fmt.Printf("[TIMING] Worker setup took: %v (using %d workers for %d entries)\\n", setupDuration, maxWorkers, len(indexEntries))
-
-	type rowResult struct {
-		row   *Row
-		rowid int64
-		err   error
-		index int
-	}
-
-	// Channel for work items and results
-	workChan := make(chan struct {
-		entry IndexEntry
-		index int
-	}, len(indexEntries))
-	resultChan := make(chan rowResult, len(indexEntries))
-
-	// Start workers
-	var wg sync.WaitGroup
-	for i := 0; i < maxWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			for work := range workChan {
-				// Check for timeout before starting work
-				select {
-				case <-timeoutCtx.Done():
-					return
-				default:
-				}
-
-				row, err := table.GetRowByRowid(timeoutCtx, work.entry.Rowid)
+// QueryPlan represents an execution plan for a query
+type QueryPlan struct {
+	QueryType    string
+	TableName    string
+	UseIndex     bool
+	IndexName    string
+	UseRowid     bool     // scan the table B-tree by rowid instead of an index
+	Covering     bool     // build rows from the index entries without reading the table
+	IndexColumn  string   // the column the index lookup is keyed on
+	IndexColumns []string // the columns the index scan is sorted on
+	IndexDesc    []bool   // whether each of IndexColumns is in descending order
+	IndexValue   interface{}
+	Ranges       []IndexRange // key ranges of the index scan, in index order
+}
 
-				resultChan <- rowResult{
-					row:   row,
-					rowid: work.entry.Rowid,
-					err:   err,
-					index: work.index,
-				}
-			}
-		}(i)
-	}
+// IndexRange is a range of index keys: the keys starting with the Equal values
+// whose next column lies between the bounds. A nil bound leaves that end open.
+type IndexRange struct {
+	Equal []Value
+	Lower *IndexBound
+	Upper *IndexBound
 
-	// Send work to workers
-	// 	workDistributionStart := time.Now()
-	for i, entry := range indexEntries {
-		workChan <- struct {
-			entry IndexEntry
-			index int
-		}{entry: entry, index: i}
-	}
-	close(workChan)
-	// 	workDistributionDuration := time.Since(workDistributionStart)
-	// fmt.Printf("[TIMING] Work distribution took: %v\\n", workDistributionDuration)
+	Desc      bool      // the bounded key column is in descending order
+	Collation Collation // compares the bounded key column; nil is BINARY
+}
 
-	// Wait for all workers to finish
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+// IndexBound is one end of an index range scan
+type IndexBound struct {
+	Value     Value
+	Inclusive bool
+}
 
-	// Collect results maintaining order
-	// 	resultCollectionStart := time.Now()
-	results := make([]*Row, len(indexEntries))
-	validCount := 0
-	for result := range resultChan {
-		if result.err == nil && result.row != nil {
-			results[result.index] = result.row
-			validCount++
+// Contains reports whether a value of the bounded key column lies within the range
+func (r IndexRange) Contains(key Value) bool {
+	if r.Lower != nil {
+		if c := r.compare(key, r.Lower.Value); c < 0 || c == 0 && !r.Lower.Inclusive {
+			return false
 		}
 	}
-	// 	resultCollectionDuration := time.Since(resultCollectionStart)
-	// fmt.Printf("[TIMING] Result collection took: %v (collected %d valid results)\\n", resultCollectionDuration, validCount)
-
-	// Filter out nil entries and preserve order
-	matchingRows := make([]Row, 0, validCount)
-	for _, row := range results {
-		if row != nil {
-			matchingRows = append(matchingRows, *row)
+	if r.Upper != nil {
+		if c := r.compare(key, r.Upper.Value); c > 0 || c == 0 && !r.Upper.Inclusive {
+			return false
 		}
 	}
+	return true
+}
+
+// point returns the only key in the range, if its bounds are one inclusive key
+func (r IndexRange) point() (Value, bool) {
+	if r.Lower == nil || r.Upper == nil || !r.Lower.Inclusive || !r.Upper.Inclusive || r.compare(r.Lower.Value, r.Upper.Value) != 0 {
+		return nil, false
+	}
+	return r.Lower.Value, true
+}
 
-	return matchingRows
+// start returns the bound a scan in index order begins at
+func (r IndexRange) start() *IndexBound {
+	if r.Desc {
+		return r.Upper
+	}
+	return r.Lower
 }
 
-// QueryPlan represents an execution plan for a query
-type QueryPlan struct {
-	QueryType  string
-	TableName  string
-	UseIndex   bool
-	IndexName  string
-	IndexValue interface{}
+// past reports whether a key outside the range comes after it in index order,
+// so that no later key can be in the range
+func (r IndexRange) past(key Value) bool {
+	if r.Desc {
+		return r.Lower != nil && r.compare(key, r.Lower.Value) <= 0
+	}
+	return r.Upper != nil && r.compare(key, r.Upper.Value) >= 0
+}
+
+func (r IndexRange) compare(a, b Value) int {
+	return CompareCollated(a, b, r.Collation)
 }
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// RowSource streams rows through a query pipeline. Consumers that stop early
+// (for example once a LIMIT is satisfied) stop the stages feeding them too.
+type RowSource = iter.Seq2[*Row, error]
+
+// rowsSource streams an already materialized slice of rows
+func rowsSource(rows []Row) RowSource {
+	return func(yield func(*Row, error) bool) {
+		for i := range rows {
+			if !yield(&rows[i], nil) {
+				return
+			}
+		}
+	}
+}
+
+// withRowid appends the row's rowid to its values, where the FROM clause expects it
+func withRowid(row *Row) *Row {
+	row.Values = append(row.Values, NewIntegerValue(row.Rowid))
+	return row
+}
+
+// rowidSource appends the rowid to every row of source
+func rowidSource(source RowSource) RowSource {
+	return func(yield func(*Row, error) bool) {
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(withRowid(row), nil) {
+				return
+			}
+		}
+	}
+}
+
+// filterSource passes through the rows for which predicate is true;
+// clause names the WHERE or HAVING clause in evaluation errors
+func filterSource(source RowSource, predicate Predicate, clause string) RowSource {
+	return func(yield func(*Row, error) bool) {
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			match, err := predicate(row)
+			if err != nil {
+				yield(nil, fmt.Errorf("error evaluating %s condition: %v", clause, err))
+				return
+			}
+			if match && !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// limitSource skips offset rows and then passes through at most limit rows.
+// A negative limit means no limit.
+func limitSource(source RowSource, limit, offset int64) RowSource {
+	return func(yield func(*Row, error) bool) {
+		if limit == 0 {
+			return
+		}
+		var skipped, emitted int64
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if !yield(row, nil) {
+				return
+			}
+			emitted++
+			if limit > 0 && emitted >= limit {
+				return
+			}
+		}
+	}
+}
+
+// sortKey is one compiled ORDER BY term
+type sortKey struct {
+	expr  *CompiledExpr
+	desc  bool
+	nulls NullsOrder
+}
+
+// compare orders two key values, honouring direction, collation and NULLS FIRST/LAST
+func (k sortKey) compare(a, b Value) int {
+	aNull, bNull := StorageClassOf(a) == StorageClassNull, StorageClassOf(b) == StorageClassNull
+	if aNull || bNull {
+		if aNull && bNull {
+			return 0
+		}
+		// NULL is the smallest value unless the term says otherwise
+		nullsFirst := k.nulls == NullsFirst || k.nulls == NullsDefault && !k.desc
+		if aNull == nullsFirst {
+			return -1
+		}
+		return 1
+	}
+
+	c := CompareCollated(a, b, k.expr.Collation)
+	if k.desc {
+		return -c
+	}
+	return c
+}
+
+// sortSource materializes its input and yields it ordered by keys.
+// The sort is stable, so rows with equal keys keep their scan order.
+func sortSource(source RowSource, keys []sortKey) RowSource {
+	type keyedRow struct {
+		row  *Row
+		keys []Value
+	}
+
+	return func(yield func(*Row, error) bool) {
+		var rows []keyedRow
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			values := make([]Value, len(keys))
+			for i, key := range keys {
+				if values[i], err = key.expr.Eval(row); err != nil {
+					yield(nil, fmt.Errorf("error evaluating ORDER BY term %d: %v", i+1, err))
+					return
+				}
+			}
+			rows = append(rows, keyedRow{row: row, keys: values})
+		}
+
+		slices.SortStableFunc(rows, func(a, b keyedRow) int {
+			for i, key := range keys {
+				if c := key.compare(a.keys[i], b.keys[i]); c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+
+		for _, r := range rows {
+			if !yield(r.row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// resultColumn is one column of a SELECT result: its expression and optional alias
+type resultColumn struct {
+	expr  sqlparser.Expr
+	alias string
+}
+
+// compileOrderBy compiles ORDER BY terms the way SQLite resolves them: an integer
+// constant K refers to the K-th result column, a bare identifier matching a result
+// column alias refers to that column, and anything else is an expression over the table.
+func compileOrderBy(orderBy sqlparser.OrderBy, nullsOrder []NullsOrder, compiler *ExprCompiler, columns []resultColumn) ([]sortKey, error) {
+	keys := make([]sortKey, len(orderBy))
+	for i, order := range orderBy {
+		expr, err := resolveResultColumnRef("ORDER BY", i, order.Expr, columns)
+		if err != nil {
+			return nil, err
+		}
+		if keys[i].expr, err = compiler.Compile(expr); err != nil {
+			return nil, err
+		}
+		keys[i].desc = order.Direction == sqlparser.DescScr
+		if i < len(nullsOrder) {
+			keys[i].nulls = nullsOrder[i]
+		}
+	}
+	return keys, nil
+}
+
+// resolveResultColumnRef replaces ordinal and alias references in an ORDER BY or
+// GROUP BY term with the result column expression
+func resolveResultColumnRef(clause string, term int, expr sqlparser.Expr, columns []resultColumn) (sqlparser.Expr, error) {
+	inner := expr
+	var collate *sqlparser.CollateExpr
+	if c, ok := expr.(*sqlparser.CollateExpr); ok {
+		collate, inner = c, c.Expr
+	}
+
+	var resolved sqlparser.Expr
+	switch e := inner.(type) {
+	case *sqlparser.SQLVal:
+		if e.Type != sqlparser.IntVal {
+			return expr, nil
+		}
+		var ordinal int
+		if _, err := fmt.Sscan(string(e.Val), &ordinal); err != nil || ordinal < 1 || ordinal > len(columns) {
+			return nil, fmt.Errorf("%s %s term out of range - should be between 1 and %d", ordinalName(term+1), clause, len(columns))
+		}
+		resolved = columns[ordinal-1].expr
+	case *sqlparser.ColName:
+		if !e.Qualifier.IsEmpty() {
+			return expr, nil
+		}
+		for _, column := range columns {
+			if column.alias != "" && strings.EqualFold(column.alias, e.Name.String()) {
+				resolved = column.expr
+				break
+			}
+		}
+	}
+
+	if resolved == nil {
+		return expr, nil
+	}
+	if collate != nil {
+		return &sqlparser.CollateExpr{Expr: resolved, Charset: collate.Charset}, nil
+	}
+	return resolved, nil
+}
+
+// groupByTerms resolves the GROUP BY terms of a query and compiles them over the input rows
+func groupByTerms(groupBy sqlparser.GroupBy, schema []Column, columns []resultColumn) ([]sqlparser.Expr, []*CompiledExpr, error) {
+	exprs := make([]sqlparser.Expr, len(groupBy))
+	keys := make([]*CompiledExpr, len(groupBy))
+	compiler := NewExprCompiler(schema)
+	for i, term := range groupBy {
+		var err error
+		if exprs[i], err = resolveResultColumnRef("GROUP BY", i, term, columns); err != nil {
+			return nil, nil, err
+		}
+		if keys[i], err = compiler.Compile(exprs[i]); err != nil {
+			if strings.HasPrefix(err.Error(), "misuse of aggregate") {
+				return nil, nil, fmt.Errorf("aggregate functions are not allowed in the GROUP BY clause")
+			}
+			return nil, nil, err
+		}
+	}
+	return exprs, keys, nil
+}
+
+// sortedColumn is a schema column a source delivers its rows ordered on
+type sortedColumn struct {
+	index int  // schema position of the column
+	desc  bool // the rows come in descending order of the column
+}
+
+// inGroupOrder reports whether a source sorted on the sortedBy columns delivers the
+// groups of the given GROUP BY terms contiguously. That holds when the terms are
+// plain columns, compared with their declared collation, forming a prefix of sortedBy.
+func inGroupOrder(terms []sqlparser.Expr, schema []Column, sortedBy []sortedColumn) bool {
+	if len(terms) > len(sortedBy) {
+		return false
+	}
+	prefix := make(map[int]bool, len(terms))
+	for _, sorted := range sortedBy[:len(terms)] {
+		prefix[sorted.index] = true
+	}
+	for _, term := range terms {
+		index := columnIndexOf(term, schema)
+		if index < 0 || !prefix[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// inSortOrder reports whether a source sorted on the sortedBy columns already
+// delivers its rows in the order of the ORDER BY terms, so that no sort is needed.
+// That holds when the terms are plain columns, compared with their declared
+// collation, forming a prefix of sortedBy in the same direction, and any NULLS
+// FIRST/LAST agrees with where the scan puts NULLs.
+func inSortOrder(orderBy sqlparser.OrderBy, nullsOrder []NullsOrder, columns []resultColumn, schema []Column, sortedBy []sortedColumn) bool {
+	if len(orderBy) > len(sortedBy) {
+		return false
+	}
+	for i, order := range orderBy {
+		expr, err := resolveResultColumnRef("ORDER BY", i, order.Expr, columns)
+		if err != nil {
+			return false
+		}
+		index := columnIndexOf(expr, schema)
+		desc := order.Direction == sqlparser.DescScr
+		if index < 0 || !sameValues(schema, index, sortedBy[i].index) || sortedBy[i].desc != desc {
+			return false
+		}
+		// NULL is the smallest value, so it leads an ascending scan and ends a descending one
+		if i < len(nullsOrder) && nullsOrder[i] != NullsDefault && (nullsOrder[i] == NullsFirst) == desc {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValues reports whether two schema columns hold the same values: the same
+// column, or the rowid and a column aliasing it
+func sameValues(schema []Column, a, b int) bool {
+	rowid := func(index int) bool {
+		for _, col := range schema {
+			if col.Index == index {
+				return col.IsRowid || col.RowidAlias
+			}
+		}
+		return false
+	}
+	return a == b || rowid(a) && rowid(b)
+}
+
+// columnIndexOf returns the schema position of a plain column reference, or -1
+func columnIndexOf(expr sqlparser.Expr, schema []Column) int {
+	colName, ok := expr.(*sqlparser.ColName)
+	if !ok {
+		return -1
+	}
+	for _, col := range schema {
+		if strings.EqualFold(col.Name, colName.Name.String()) {
+			return col.Index
+		}
+	}
+	return -1
+}
+
+// ordinalName formats n as "1st", "2nd", "3rd", ... for error messages
+func ordinalName(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// evaluateLimit evaluates the LIMIT and OFFSET expressions of a query.
+// A missing or negative LIMIT means no limit; a negative OFFSET counts as zero.
+func evaluateLimit(limit *sqlparser.Limit) (int64, int64, error) {
+	if limit == nil {
+		return -1, 0, nil
+	}
+
+	count, err := evaluateLimitTerm(limit.Rowcount, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset, err := evaluateLimitTerm(limit.Offset, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if count < 0 {
+		count = -1
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return count, offset, nil
+}
+
+// evaluateLimitTerm evaluates a constant LIMIT or OFFSET expression, which must be an integer
+func evaluateLimitTerm(expr sqlparser.Expr, missing int64) (int64, error) {
+	if expr == nil {
+		return missing, nil
+	}
+
+	compiled, err := NewExprCompiler(nil).Compile(expr)
+	if err != nil {
+		return 0, err
+	}
+	value, err := compiled.Eval(&Row{})
+	if err != nil {
+		return 0, err
+	}
+	value = ApplyAffinity(value, AffinityNumeric)
+	if StorageClassOf(value) != StorageClassInteger {
+		return 0, fmt.Errorf("datatype mismatch")
+	}
+	return value.Int64()
+}
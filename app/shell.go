@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// maxHistory is the number of lines kept in the history file
+const maxHistory = 1000
+
+// Shell is the interactive mode: it reads SQL statements, which may span lines
+// and end with a semicolon, and dot-commands, and runs them with the engine.
+// On a terminal it edits lines with a LineEditor and keeps their history in a
+// file; otherwise it reads its input silently, as a script.
+type Shell struct {
+	engine      *SqliteEngine
+	in          io.Reader
+	out         io.Writer
+	errOut      io.Writer
+	historyPath string // file the history is kept in; empty for none
+}
+
+// NewShell creates a shell running commands read from in on engine
+func NewShell(engine *SqliteEngine, in io.Reader, out, errOut io.Writer) *Shell {
+	return &Shell{engine: engine, in: in, out: out, errOut: errOut, historyPath: historyPath()}
+}
+
+// historyPath returns the history file: $SQLITE_HISTORY, as in the sqlite3 shell,
+// or .sqlite_go_history in the home directory
+func historyPath() string {
+	if path := os.Getenv("SQLITE_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sqlite_go_history")
+}
+
+// Run reads and executes commands until the input ends or .quit
+func (s *Shell) Run() error {
+	file, ok := s.in.(*os.File)
+	if !ok || !isTerminal(int(file.Fd())) {
+		scanner := bufio.NewScanner(s.in)
+		scanner.Buffer(nil, 1<<20)
+		return s.loop(func(string) (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}, func(string) {})
+	}
+
+	fmt.Fprintln(s.out, `Enter ".help" for usage hints.`)
+	editor := NewLineEditor(s.in, s.out)
+	editor.History = s.loadHistory()
+	defer s.saveHistory(editor)
+	return s.loop(func(prompt string) (string, error) {
+		restore, err := makeRaw(int(file.Fd()))
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+		return editor.ReadLine(prompt)
+	}, func(entry string) {
+		if n := len(editor.History); n == 0 || editor.History[n-1] != entry {
+			editor.History = append(editor.History, entry)
+		}
+	})
+}
+
+// loop runs the commands of the lines returned by readLine, which is given the
+// prompt to show, and records each complete command with remember
+func (s *Shell) loop(readLine func(prompt string) (string, error), remember func(entry string)) error {
+	var pending []string // lines of an unfinished statement
+	for {
+		prompt := "sqlite> "
+		if len(pending) > 0 {
+			prompt = "   ...> "
+		}
+		line, err := readLine(prompt)
+		if errors.Is(err, errInterrupted) {
+			pending = nil
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if len(pending) > 0 {
+				// like sqlite3, run a final statement that lacks its semicolon
+				s.execute("sql", strings.Join(pending, "\n"))
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			remember(strings.TrimSpace(line))
+			command, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch command {
+			case ".quit", ".exit":
+				return nil
+			case ".help":
+				s.printHelp()
+			default:
+				s.execute(command, strings.TrimSpace(args))
+			}
+			continue
+		}
+
+		if len(pending) == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		pending = append(pending, line)
+		statements, rest := splitStatements(strings.Join(pending, "\n"))
+		if len(statements) == 0 {
+			continue
+		}
+		remember(strings.Join(strings.Fields(strings.Join(pending, " ")), " "))
+		for _, statement := range statements {
+			s.execute("sql", statement)
+		}
+		pending = nil
+		if strings.TrimSpace(rest) != "" {
+			pending = []string{rest}
+		}
+	}
+}
+
+// execute runs one command, which Ctrl-C interrupts by cancelling its context
+func (s *Shell) execute(command, args string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := s.engine.ExecuteCommandContext(ctx, command, args)
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.Canceled):
+		fmt.Fprintln(s.errOut, "Error: interrupted")
+	default:
+		fmt.Fprintf(s.errOut, "Error: %v\n", err)
+	}
+}
+
+// printHelp lists the dot-commands
+func (s *Shell) printHelp() {
+	fmt.Fprint(s.out, `.dbinfo                  Show status information about the database
+.eqp on|off              Show the query plan of each statement before running it
+.exit                    Exit this program
+.headers on|off          Turn display of column names on or off
+.help                    Show this message
+.indexes                 Show names of indexes
+.mode MODE ?TABLE?       Set the output mode: box, column, csv, html, insert,
+                         json, jsonl, line, list, markdown, quote, table, tabs
+.nullvalue STRING        Show STRING in place of NULL values
+.quit                    Exit this program
+.schema                  Show the CREATE statements
+.separator COL ?ROW?     Set the column and row separators
+.snapshot list           List the commits in the write-ahead log
+.snapshot use FRAME      Read the database as of a commit frame, or "latest"
+.stats                   Show page cache statistics
+.tables                  List names of tables
+`)
+}
+
+// loadHistory reads the history file, if there is one
+func (s *Shell) loadHistory() []string {
+	if s.historyPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.historyPath)
+	if err != nil {
+		return nil
+	}
+	return strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' })
+}
+
+// saveHistory writes the most recent history entries to the history file
+func (s *Shell) saveHistory(editor *LineEditor) {
+	if s.historyPath == "" {
+		return
+	}
+	history := editor.History[max(len(editor.History)-maxHistory, 0):]
+	if err := os.WriteFile(s.historyPath, []byte(strings.Join(history, "\n")+"\n"), 0o600); err != nil {
+		fmt.Fprintf(s.errOut, "Error: cannot save history: %v\n", err)
+	}
+}
+
+// splitStatements splits SQL text into the statements completed by a semicolon,
+// without it, and the unfinished text after them. Semicolons inside quotes and
+// comments do not end a statement.
+func splitStatements(text string) (statements []string, rest string) {
+	start := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '-' && strings.HasPrefix(text[i:], "--"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				return statements, text[start:]
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return statements, text[start:]
+			}
+			i += end + 3
+		case c == ';':
+			if statement := strings.TrimSpace(text[start:i]); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+	}
+	return statements, text[start:]
+}
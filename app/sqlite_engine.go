@@ -1,33 +1,42 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/xwb1989/sqlparser"
 )
 
 // SqliteEngine represents the main SQLite query engine
 type SqliteEngine struct {
-	db        Database
-	formatter OutputFormatter
+	db     Database
+	out    io.Writer
+	output OutputSettings
+	eqp    bool // print the query plan of each SELECT before its results
 }
 
 // NewSqliteEngine creates a new SQLite engine instance
 func NewSqliteEngine(dbPath string) (*SqliteEngine, error) {
-	db, err := NewDatabase(dbPath)
+	db, err := NewDatabase(dbPath, WithLogger(func(err error) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	formatter := NewConsoleFormatter(os.Stdout)
-
 	return &SqliteEngine{
-		db:        db,
-		formatter: formatter,
+		db:     db,
+		out:    os.Stdout,
+		output: DefaultOutputSettings(),
 	}, nil
 }
 
@@ -38,60 +47,136 @@ func (engine *SqliteEngine) Close() error {
 
 // ExecuteCommand executes a command
 func (engine *SqliteEngine) ExecuteCommand(command, args string) error {
+	return engine.ExecuteCommandContext(context.Background(), command, args)
+}
+
+// ExecuteCommandContext executes a command, stopping early with ctx's error when
+// ctx is cancelled
+func (engine *SqliteEngine) ExecuteCommandContext(ctx context.Context, command, args string) error {
 	switch command {
 	case ".dbinfo":
-		return engine.handleDBInfo()
+		return engine.handleDBInfo(ctx)
 	case ".tables":
-		return engine.handleTables()
+		return engine.handleTables(ctx)
 	case ".indexes":
-		return engine.handleIndexes()
+		return engine.handleIndexes(ctx)
 	case ".schema":
-		return engine.handleSchema()
+		return engine.handleSchema(ctx)
+	case ".stats":
+		return engine.handleStats()
+	case ".eqp":
+		return engine.handleEQP(args)
+	case ".mode":
+		return engine.handleMode(args)
+	case ".headers":
+		return engine.handleHeaders(args)
+	case ".separator":
+		return engine.handleSeparator(args)
+	case ".nullvalue":
+		return engine.handleNullValue(args)
+	case ".snapshot":
+		return engine.handleSnapshot(args)
 	case "sql":
-		return engine.handleSQL(args)
+		return engine.handleSQL(ctx, args)
 	default:
 		return fmt.Errorf("unknown command: %s", command)
 	}
 }
 
-// handleDBInfo handles the .dbinfo command
-func (engine *SqliteEngine) handleDBInfo() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// handleDBInfo handles the .dbinfo command, which reports the database header
+// and the number of each kind of schema object the way the sqlite3 shell does
+func (engine *SqliteEngine) handleDBInfo(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	// Get the actual page size from the database header
-	pageSize := engine.db.GetPageSize()
-	fmt.Printf("database page size: %v\n", pageSize)
-
-	tables, err := engine.db.GetTables(ctx)
+	header := engine.db.GetHeader()
+	schemas, err := engine.db.LoadSchema(ctx)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("number of tables: %v\n", len(tables)-1) // sqlite_schema table should not be counted
+	fileSize, err := engine.db.FileSize()
+	if err != nil {
+		return err
+	}
+
+	// The header page count goes stale when an old version of SQLite writes the
+	// file, so point out when the file holds a different number of pages
+	pageCount := fmt.Sprint(header.DatabaseSize)
+	if filePages := fileSize / int64(header.GetActualPageSize()); filePages != int64(header.DatabaseSize) {
+		pageCount += fmt.Sprintf(" (file holds %d pages)", filePages)
+	}
+	encoding := fmt.Sprint(header.TextEncoding)
+	switch header.TextEncoding {
+	case 1:
+		encoding += " (utf8)"
+	case 2:
+		encoding += " (utf16le)"
+	case 3:
+		encoding += " (utf16be)"
+	}
+	objects := make(map[string]int)
+	schemaSize := 0
+	for _, schema := range schemas {
+		objects[schema.Type]++
+		schemaSize += utf8.RuneCountInString(schema.SQL)
+	}
+
+	fields := []struct {
+		name  string
+		value any
+	}{
+		{"database page size:", header.GetActualPageSize()},
+		{"write format:", header.FileFormatWrite},
+		{"read format:", header.FileFormatRead},
+		{"reserved bytes:", header.ReservedBytes},
+		{"file change counter:", header.FileChangeCount},
+		{"database page count:", pageCount},
+		{"freelist trunk page:", header.FirstFreePage},
+		{"freelist page count:", header.FreePageCount},
+		{"schema cookie:", header.SchemaCookie},
+		{"schema format:", header.SchemaFormat},
+		{"default cache size:", header.DefaultCache},
+		{"autovacuum top root:", header.LargestBTree},
+		{"incremental vacuum:", header.IncrVacuum},
+		{"text encoding:", encoding},
+		{"user version:", header.UserVersion},
+		{"application id:", header.AppID},
+		{"software version:", header.SQLiteVersion},
+		{"number of tables:", objects["table"]},
+		{"number of indexes:", objects["index"]},
+		{"number of triggers:", objects["trigger"]},
+		{"number of views:", objects["view"]},
+		{"schema size:", schemaSize},
+	}
+	for _, field := range fields {
+		fmt.Fprintf(engine.out, "%-20s %v\n", field.name, field.value)
+	}
 	return nil
 }
 
-// handleTables handles the .tables command
-func (engine *SqliteEngine) handleTables() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// handleTables handles the .tables command. Like the sqlite3 shell, it lists the
+// tables in name order and leaves out SQLite's internal sqlite_ tables.
+func (engine *SqliteEngine) handleTables(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	tableNames, err := engine.db.GetTables(ctx)
 	if err != nil {
 		return err
 	}
+	slices.Sort(tableNames)
 	for _, tableName := range tableNames {
-		if tableName != "sqlite_master" {
-			fmt.Printf("%s ", tableName)
+		if !strings.HasPrefix(strings.ToLower(tableName), "sqlite_") {
+			fmt.Fprintf(engine.out, "%s ", tableName)
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(engine.out)
 	return nil
 }
 
 // handleIndexes handles the .indexes command
-func (engine *SqliteEngine) handleIndexes() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (engine *SqliteEngine) handleIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	indexNames, err := engine.db.GetIndices(ctx)
@@ -100,573 +185,510 @@ func (engine *SqliteEngine) handleIndexes() error {
 	}
 
 	for _, indexName := range indexNames {
-		fmt.Printf("%s ", indexName)
+		fmt.Fprintf(engine.out, "%s ", indexName)
 	}
-	fmt.Println()
+	fmt.Fprintln(engine.out)
 	return nil
 }
 
-// handleSchema handles the .schema command - shows table-index relationships
-func (engine *SqliteEngine) handleSchema() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// handleSchema handles the .schema command - prints the CREATE statements stored
+// in the schema table, in the order they appear there, as sqlite3 does. Indexes
+// SQLite builds for PRIMARY KEY and UNIQUE constraints have none.
+func (engine *SqliteEngine) handleSchema(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	// Get all tables
-	tableNames, err := engine.db.GetTables(ctx)
+	schemas, err := engine.db.LoadSchema(ctx)
 	if err != nil {
-		return fmt.Errorf("get tables: %w", err)
+		return fmt.Errorf("load schema: %w", err)
 	}
-
-	fmt.Println("Tables and their indexes:")
-	for _, tableName := range tableNames {
-		if tableName == "sqlite_master" || tableName == "sqlite_sequence" {
-			continue // Skip system tables
-		}
-
-		fmt.Printf("\nTable: %s\n", tableName)
-
-		// Get indexes for this table
-		indexes, err := engine.db.GetTableIndexes(ctx, tableName)
-		if err != nil {
-			fmt.Printf("  Error getting indexes: %v\n", err)
-			continue
-		}
-
-		if len(indexes) == 0 {
-			fmt.Println("  No indexes")
-		} else {
-			fmt.Println("  Indexes:")
-			for _, index := range indexes {
-				fmt.Printf("    - %s\n", index.GetName())
-			}
+	for _, schema := range schemas {
+		if schema.SQL != "" {
+			fmt.Fprintf(engine.out, "%s;\n", schema.SQL)
 		}
 	}
-
 	return nil
 }
 
-// handleSQL handles SQL commands
-func (engine *SqliteEngine) handleSQL(sqlArgs string) error {
-	// 	sqlStart := time.Now()
-	// fmt.Printf("[TIMING] Starting SQL execution\n")
-
-	// 	parseStart := time.Now()
-	stmt, err := sqlparser.Parse(sqlArgs)
-	// 	parseDuration := time.Since(parseStart)
-	// fmt.Printf("[TIMING] SQL parsing took: %v\n", parseDuration)
-
-	if err != nil {
-		return fmt.Errorf("failed to parse SQL: %v", err)
-	}
+// handleStats handles the .stats command - reports page cache activity
+func (engine *SqliteEngine) handleStats() error {
+	stats := engine.db.PageCacheStats()
+	fmt.Fprintf(engine.out, "Page cache hits:                     %d\n", stats.Hits)
+	fmt.Fprintf(engine.out, "Page cache misses:                   %d\n", stats.Misses)
+	fmt.Fprintf(engine.out, "Page cache evictions:                %d\n", stats.Evictions)
+	fmt.Fprintf(engine.out, "Page cache pages:                    %d of %d\n", stats.Pages, stats.Capacity)
+	return nil
+}
 
-	switch parsedStmt := stmt.(type) {
-	case *sqlparser.Select:
-		err := engine.handleSelect(parsedStmt)
-		// 		sqlDuration := time.Since(sqlStart)
-		// fmt.Printf("[TIMING] Total SQL execution took: %v\n", sqlDuration)
-		return err
-	case *sqlparser.Insert:
-		return fmt.Errorf("INSERT statements not supported yet")
-	case *sqlparser.Update:
-		return fmt.Errorf("UPDATE statements not supported yet")
-	case *sqlparser.Delete:
-		return fmt.Errorf("DELETE statements not supported yet")
+// handleEQP handles the .eqp command, which turns printing query plans on or off
+func (engine *SqliteEngine) handleEQP(args string) error {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		engine.eqp = true
+	case "off":
+		engine.eqp = false
 	default:
-		return fmt.Errorf("unsupported SQL statement type: %T", parsedStmt)
+		return fmt.Errorf("usage: .eqp on|off")
 	}
+	return nil
 }
 
-// handleSelect handles SELECT statements
-func (engine *SqliteEngine) handleSelect(stmt *sqlparser.Select) error {
-	// 	selectStart := time.Now()
-	// fmt.Printf("[TIMING] Starting SELECT statement processing\n")
-
-	tableName := engine.extractTableName(stmt)
-	if tableName == "" {
-		return fmt.Errorf("could not extract table name from SELECT statement")
-	}
-
-	var columnNames []string
-	var hasStarExpr bool
-	var hasCountFunc bool
-
-	// First pass: collect all column names and check for special cases
-	for _, expr := range stmt.SelectExprs {
-		switch selectExpr := expr.(type) {
-		case *sqlparser.StarExpr:
-			hasStarExpr = true
-		case *sqlparser.AliasedExpr:
-			switch innerExpr := selectExpr.Expr.(type) {
-			case *sqlparser.FuncExpr:
-				funcName := strings.ToLower(innerExpr.Name.String())
-				if funcName == "count" {
-					hasCountFunc = true
-				} else {
-					return fmt.Errorf("unsupported function: %s", funcName)
-				}
-			case *sqlparser.ColName:
-				columnName := innerExpr.Name.String()
-				columnNames = append(columnNames, columnName)
-			default:
-				return fmt.Errorf("unsupported expression type: %T", innerExpr)
-			}
-		default:
-			return fmt.Errorf("unsupported SELECT expression type: %T", selectExpr)
-		}
+// handleMode handles the .mode command, which selects the output mode and, for
+// insert mode, the table to insert into
+func (engine *SqliteEngine) handleMode(args string) error {
+	fields := commandArgs(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("usage: .mode MODE ?TABLE?")
 	}
-
-	// Try to optimize the query using indexes
-	// 	optimizeStart := time.Now()
-	optimizer := NewQueryOptimizer(engine.db)
-	plan, err := optimizer.OptimizeSelect(stmt)
-	// 	optimizeDuration := time.Since(optimizeStart)
-	// fmt.Printf("[TIMING] Query optimization took: %v\n", optimizeDuration)
-
-	if err == nil && plan.UseIndex {
-		// Use optimized query execution
-		// 		selectDuration := time.Since(selectStart)
-		// fmt.Printf("[TIMING] Total SELECT preparation took: %v\n", selectDuration)
-		return engine.handleOptimizedSelect(stmt, plan, hasStarExpr, hasCountFunc, columnNames)
+	table := ""
+	if len(fields) == 2 {
+		table = fields[1]
 	}
+	return engine.output.SetMode(fields[0], table)
+}
 
-	// Fall back to original query execution
-	if hasStarExpr {
-		return engine.handleSelectAll(tableName, stmt.Where)
-	} else if hasCountFunc {
-		return engine.handleCount(tableName, stmt.Where)
-	} else if len(columnNames) > 0 {
-		// fmt.Println("++", strings.Join(columnNames, "|"))
-		return engine.handleSelectColumns(tableName, columnNames, stmt.Where)
+// handleHeaders handles the .headers command, which turns column names on or off
+func (engine *SqliteEngine) handleHeaders(args string) error {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		engine.output.Headers = true
+	case "off":
+		engine.output.Headers = false
+	default:
+		return fmt.Errorf("usage: .headers on|off")
 	}
-
-	return fmt.Errorf("no valid columns found in SELECT statement")
+	engine.output.HeadersSet = true
+	return nil
 }
 
-// filterRows filters rows based on WHERE clause conditions
-func (engine *SqliteEngine) filterRows(rows []*Row, schema []*Column, whereClause *sqlparser.Where) ([]*Row, error) {
-	if whereClause == nil {
-		return rows, nil
+// handleSeparator handles the .separator command, which sets the column and,
+// optionally, the row separator
+func (engine *SqliteEngine) handleSeparator(args string) error {
+	fields := commandArgs(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("usage: .separator COL ?ROW?")
+	}
+	engine.output.ColSep = fields[0]
+	if len(fields) == 2 {
+		engine.output.RowSep = fields[1]
 	}
+	return nil
+}
 
-	var filteredRows []*Row
-	for _, row := range rows {
-		match, err := engine.evaluateWhereCondition(row, schema, whereClause.Expr)
-		if err != nil {
-			return nil, fmt.Errorf("error evaluating WHERE condition: %v", err)
-		}
-		if match {
-			filteredRows = append(filteredRows, row)
-		}
+// handleNullValue handles the .nullvalue command, which sets the text shown for NULL
+func (engine *SqliteEngine) handleNullValue(args string) error {
+	fields := commandArgs(args)
+	if len(fields) != 1 {
+		return fmt.Errorf("usage: .nullvalue STRING")
 	}
-	return filteredRows, nil
+	engine.output.NullValue = fields[0]
+	return nil
 }
 
-// evaluateWhereCondition evaluates a WHERE condition for a single row
-func (engine *SqliteEngine) evaluateWhereCondition(row *Row, schema []*Column, expr sqlparser.Expr) (bool, error) {
-	switch e := expr.(type) {
-	case *sqlparser.ComparisonExpr:
-		return engine.evaluateComparison(row, schema, e)
-	case *sqlparser.AndExpr:
-		left, err := engine.evaluateWhereCondition(row, schema, e.Left)
-		if err != nil {
-			return false, err
+// handleSnapshot handles the .snapshot command: "list" shows the commits in the
+// write-ahead log, and "use N" reads the database as of commit frame N, or as of
+// the last commit for "use latest"
+func (engine *SqliteEngine) handleSnapshot(args string) error {
+	fields := commandArgs(args)
+	switch {
+	case len(fields) == 1 && fields[0] == "list":
+		snapshots := engine.db.Snapshots()
+		if len(snapshots) == 0 {
+			return fmt.Errorf("no committed transactions in a write-ahead log")
 		}
-		if !left {
-			return false, nil // Short-circuit AND
-		}
-		return engine.evaluateWhereCondition(row, schema, e.Right)
-	case *sqlparser.OrExpr:
-		left, err := engine.evaluateWhereCondition(row, schema, e.Left)
-		if err != nil {
-			return false, err
+		fmt.Fprintln(engine.out, "commit frame  database pages  pages written")
+		for _, snapshot := range snapshots {
+			note := ""
+			switch {
+			case snapshot.Current:
+				note = "  (current)"
+			case !snapshot.Available:
+				note = "  (checkpointed)"
+			}
+			fmt.Fprintf(engine.out, "%12d  %14d  %13d%s\n", snapshot.Frame, snapshot.DatabaseSize, snapshot.Pages, note)
 		}
-		if left {
-			return true, nil // Short-circuit OR
+		return nil
+	case len(fields) == 2 && fields[0] == "use":
+		frame := 0
+		if fields[1] != "latest" {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid commit frame: %s", fields[1])
+			}
+			frame = n
 		}
-		return engine.evaluateWhereCondition(row, schema, e.Right)
-	case *sqlparser.ParenExpr:
-		return engine.evaluateWhereCondition(row, schema, e.Expr)
+		return engine.db.UseSnapshot(frame)
 	default:
-		return false, fmt.Errorf("unsupported WHERE expression type: %T", expr)
+		return fmt.Errorf("usage: .snapshot list|use FRAME|use latest")
 	}
 }
 
-// evaluateComparison evaluates a comparison expression (=, !=, <, >, <=, >=)
-func (engine *SqliteEngine) evaluateComparison(row *Row, schema []*Column, comp *sqlparser.ComparisonExpr) (bool, error) {
-	// Get column name and value
-	colName, ok := comp.Left.(*sqlparser.ColName)
-	if !ok {
-		return false, fmt.Errorf("left side of comparison must be a column name")
+// commandArgs splits the arguments of a dot-command at spaces. As in the sqlite3
+// shell, an argument in single quotes is taken literally and one in double quotes
+// or without quotes has its backslash escapes (\t, \n, \\ ...) resolved.
+func commandArgs(args string) []string {
+	var fields []string
+	for args = strings.TrimSpace(args); args != ""; args = strings.TrimSpace(args) {
+		var field string
+		switch quote := args[0]; quote {
+		case '\'', '"':
+			end := 1
+			for end < len(args) && args[end] != quote {
+				if quote == '"' && args[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			field, args = args[1:min(end, len(args))], args[min(end+1, len(args)):]
+			if quote == '"' {
+				field = resolveEscapes(field)
+			}
+		default:
+			end := strings.IndexFunc(args, unicode.IsSpace)
+			if end < 0 {
+				end = len(args)
+			}
+			field, args = resolveEscapes(args[:end]), args[end:]
+		}
+		fields = append(fields, field)
 	}
+	return fields
+}
 
-	// Find column index
-	columnName := colName.Name.String()
-	columnIndex := -1
-	for _, col := range schema {
-		if strings.EqualFold(col.Name, columnName) {
-			columnIndex = col.Index
-			break
+// resolveEscapes replaces the backslash escapes of a dot-command argument
+func resolveEscapes(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		default:
+			b.WriteByte(s[i])
 		}
 	}
-	if columnIndex == -1 {
-		return false, fmt.Errorf("column '%s' not found", columnName)
-	}
+	return b.String()
+}
 
-	// Get row value
-	rowValue, err := row.Get(columnIndex)
+// handleSQL handles SQL commands
+func (engine *SqliteEngine) handleSQL(ctx context.Context, sqlArgs string) error {
+	sqlArgs, explain := cutExplainQueryPlan(sqlArgs)
+	sql, err := rewriteSQLiteQuery(sqlArgs)
 	if err != nil {
-		return false, fmt.Errorf("error getting row value: %v", err)
+		return fmt.Errorf("failed to parse SQL: %v", err)
 	}
-
-	// Get comparison value
-	compValue, err := engine.extractComparisonValue(comp.Right)
+	sql, nullsOrder := stripNullsOrdering(sql)
+	stmt, err := sqlparser.Parse(sql)
 	if err != nil {
-		return false, fmt.Errorf("error extracting comparison value: %v", err)
+		return fmt.Errorf("failed to parse SQL: %v", err)
 	}
 
-	// Perform comparison
-	return engine.compareValues(rowValue, compValue, comp.Operator)
-}
-
-// extractComparisonValue extracts the value from the right side of a comparison
-func (engine *SqliteEngine) extractComparisonValue(expr sqlparser.Expr) (interface{}, error) {
-	switch e := expr.(type) {
-	case *sqlparser.SQLVal:
-		switch e.Type {
-		case sqlparser.StrVal:
-			return string(e.Val), nil
-		case sqlparser.IntVal:
-			return string(e.Val), nil
-		case sqlparser.FloatVal:
-			return string(e.Val), nil
-		default:
-			return string(e.Val), nil
-		}
-	case *sqlparser.ColName:
-		return e.Name.String(), nil
+	switch parsedStmt := stmt.(type) {
+	case *sqlparser.Select:
+		return engine.handleSelect(ctx, parsedStmt, nullsOrder, explain)
+	case *sqlparser.Insert:
+		return fmt.Errorf("INSERT statements not supported yet")
+	case *sqlparser.Update:
+		return fmt.Errorf("UPDATE statements not supported yet")
+	case *sqlparser.Delete:
+		return fmt.Errorf("DELETE statements not supported yet")
 	default:
-		return nil, fmt.Errorf("unsupported comparison value type: %T", expr)
+		return fmt.Errorf("unsupported SQL statement type: %T", parsedStmt)
 	}
 }
 
-// compareValues compares two values based on the operator
-func (engine *SqliteEngine) compareValues(rowValue Value, compValue interface{}, operator string) (bool, error) {
-	// Convert both values to strings for comparison
-	rowStr := engine.formatter.FormatValue(rowValue)
-	compStr := fmt.Sprintf("%v", compValue)
-
-	switch operator {
-	case "=":
-		return rowStr == compStr, nil
-	case "!=", "<>":
-		return rowStr != compStr, nil
-	case "<":
-		return rowStr < compStr, nil
-	case "<=":
-		return rowStr <= compStr, nil
-	case ">":
-		return rowStr > compStr, nil
-	case ">=":
-		return rowStr >= compStr, nil
-	default:
-		return false, fmt.Errorf("unsupported operator: %s", operator)
+// cutExplainQueryPlan strips a leading EXPLAIN QUERY PLAN from sql, reporting whether it was there
+func cutExplainQueryPlan(sql string) (string, bool) {
+	rest := strings.TrimSpace(sql)
+	for _, keyword := range []string{"EXPLAIN", "QUERY", "PLAN"} {
+		if len(rest) <= len(keyword) || !strings.EqualFold(rest[:len(keyword)], keyword) || !unicode.IsSpace(rune(rest[len(keyword)])) {
+			return sql, false
+		}
+		rest = strings.TrimSpace(rest[len(keyword):])
 	}
+	return rest, true
 }
 
-// handleSelectAll handles SELECT * statements
-func (engine *SqliteEngine) handleSelectAll(tableName string, whereClause *sqlparser.Where) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	table, err := engine.db.GetTable(ctx, tableName)
-	if err != nil {
-		return err
-	}
-
-	schema, err := table.GetSchema(ctx)
-	if err != nil {
-		return err
-	}
+// selectPlan is a planned SELECT statement: the steps SQLite's EXPLAIN QUERY PLAN
+// would list for it, and run, which executes exactly those steps and prints the rows
+type selectPlan struct {
+	steps []*PlanNode
+	run   func() error
+}
 
-	rows, err := table.GetRows(ctx)
+// handleSelect handles SELECT statements, printing the query plan instead of the
+// rows for EXPLAIN QUERY PLAN and before them when .eqp is on. The query streams
+// its rows for as long as it takes; only cancelling ctx stops it early.
+func (engine *SqliteEngine) handleSelect(ctx context.Context, stmt *sqlparser.Select, nullsOrder []NullsOrder, explain bool) error {
+	plan, err := engine.planSelect(ctx, stmt, nullsOrder)
 	if err != nil {
 		return err
 	}
-
-	// Convert []Column to []*Column for compatibility
-	schemaPointers := make([]*Column, len(schema))
-	for i := range schema {
-		schemaPointers[i] = &schema[i]
+	if explain || engine.eqp {
+		fmt.Fprint(engine.out, FormatQueryPlan(plan.steps))
 	}
-
-	// Convert []Row to []*Row for compatibility
-	rowPointers := make([]*Row, len(rows))
-	for i := range rows {
-		rowPointers[i] = &rows[i]
-	}
-
-	// Apply WHERE clause filtering
-	filteredRows, err := engine.filterRows(rowPointers, schemaPointers, whereClause)
-	if err != nil {
-		return err
+	if explain {
+		return nil
 	}
-
-	output := engine.formatter.FormatTable(filteredRows, schemaPointers)
-	fmt.Print(output)
-	return nil
+	return plan.run()
 }
 
-// handleSelectColumns handles SELECT column statements (single or multiple columns)
-func (engine *SqliteEngine) handleSelectColumns(tableName string, columnNames []string, whereClause *sqlparser.Where) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Get table instance
-	table, err := engine.db.GetTable(ctx, tableName)
-	if err != nil {
-		return err
-	}
-
-	// Get table schema to validate columns and get their indices
-	schema, err := table.GetSchema(ctx)
+// planSelect builds the row pipeline of a SELECT statement without reading any rows
+func (engine *SqliteEngine) planSelect(ctx context.Context, stmt *sqlparser.Select, nullsOrder []NullsOrder) (*selectPlan, error) {
+	from, err := engine.resolveFrom(ctx, stmt.From)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	schema := from.columns
 
-	// Create a map for quick column index lookup
-	columnIndexMap := make(map[string]int)
-	for _, col := range schema {
-		columnIndexMap[strings.ToLower(col.Name)] = col.Index
-	}
+	var columns []resultColumn
+	var names []string
 
-	// Validate all requested columns exist and get their indices
-	columnIndices := make([]int, len(columnNames))
-	for i, columnName := range columnNames {
-		if index, exists := columnIndexMap[strings.ToLower(columnName)]; exists {
-			columnIndices[i] = index
-		} else {
-			return fmt.Errorf("column '%s' not found in table '%s'", columnName, tableName)
+	// First pass: expand the SELECT list into result columns
+	for _, expr := range stmt.SelectExprs {
+		switch selectExpr := expr.(type) {
+		case *sqlparser.StarExpr:
+			qualifier := selectExpr.TableName.Name.String()
+			found := false
+			for i := range schema {
+				col := &schema[i]
+				if col.IsRowid || qualifier == "" && col.Hidden || qualifier != "" && !strings.EqualFold(col.Table, qualifier) {
+					continue
+				}
+				found = true
+				columns = append(columns, resultColumn{expr: qualifiedColumn(col.Table, col.Name)})
+				names = append(names, col.Name)
+			}
+			if qualifier != "" && !found {
+				return nil, fmt.Errorf("no such table: %s", qualifier)
+			}
+		case *sqlparser.AliasedExpr:
+			columns = append(columns, resultColumn{expr: selectExpr.Expr, alias: selectExpr.As.String()})
+			names = append(names, resultName(selectExpr))
+		default:
+			return nil, fmt.Errorf("unsupported SELECT expression type: %T", selectExpr)
 		}
 	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no valid columns found in SELECT statement")
+	}
 
-	// Get all rows
-	rows, err := table.GetRows(ctx)
+	limit, offset, err := evaluateLimit(stmt.Limit)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error in LIMIT clause: %v", err)
 	}
 
-	// Convert []Column to []*Column for compatibility
-	schemaPointers := make([]*Column, len(schema))
-	for i := range schema {
-		schemaPointers[i] = &schema[i]
+	if len(from.tables) == 1 && stmt.Where == nil && stmt.GroupBy == nil && stmt.Having == nil && len(columns) == 1 && isCountStar(columns[0].expr) {
+		return &selectPlan{
+			steps: []*PlanNode{{Detail: "SCAN " + from.tables[0].name}},
+			run:   func() error { return engine.handleCount(ctx, from.tables[0].table, names[0], limit, offset) },
+		}, nil
 	}
 
-	// Convert []Row to []*Row for compatibility
-	rowPointers := make([]*Row, len(rows))
-	for i := range rows {
-		rowPointers[i] = &rows[i]
+	// Aggregate calls anywhere in the SELECT list, HAVING or ORDER BY make this an aggregate query
+	aggregates := NewAggregateSet(len(schema))
+	compiler := NewAggregateCompiler(schema, aggregates)
+	projections := make([]*CompiledExpr, len(columns))
+	for i, column := range columns {
+		if projections[i], err = compiler.Compile(column.expr); err != nil {
+			return nil, fmt.Errorf("error in SELECT list: %v", err)
+		}
 	}
-
-	// Apply WHERE clause filtering
-	filteredRows, err := engine.filterRows(rowPointers, schemaPointers, whereClause)
+	groupTerms, groupKeys, err := groupByTerms(stmt.GroupBy, schema, columns)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error in GROUP BY clause: %v", err)
 	}
-
-	// Output the selected columns for each row
-	for _, row := range filteredRows {
-		rowValues := make([]string, len(columnIndices))
-		for i, colIndex := range columnIndices {
-			value, err := row.Get(colIndex)
-			if err != nil {
-				return fmt.Errorf("error getting value for column index %d: %v", colIndex, err)
-			}
-			rowValues[i] = engine.formatter.FormatValue(value)
+	var having Predicate
+	if stmt.Having != nil {
+		if having, err = compiler.WithAliases(columns).CompilePredicate(stmt.Having.Expr); err != nil {
+			return nil, fmt.Errorf("error in HAVING clause: %v", err)
 		}
-
-		// For single column, print one per line; for multiple columns, print tab-separated
-		if len(rowValues) == 1 {
-			fmt.Println(rowValues[0])
-		} else {
-			fmt.Println(strings.Join(rowValues, "|"))
+	}
+	var keys []sortKey
+	if len(stmt.OrderBy) > 0 {
+		if keys, err = compileOrderBy(stmt.OrderBy, nullsOrder, compiler, columns); err != nil {
+			return nil, fmt.Errorf("error in ORDER BY clause: %v", err)
 		}
 	}
+	isAggregate := len(aggregates.Calls) > 0 || len(groupKeys) > 0
+	if having != nil && !isAggregate {
+		return nil, fmt.Errorf("HAVING clause on a non-aggregate query")
+	}
 
-	return nil
-}
-
-// handleCount handles COUNT(*) statements
-func (engine *SqliteEngine) handleCount(tableName string, whereClause *sqlparser.Where) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	table, err := engine.db.GetTable(ctx, tableName)
+	source, sortedBy, steps, err := engine.selectSource(ctx, stmt, from)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if whereClause == nil {
-		// If no WHERE clause, use the optimized row count method
-		count, err := table.Count(ctx)
-		if err != nil {
-			return err
+	switch {
+	case len(groupKeys) > 0:
+		inOrder := inGroupOrder(groupTerms, schema, sortedBy)
+		if !inOrder {
+			steps = append(steps, &PlanNode{Detail: "USE TEMP B-TREE FOR GROUP BY"})
 		}
-		formatted := engine.formatter.FormatCount(count)
-		fmt.Println(formatted)
-		return nil
+		source = groupSource(source, groupKeys, aggregates, inOrder)
+	case isAggregate:
+		source = aggregateSource(source, aggregates)
 	}
-
-	// If there's a WHERE clause, we need to fetch and filter rows
-	schema, err := table.GetSchema(ctx)
-	if err != nil {
-		return err
+	if having != nil {
+		source = filterSource(source, having, "HAVING")
 	}
 
-	rows, err := table.GetRows(ctx)
-	if err != nil {
-		return err
+	// Without a sort the rows come straight from the B-tree walk, so a LIMIT
+	// stops the scan as soon as enough rows have been produced. The scan order is
+	// lost once rows are grouped.
+	if len(keys) > 0 && (isAggregate || !inSortOrder(stmt.OrderBy, nullsOrder, columns, schema, sortedBy)) {
+		steps = append(steps, &PlanNode{Detail: "USE TEMP B-TREE FOR ORDER BY"})
+		source = sortSource(source, keys)
 	}
+	source = limitSource(source, limit, offset)
 
-	// Convert []Column to []*Column for compatibility
-	schemaPointers := make([]*Column, len(schema))
-	for i := range schema {
-		schemaPointers[i] = &schema[i]
-	}
+	run := func() error { return engine.printRows(names, projectSource(source, projections)) }
+	return &selectPlan{steps: steps, run: run}, nil
+}
 
-	// Convert []Row to []*Row for compatibility
-	rowPointers := make([]*Row, len(rows))
-	for i := range rows {
-		rowPointers[i] = &rows[i]
+// selectSource returns the rows matching the WHERE clause, along with the schema
+// columns the rows are sorted on and the plan steps that read them. Single-table
+// queries use an index when the optimizer finds one; joins are executed by joinSource.
+func (engine *SqliteEngine) selectSource(ctx context.Context, stmt *sqlparser.Select, from *fromClause) (RowSource, []sortedColumn, []*PlanNode, error) {
+	var where sqlparser.Expr
+	if stmt.Where != nil {
+		where = stmt.Where.Expr
 	}
-
-	filteredRows, err := engine.filterRows(rowPointers, schemaPointers, whereClause)
-	if err != nil {
-		return err
+	if len(from.tables) > 1 {
+		source, steps, err := engine.joinSource(ctx, from, where)
+		return source, nil, steps, err
 	}
 
-	count := len(filteredRows)
-	formatted := engine.formatter.FormatCount(count)
-	fmt.Println(formatted)
-	return nil
-}
+	schema := from.columns
+	optimizer := NewQueryOptimizer(engine.db)
+	plan, err := optimizer.OptimizeSelect(stmt)
 
-// extractTableName extracts the table name from a SELECT statement
-func (engine *SqliteEngine) extractTableName(stmt *sqlparser.Select) string {
-	if len(stmt.From) == 0 {
-		return ""
+	// The table B-tree is in rowid order, and so are its scans
+	var source RowSource
+	var sortedBy []sortedColumn
+	for _, col := range schema {
+		if col.IsRowid || col.RowidAlias {
+			sortedBy = append(sortedBy, sortedColumn{index: col.Index})
+		}
 	}
-
-	switch tableExpr := stmt.From[0].(type) {
-	case *sqlparser.AliasedTableExpr:
-		switch table := tableExpr.Expr.(type) {
-		case sqlparser.TableName:
-			return table.Name.String()
+	step := &PlanNode{Detail: "SCAN " + from.tables[0].name}
+	switch {
+	case err == nil && (plan.UseIndex || plan.UseRowid):
+		step = plan.Describe(from.tables[0].name)
+		if source, err = optimizer.ExecutePlan(ctx, plan); err != nil {
+			return nil, nil, nil, fmt.Errorf("error executing optimized query: %v", err)
+		}
+		if plan.UseIndex {
+			// index scans come in index order
+			sortedBy = nil
+			for i, name := range plan.IndexColumns {
+				for _, col := range schema {
+					if !col.IsRowid && strings.EqualFold(col.Name, name) {
+						sortedBy = append(sortedBy, sortedColumn{index: col.Index, desc: plan.IndexDesc[i]})
+					}
+				}
+			}
 		}
+	default:
+		source = from.tables[0].table.Scan(ctx)
 	}
-	return ""
-}
-
-// handleOptimizedSelect handles SELECT statements using query optimization
-func (engine *SqliteEngine) handleOptimizedSelect(stmt *sqlparser.Select, plan *QueryPlan, hasStarExpr, hasCountFunc bool, columnNames []string) error {
-	// 	totalStart := time.Now()
-	// fmt.Printf("[TIMING] Starting optimized select execution\n")
-
-	// Create optimizer and execute plan
-	// 	planStart := time.Now()
-	optimizer := NewQueryOptimizer(engine.db)
-	optimizedRows, err := optimizer.ExecutePlan(plan, stmt)
-	// 	planDuration := time.Since(planStart)
-	// fmt.Printf("[TIMING] Query plan execution took: %v\n", planDuration)
-	if err != nil {
-		return fmt.Errorf("error executing optimized query: %v", err)
+	source = rowidSource(source)
+	steps := []*PlanNode{step}
+	if where == nil {
+		return source, sortedBy, steps, nil
 	}
 
-	// 	schemaStart := time.Now()
-	ctx := context.Background()
-	// Get table for schema information
-	table, err := engine.db.GetTable(ctx, plan.TableName)
+	// Index and rowid ranges can hold rows that fail the rest of the WHERE clause
+	predicate, err := NewExprCompiler(schema).CompilePredicate(where)
 	if err != nil {
-		return err
+		return nil, nil, nil, fmt.Errorf("error compiling WHERE condition: %v", err)
 	}
+	return filterSource(source, predicate, "WHERE"), sortedBy, steps, nil
+}
 
-	schema, err := table.GetSchema(ctx)
-	if err != nil {
-		return err
+// resultName returns the column name of a SELECT list entry: its alias, the
+// name of a column, or else the text of the expression
+func resultName(expr *sqlparser.AliasedExpr) string {
+	if !expr.As.IsEmpty() {
+		return expr.As.String()
 	}
-	// 	schemaDuration := time.Since(schemaStart)
-	// fmt.Printf("[TIMING] Schema retrieval took: %v\n", schemaDuration)
-
-	if hasCountFunc {
-		// Return count of optimized results
-		count := len(optimizedRows)
-		formatted := engine.formatter.FormatCount(count)
-		fmt.Println(formatted)
-		return nil
+	if col, ok := expr.Expr.(*sqlparser.ColName); ok {
+		return col.Name.String()
 	}
+	return sqlparser.String(expr.Expr)
+}
 
-	// Convert optimized rows to the format expected by the formatter
-	// 	conversionStart := time.Now()
-	var rowPointers []*Row
-	for i := range optimizedRows {
-		rowPointers = append(rowPointers, &optimizedRows[i])
+// projectSource evaluates the SELECT list for each row
+func projectSource(source RowSource, exprs []*CompiledExpr) RowSource {
+	return func(yield func(*Row, error) bool) {
+		for row, err := range source {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			values := make([]Value, len(exprs))
+			for i, expr := range exprs {
+				if values[i], err = expr.Eval(row); err != nil {
+					yield(nil, fmt.Errorf("error evaluating column %d: %v", i+1, err))
+					return
+				}
+			}
+			if !yield(&Row{Values: values}, nil) {
+				return
+			}
+		}
 	}
+}
 
-	// Convert schema to pointer format for compatibility
-	var schemaPointers []*Column
-	for i := range schema {
-		schemaPointers = append(schemaPointers, &schema[i])
+// printRows writes result rows with the formatter of the output mode
+func (engine *SqliteEngine) printRows(names []string, source RowSource) error {
+	out := bufio.NewWriter(engine.out)
+	defer out.Flush()
+	formatter := NewOutputFormatter(out, engine.output)
+	if err := formatter.Begin(names); err != nil {
+		return err
 	}
-	// 	conversionDuration := time.Since(conversionStart)
-	// fmt.Printf("[TIMING] Data conversion took: %v\n", conversionDuration)
-	// 	outputStart := time.Now()
-	if hasStarExpr {
-		// Select all columns - use existing formatter
-		for _, row := range rowPointers {
-			output := engine.formatter.FormatRow(row, schemaPointers)
-			fmt.Println(output)
-		}
-	} else {
-		// Select specific columns - manually extract values like in handleSelectColumns
-		// Create a map for quick column index lookup
-		columnIndexMap := make(map[string]int)
-		for _, col := range schema {
-			columnIndexMap[strings.ToLower(col.Name)] = col.Index
+	for row, err := range source {
+		if err != nil {
+			return err
 		}
-
-		// Get column indices
-		columnIndices := make([]int, len(columnNames))
-		for i, columnName := range columnNames {
-			if index, exists := columnIndexMap[strings.ToLower(columnName)]; exists {
-				columnIndices[i] = index
-			} else {
-				return fmt.Errorf("column '%s' not found in table '%s'", columnName, plan.TableName)
-			}
+		if err := formatter.WriteRow(row.Values); err != nil {
+			return err
 		}
+	}
+	return formatter.End()
+}
 
-		// Output the selected columns for each row
-		for _, row := range rowPointers {
-			rowValues := make([]string, len(columnIndices))
-			for i, colIndex := range columnIndices {
-				value, err := row.Get(colIndex)
-				if err != nil {
-					return fmt.Errorf("error getting value for column index %d: %v", colIndex, err)
-				}
-				rowValues[i] = engine.formatter.FormatValue(value)
-			}
-
-			// For single column, print one per line; for multiple columns, print tab-separated
-			if len(rowValues) == 1 {
-				fmt.Fprintf(os.Stdout, "%s\n", rowValues[0])
-			} else {
-				fmt.Fprintf(os.Stdout, "%s\n", strings.Join(rowValues, "|"))
-			}
-		}
+// isCountStar reports whether expr is a plain COUNT(*)
+func isCountStar(expr sqlparser.Expr) bool {
+	fn, ok := expr.(*sqlparser.FuncExpr)
+	if !ok || fn.Name.Lowered() != "count" || fn.Distinct || len(fn.Exprs) != 1 {
+		return false
 	}
-	// 	outputDuration := time.Since(outputStart)
-	// fmt.Printf("[TIMING] Output formatting took: %v\n", outputDuration)
+	_, ok = fn.Exprs[0].(*sqlparser.StarExpr)
+	return ok
+}
 
-	// 	totalDuration := time.Since(totalStart)
-	// fmt.Printf("[TIMING] Total optimized select execution took: %v\n", totalDuration)
+// handleCount handles COUNT(*) statements without a WHERE clause, which only need the number of cells
+func (engine *SqliteEngine) handleCount(ctx context.Context, table Table, name string, limit, offset int64) error {
+	// COUNT produces a single row, which LIMIT and OFFSET can still suppress
+	if limit == 0 || offset > 0 {
+		return nil
+	}
 
-	return nil
+	count, err := table.Count(ctx)
+	if err != nil {
+		return err
+	}
+	return engine.printRows([]string{name}, rowsSource([]Row{{Values: []Value{NewIntegerValue(int64(count))}}}))
 }
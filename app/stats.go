@@ -0,0 +1,305 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TableStats are the planner's statistics for a table and its indexes. Row counts
+// come from sqlite_stat1 and sqlite_stat4 when ANALYZE has run; otherwise they are
+// estimated from the shape of the B-trees, as are the page figures always.
+type TableStats struct {
+	Rows    float64 // rows in the table
+	Depth   int     // pages a rowid lookup reads
+	PerPage float64 // rows per leaf page
+	Indexes map[string]*IndexStats
+}
+
+// IndexStats are the statistics of one index
+type IndexStats struct {
+	Rows       float64       // entries in the index
+	RowsPerKey []float64     // average entries per distinct value of the first i+1 key columns
+	Samples    []IndexSample // sqlite_stat4 samples, in index order
+	Depth      int           // pages a key lookup reads
+	PerPage    float64       // entries per leaf page
+	Analyzed   bool          // RowsPerKey comes from sqlite_stat1
+}
+
+// IndexSample is a sqlite_stat4 sample: an index entry with the number of entries
+// equal to and less than each leading part of its key
+type IndexSample struct {
+	Key IndexKey
+	Eq  []float64
+	Lt  []float64
+}
+
+// defaultRowsPerKey are the rows per key SQLite assumes for an index that has not
+// been analyzed, by number of leading key columns
+var defaultRowsPerKey = []float64{10, 9, 8, 7, 6}
+
+// GetTableStats returns the planner statistics of a table
+func (db *DatabaseImpl) GetTableStats(ctx context.Context, tableName string) (*TableStats, error) {
+	if stats, ok := db.stats[tableName]; ok {
+		return stats, nil
+	}
+	table, err := db.GetTable(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	tableImpl, ok := table.(*TableImpl)
+	if !ok {
+		return nil, fmt.Errorf("table %s is not a TableImpl", tableName)
+	}
+
+	stats := &TableStats{Indexes: make(map[string]*IndexStats)}
+	if stats.Rows, stats.Depth, stats.PerPage, err = tableImpl.tableRaw.Cursor(ctx).Estimate(); err != nil {
+		return nil, fmt.Errorf("estimate table %s: %w", tableName, err)
+	}
+	indexes, err := tableImpl.GetIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		indexStats := &IndexStats{}
+		switch index := index.(type) {
+		case *IndexImpl:
+			if indexStats.Rows, indexStats.Depth, indexStats.PerPage, err = index.indexRaw.Cursor(ctx).Estimate(); err != nil {
+				return nil, fmt.Errorf("estimate index %s: %w", index.GetName(), err)
+			}
+		case *primaryKeyIndex:
+			// the primary key of a WITHOUT ROWID table is the table B-tree itself
+			indexStats.Rows, indexStats.Depth, indexStats.PerPage = stats.Rows, stats.Depth, stats.PerPage
+		default:
+			continue
+		}
+		stats.Indexes[index.GetName()] = indexStats
+	}
+
+	if err := db.readStat1(ctx, tableName, stats); err != nil {
+		return nil, err
+	}
+	if err := db.readStat4(ctx, tableName, stats); err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if indexStats, ok := stats.Indexes[index.GetName()]; ok && !indexStats.Analyzed {
+			indexStats.RowsPerKey = estimatedRowsPerKey(index, indexStats.Rows)
+		}
+	}
+
+	db.stats[tableName] = stats
+	return stats, nil
+}
+
+// estimatedRowsPerKey fills in the rows per key of an index without sqlite_stat1
+// statistics the way SQLite does: a few rows per key, and one for a whole key of
+// a unique index
+func estimatedRowsPerKey(index Index, rows float64) []float64 {
+	perKey := make([]float64, len(index.GetKeyColumns()))
+	for i := range perKey {
+		perKey[i] = defaultRowsPerKey[min(i, len(defaultRowsPerKey)-1)]
+		perKey[i] = max(min(perKey[i], rows), 1)
+	}
+	if index.IsUnique() && len(perKey) > 0 {
+		perKey[len(perKey)-1] = 1
+	}
+	return perKey
+}
+
+// statRows returns the records of a statistics table, or nil if ANALYZE has not
+// created it. They are read positionally, without the table's typeless schema.
+func (db *DatabaseImpl) statRows(ctx context.Context, name string) ([][]Value, error) {
+	for _, schema := range db.schemas {
+		if schema.Type != "table" || !strings.EqualFold(schema.Name, name) {
+			continue
+		}
+		var rows [][]Value
+		for cell, err := range NewTableRaw(db.dbRaw, schema.Name, int(schema.RootPage)).Cells(ctx) {
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", name, err)
+			}
+			rows = append(rows, cell.Record.ColumnValues())
+		}
+		return rows, nil
+	}
+	return nil, nil
+}
+
+// readStat1 applies the sqlite_stat1 rows of a table. A row with a NULL idx holds
+// the row count of a table without indexes; an index row holds the entry count
+// followed by the average entries per value of each leading part of the key.
+func (db *DatabaseImpl) readStat1(ctx context.Context, tableName string, stats *TableStats) error {
+	rows, err := db.statRows(ctx, "sqlite_stat1")
+	if err != nil {
+		return err
+	}
+	analyzed := false
+	tableRows := 0.0
+	for _, row := range rows {
+		if len(row) < 3 || !strings.EqualFold(row[0].String(), tableName) {
+			continue
+		}
+		numbers := statNumbers(row[2].String())
+		if len(numbers) == 0 {
+			continue
+		}
+		// a partial index holds only some of the rows, so the table has the most
+		analyzed = true
+		tableRows = max(tableRows, numbers[0])
+		if indexStats, ok := stats.Indexes[row[1].String()]; ok && row[1].Type() != ValueTypeNull {
+			indexStats.Rows = numbers[0]
+			indexStats.RowsPerKey = numbers[1:]
+			indexStats.Analyzed = true
+		}
+	}
+	if analyzed {
+		stats.Rows = tableRows
+	}
+	return nil
+}
+
+// readStat4 loads the sqlite_stat4 samples of a table's indexes. The neq and nlt
+// columns list a count per leading part of the key, and sample is the sampled
+// index entry as a record.
+func (db *DatabaseImpl) readStat4(ctx context.Context, tableName string, stats *TableStats) error {
+	rows, err := db.statRows(ctx, "sqlite_stat4")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if len(row) < 6 || !strings.EqualFold(row[0].String(), tableName) {
+			continue
+		}
+		indexStats, ok := stats.Indexes[row[1].String()]
+		if !ok {
+			continue
+		}
+		record, err := parseRecord(row[5].Raw(), TextEncoding(db.dbRaw.GetHeader().TextEncoding))
+		if err != nil {
+			continue
+		}
+		sample := IndexSample{Key: IndexKey(record.ColumnValues()), Eq: statNumbers(row[2].String()), Lt: statNumbers(row[3].String())}
+		if len(sample.Key) > 0 && len(sample.Eq) > 0 && len(sample.Eq) == len(sample.Lt) {
+			indexStats.Samples = append(indexStats.Samples, sample)
+		}
+	}
+	for _, indexStats := range stats.Indexes {
+		slices.SortFunc(indexStats.Samples, func(a, b IndexSample) int {
+			return cmp.Compare(a.Lt[len(a.Lt)-1], b.Lt[len(b.Lt)-1])
+		})
+	}
+	return nil
+}
+
+// statNumbers parses the leading integers of a statistics list such as
+// "30000 4286 268 unordered", ignoring the keywords after them
+func statNumbers(list string) []float64 {
+	var numbers []float64
+	for _, field := range strings.Fields(list) {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, float64(n))
+	}
+	return numbers
+}
+
+// scanCost estimates the pages a full table scan reads: the path down to the
+// first leaf, then every leaf
+func (s *TableStats) scanCost() float64 {
+	return float64(s.Depth) + s.Rows/s.PerPage
+}
+
+// rangeRows estimates the rows of a rowid range: one for a single rowid, and a
+// quarter of the table for each bound otherwise, as SQLite assumes
+func (s *TableStats) rangeRows(r IndexRange) float64 {
+	if _, ok := r.point(); ok {
+		return 1
+	}
+	rows := s.Rows
+	if r.Lower != nil {
+		rows /= 4
+	}
+	if r.Upper != nil {
+		rows /= 4
+	}
+	return max(rows, 1)
+}
+
+// keyRows estimates the entries whose key starts with key: the sampled count when
+// a sample has that prefix, otherwise the average rows per key
+func (s *IndexStats) keyRows(key IndexKey, columns []IndexKeyColumn) float64 {
+	n := len(key)
+	for _, sample := range s.Samples {
+		if len(sample.Key) >= n && len(sample.Eq) >= n && compareIndexKeys(columns, sample.Key[:n], key) == 0 {
+			return sample.Eq[n-1]
+		}
+	}
+	if len(s.RowsPerKey) == 0 {
+		return s.Rows
+	}
+	return s.RowsPerKey[min(n, len(s.RowsPerKey))-1]
+}
+
+// before estimates the entries whose first key column sorts before value in the
+// index, interpolating between the samples around it
+func (s *IndexStats) before(value Value, columns []IndexKeyColumn) float64 {
+	previous := 0.0
+	for _, sample := range s.Samples {
+		c := compareIndexKeys(columns, sample.Key[:1], IndexKey{value})
+		if c == 0 {
+			return sample.Lt[0]
+		}
+		if c > 0 {
+			return (previous + sample.Lt[0]) / 2
+		}
+		previous = sample.Lt[0] + sample.Eq[0]
+	}
+	return (previous + s.Rows) / 2
+}
+
+// rangeRows estimates the entries of an index range. Equalities on the leading
+// key columns select their rows per key; bounds on the next column select the
+// entries between them according to the samples, or a quarter of the rows each
+// without samples, as SQLite assumes.
+func (s *IndexStats) rangeRows(r IndexRange, columns []IndexKeyColumn) float64 {
+	if value, ok := r.point(); ok {
+		return s.keyRows(append(slices.Clone(IndexKey(r.Equal)), value), columns)
+	}
+	rows := s.Rows
+	if len(r.Equal) > 0 {
+		rows = s.keyRows(IndexKey(r.Equal), columns)
+	}
+	if r.Lower == nil && r.Upper == nil {
+		return rows
+	}
+
+	if len(r.Equal) == 0 && len(s.Samples) > 0 {
+		// a DESC column has the high values first
+		desc := len(columns) > 0 && columns[0].Desc
+		lower, upper := 0.0, s.Rows
+		if desc {
+			lower, upper = upper, lower
+		}
+		if r.Lower != nil {
+			lower = s.before(r.Lower.Value, columns)
+		}
+		if r.Upper != nil {
+			upper = s.before(r.Upper.Value, columns)
+		}
+		return max(math.Abs(upper-lower), 1)
+	}
+	if r.Lower != nil {
+		rows /= 4
+	}
+	if r.Upper != nil {
+		rows /= 4
+	}
+	return max(rows, 1)
+}
@@ -3,21 +3,31 @@ package main
 import (
 	"context"
 	"fmt"
+	"iter"
+	"strconv"
+	"strings"
 )
 
 // TableImpl implements TableInterface
 type TableImpl struct {
 	tableRaw TableRaw
 	schema   *SchemaRecord
-	columns  []Column // cached column information
-	indexes  []Index  // cached indexes for this table
+	encoding TextEncoding // encoding the database stores TEXT in
+	columns  []Column     // cached column information
+	indexes  []Index      // cached indexes for this table
+
+	// primaryKey is set for a WITHOUT ROWID table, whose records hold the columns
+	// at the positions in storage
+	primaryKey *primaryKeyIndex
+	storage    []int
 }
 
 // NewTable creates a new logical table instance
-func NewTable(tableRaw TableRaw, schema *SchemaRecord) *TableImpl {
+func NewTable(tableRaw TableRaw, schema *SchemaRecord, encoding TextEncoding) *TableImpl {
 	return &TableImpl{
 		tableRaw: tableRaw,
 		schema:   schema,
+		encoding: encoding,
 	}
 }
 
@@ -33,6 +43,9 @@ func (t *TableImpl) GetSchema(ctx context.Context) ([]Column, error) {
 	if err != nil {
 		return nil, fmt.Errorf("get table schema for %s: %w", t.schema.Name, err)
 	}
+	for i := range columns {
+		columns[i].encoding = t.encoding
+	}
 
 	// Cache columns
 	t.columns = columns
@@ -41,24 +54,63 @@ func (t *TableImpl) GetSchema(ctx context.Context) ([]Column, error) {
 
 // GetRows returns all rows from the table
 func (t *TableImpl) GetRows(ctx context.Context) ([]Row, error) {
-	cells, err := t.tableRaw.ReadAllCells(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("get rows for table %s: %w", t.schema.Name, err)
-	}
-
-	rows := make([]Row, len(cells))
-	for i, cell := range cells {
-		row, err := t.cellToRow(cell)
+	var rows []Row
+	for row, err := range t.Scan(ctx) {
 		if err != nil {
-			return nil, fmt.Errorf("convert cell %d to row for table %s: %w",
-				i, t.schema.Name, err)
+			return nil, fmt.Errorf("get rows for table %s: %w", t.schema.Name, err)
 		}
-		rows[i] = *row
+		rows = append(rows, *row)
 	}
 
 	return rows, nil
 }
 
+// Scan streams the table's rows in rowid order. Breaking out of the loop stops
+// the underlying B-tree cursor, so only the pages needed so far are read.
+func (t *TableImpl) Scan(ctx context.Context) iter.Seq2[*Row, error] {
+	return func(yield func(*Row, error) bool) {
+		for cell, err := range t.tableRaw.Cells(ctx) {
+			if err != nil {
+				yield(nil, fmt.Errorf("scan table %s: %w", t.schema.Name, err))
+				return
+			}
+			row, err := t.cellToRow(*cell)
+			if err != nil {
+				yield(nil, fmt.Errorf("convert cell %d to row for table %s: %w", cell.Rowid, t.schema.Name, err))
+				return
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ScanFrom streams the table's rows in rowid order, starting at the first row
+// whose rowid is not smaller than rowid. The cursor seeks straight to it.
+func (t *TableImpl) ScanFrom(ctx context.Context, rowid int64) iter.Seq2[*Row, error] {
+	return func(yield func(*Row, error) bool) {
+		if t.WithoutRowid() {
+			yield(nil, fmt.Errorf("table %s is WITHOUT ROWID", t.schema.Name))
+			return
+		}
+		cursor := t.tableRaw.Cursor(ctx)
+		for cursor.Seek(uint64(rowid)); cursor.Valid(); cursor.Next() {
+			row, err := t.cellToRow(*cursor.Cell())
+			if err != nil {
+				yield(nil, fmt.Errorf("convert cell %d to row for table %s: %w", cursor.Cell().Rowid, t.schema.Name, err))
+				return
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			yield(nil, fmt.Errorf("scan table %s: %w", t.schema.Name, err))
+		}
+	}
+}
+
 // SelectColumns returns rows with only the specified columns
 func (t *TableImpl) SelectColumns(ctx context.Context, columns []string) ([]Row, error) {
 	// Get all rows first
@@ -130,12 +182,15 @@ func (t *TableImpl) Filter(ctx context.Context, condition func(Row) bool) ([]Row
 
 // Count returns the number of rows in the table
 func (t *TableImpl) Count(ctx context.Context) (int, error) {
-	cells, err := t.tableRaw.ReadAllCells(ctx)
-	if err != nil {
-		return 0, fmt.Errorf("count rows for table %s: %w", t.schema.Name, err)
+	count := 0
+	for _, err := range t.tableRaw.Cells(ctx) {
+		if err != nil {
+			return 0, fmt.Errorf("count rows for table %s: %w", t.schema.Name, err)
+		}
+		count++
 	}
 
-	return len(cells), nil
+	return count, nil
 }
 
 // GetName returns the table name
@@ -163,6 +218,28 @@ func (t *TableImpl) GetIndexByName(name string) (Index, bool) {
 	return nil, false
 }
 
+// autoindexKey returns the key columns of sqlite_autoindex_<table>_<n>, the index
+// SQLite built for the table's n-th PRIMARY KEY or UNIQUE constraint
+func (t *TableImpl) autoindexKey(ctx context.Context, name string) ([]IndexKeyColumn, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "sqlite_autoindex_"+t.schema.Name+"_"))
+	if err != nil || n < 1 {
+		return nil, false
+	}
+	columns, err := t.GetSchema(ctx)
+	if err != nil {
+		return nil, false
+	}
+	var rowidAlias string
+	if i := t.findRowidAliasIndex(columns); i >= 0 {
+		rowidAlias = columns[i].Name
+	}
+	keys := autoindexKeys(t.schema.SQL, rowidAlias)
+	if n > len(keys) {
+		return nil, false
+	}
+	return keys[n-1], true
+}
+
 // GetRowByRowid gets a specific row by its rowid
 func (t *TableImpl) GetRowByRowid(ctx context.Context, rowid int64) (*Row, error) {
 	// Use the raw table to get the specific cell
@@ -185,34 +262,48 @@ func (t *TableImpl) GetRowByRowid(ctx context.Context, rowid int64) (*Row, error
 // SQLite Record Format:
 // - Record Header: Contains serial types for each column in schema order (serial type 0 = NULL or not stored)
 // - Record Body: Contains actual data values in order (only for columns with serial type != 0)
-// - INTEGER PRIMARY KEY AUTOINCREMENT columns typically have serial type 0 (use rowid instead)
+// - INTEGER PRIMARY KEY columns alias the rowid and are stored as NULL (serial type 0)
 func (t *TableImpl) cellToRow(cell Cell) (*Row, error) {
 	columns, err := t.GetSchema(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("get schema for cellToRow: %w", err)
 	}
+	if t.WithoutRowid() {
+		return t.withoutRowidRow(cell, columns), nil
+	}
 
-	autoincrementColumnIndex := t.findAutoIncrementColumnIndex(columns)
 	values := make([]Value, len(columns))
 
 	processor := &columnProcessor{
-		cell:                     cell,
-		recordBodyIndex:          0,
-		autoincrementColumnIndex: autoincrementColumnIndex,
+		cell:            cell,
+		recordBodyIndex: 0,
+		rowidAliasIndex: t.findRowidAliasIndex(columns),
 	}
 
 	for i := 0; i < len(columns); i++ {
 		serialType := processor.getSerialType(i)
 		values[i] = processor.processColumn(i, serialType)
+
+		values[i] = columnValue(values[i], columns[i])
 	}
 
-	return &Row{Values: values}, nil
+	return &Row{Values: values, Rowid: int64(cell.Rowid)}, nil
 }
 
-// findAutoIncrementColumnIndex finds the index of the auto-increment primary key column
-func (t *TableImpl) findAutoIncrementColumnIndex(columns []Column) int {
+// columnValue converts a value read from a record to the column's type.
+// SQLite stores integral REAL values as integers on disk; REAL affinity converts them back.
+func columnValue(value Value, column Column) Value {
+	if IsNumeric(value) && value.Type() != ValueTypeFloat64 && AffinityForType(column.Type) == AffinityReal {
+		f, _ := value.Float64()
+		return NewRealValue(f)
+	}
+	return value
+}
+
+// findRowidAliasIndex finds the index of the INTEGER PRIMARY KEY column, or -1
+func (t *TableImpl) findRowidAliasIndex(columns []Column) int {
 	for i, col := range columns {
-		if col.IsPrimaryKey && col.IsAutoIncrement {
+		if col.RowidAlias {
 			return i
 		}
 	}
@@ -221,9 +312,9 @@ func (t *TableImpl) findAutoIncrementColumnIndex(columns []Column) int {
 
 // columnProcessor handles the processing of individual columns during row conversion
 type columnProcessor struct {
-	cell                     Cell
-	recordBodyIndex          int
-	autoincrementColumnIndex int
+	cell            Cell
+	recordBodyIndex int
+	rowidAliasIndex int
 }
 
 // getSerialType returns the serial type for the given column index
@@ -237,8 +328,8 @@ func (cp *columnProcessor) getSerialType(columnIndex int) uint64 {
 // processColumn processes a single column and returns its value
 func (cp *columnProcessor) processColumn(columnIndex int, serialType uint64) Value {
 	switch {
-	case serialType == 0 && columnIndex == cp.autoincrementColumnIndex:
-		return cp.handleAutoIncrementColumn()
+	case serialType == 0 && columnIndex == cp.rowidAliasIndex:
+		return cp.handleRowidAliasColumn()
 	case serialType == 0:
 		return cp.handleNullColumn()
 	default:
@@ -246,11 +337,9 @@ func (cp *columnProcessor) processColumn(columnIndex int, serialType uint64) Val
 	}
 }
 
-// handleAutoIncrementColumn creates a value from the cell's rowid for auto-increment columns
-func (cp *columnProcessor) handleAutoIncrementColumn() Value {
-	rowidString := fmt.Sprintf("%d", cp.cell.Rowid)
-	textSerialType := uint64(13 + 2*len(rowidString)) // Text serial type formula
-	return NewSQLiteValue(textSerialType, []byte(rowidString))
+// handleRowidAliasColumn returns the cell's rowid as the value of the rowid alias column
+func (cp *columnProcessor) handleRowidAliasColumn() Value {
+	return NewIntegerValue(int64(cp.cell.Rowid))
 }
 
 // handleNullColumn creates a NULL value for columns with no stored data
@@ -260,6 +349,11 @@ func (cp *columnProcessor) handleNullColumn() Value {
 
 // handleRegularColumn reads and creates a value from the record body for regular columns
 func (cp *columnProcessor) handleRegularColumn(serialType uint64) Value {
+	// Constant serial types (0 and 1) carry no bytes in the record body
+	if getSerialTypeSize(serialType) == 0 {
+		return NewSQLiteValue(serialType, nil)
+	}
+
 	if cp.recordBodyIndex >= len(cp.cell.Record.RecordBody.Values) {
 		return NewSQLiteValue(0, nil) // No more data available
 	}
@@ -3,13 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
+	"iter"
 )
 
 // TableRawImpl implements TableRaw interface for raw SQLite table operations
 type TableRawImpl struct {
-	dbRaw    DatabaseRaw
-	name     string
-	rootPage int
+	dbRaw      DatabaseRaw
+	name       string
+	rootPage   int
+	primaryKey []IndexKeyColumn // key of a WITHOUT ROWID table; nil for a rowid table
 }
 
 // NewTableRaw creates a new raw table instance
@@ -21,11 +23,21 @@ func NewTableRaw(dbRaw DatabaseRaw, name string, rootPage int) *TableRawImpl {
 	}
 }
 
+// NewWithoutRowidTableRaw creates a raw WITHOUT ROWID table, stored as an index
+// B-tree whose records start with the primary key columns
+func NewWithoutRowidTableRaw(dbRaw DatabaseRaw, name string, rootPage int, primaryKey []IndexKeyColumn) *TableRawImpl {
+	return &TableRawImpl{
+		dbRaw:      dbRaw,
+		name:       name,
+		rootPage:   rootPage,
+		primaryKey: primaryKey,
+	}
+}
+
 // ReadAllCells reads all cells from the table's root page using B-tree traversal
 func (tr *TableRawImpl) ReadAllCells(ctx context.Context) ([]Cell, error) {
 	// Use B-tree abstraction for traversal
-	btree := NewBTree(tr.dbRaw, tr.rootPage, BTreeTypeTable)
-	cells, err := btree.TraverseAll(ctx)
+	cells, err := tr.btree().TraverseAll(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("traverse table %s B-tree: %w", tr.name, err)
 	}
@@ -33,6 +45,36 @@ func (tr *TableRawImpl) ReadAllCells(ctx context.Context) ([]Cell, error) {
 	return cells, nil
 }
 
+// Cells streams the table's cells in rowid order, or primary key order for a
+// WITHOUT ROWID table
+func (tr *TableRawImpl) Cells(ctx context.Context) iter.Seq2[*Cell, error] {
+	return func(yield func(*Cell, error) bool) {
+		for cell, err := range tr.btree().Cells(ctx) {
+			if err != nil {
+				yield(nil, fmt.Errorf("walk table %s B-tree: %w", tr.name, err))
+				return
+			}
+			if !yield(cell, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Cursor returns a cursor over the table's B-tree, keyed by rowid or, for a
+// WITHOUT ROWID table, by primary key
+func (tr *TableRawImpl) Cursor(ctx context.Context) *BTreeCursor {
+	return tr.btree().Cursor(ctx)
+}
+
+// btree returns the B-tree rooted at the table's root page
+func (tr *TableRawImpl) btree() *BTree {
+	if tr.primaryKey != nil {
+		return NewIndexBTree(tr.dbRaw, tr.rootPage, tr.primaryKey)
+	}
+	return NewBTree(tr.dbRaw, tr.rootPage, BTreeTypeTable)
+}
+
 // GetRootPage returns the root page number
 func (tr *TableRawImpl) GetRootPage() int {
 	return tr.rootPage
@@ -45,15 +87,17 @@ func (tr *TableRawImpl) GetName() string {
 
 // ReadCellByRowid reads a specific cell by rowid using B-tree search
 func (tr *TableRawImpl) ReadCellByRowid(ctx context.Context, targetRowid int64) (*Cell, error) {
+	if tr.primaryKey != nil {
+		return nil, fmt.Errorf("table %s is WITHOUT ROWID", tr.name)
+	}
 	// Use B-tree search to find the specific rowid
-	btree := NewBTree(tr.dbRaw, tr.rootPage, BTreeTypeTable)
-	cells, err := btree.Search(ctx, uint64(targetRowid))
+	cells, err := tr.btree().Search(ctx, uint64(targetRowid))
 	if err != nil {
 		return nil, fmt.Errorf("search for rowid %d: %w", targetRowid, err)
 	}
 
 	if len(cells) == 0 {
-		return nil, fmt.Errorf("rowid %d not found in table %s", targetRowid, tr.name)
+		return nil, fmt.Errorf("rowid %d in table %s: %w", targetRowid, tr.name, ErrRowNotFound)
 	}
 
 	// Return first matching cell
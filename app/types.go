@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"iter"
 )
 
 // Logical layer interfaces - clean user-facing API
@@ -13,6 +14,11 @@ type Database interface {
 	DatabaseProvider
 	io.Closer
 	GetPageSize() int
+	GetHeader() *DatabaseHeader
+	FileSize() (int64, error)
+	Snapshots() []WALSnapshot
+	UseSnapshot(frame int) error
+	PageCacheStats() PageCacheStats
 }
 
 // DatabaseProvider consolidates schema, table and index access
@@ -28,6 +34,9 @@ type DatabaseProvider interface {
 	GetIndex(ctx context.Context, name string) (Index, error)
 	GetIndices(ctx context.Context) ([]string, error)
 	GetTableIndexes(ctx context.Context, tableName string) ([]Index, error)
+
+	// Planner statistics
+	GetTableStats(ctx context.Context, tableName string) (*TableStats, error)
 }
 
 // Table represents a logical table with user-friendly operations
@@ -37,6 +46,8 @@ type Table interface {
 	GetIndexes(ctx context.Context) ([]Index, error)
 	GetIndexByName(name string) (Index, bool)
 	GetRowByRowid(ctx context.Context, rowid int64) (*Row, error)
+	ScanFrom(ctx context.Context, rowid int64) iter.Seq2[*Row, error]
+	WithoutRowid() bool
 }
 
 // Index represents a logical index with user-friendly operations
@@ -46,6 +57,11 @@ type Index interface {
 	GetName() string
 	GetTableName() string
 	SearchByKey(ctx context.Context, key interface{}) ([]IndexEntry, error)
+	Scan(ctx context.Context) iter.Seq2[*IndexEntry, error]
+	ScanFrom(ctx context.Context, key interface{}) iter.Seq2[*IndexEntry, error]
+	GetKeyColumns() []IndexKeyColumn
+	IsPartial() bool
+	IsUnique() bool
 }
 
 // DataOperations consolidates all data access operations for tables
@@ -55,6 +71,7 @@ type DataOperations interface {
 
 	// Data reading operations
 	GetRows(ctx context.Context) ([]Row, error)
+	Scan(ctx context.Context) iter.Seq2[*Row, error]
 	Count(ctx context.Context) (int, error)
 
 	// Data filtering operations
@@ -68,12 +85,18 @@ type DataOperations interface {
 type DatabaseRaw interface {
 	RawDataAccess
 	io.Closer
+	GetHeader() *DatabaseHeader
+	FileSize() (int64, error)
+	Snapshots() []WALSnapshot
+	UseSnapshot(frame int) error
+	PageCacheStats() PageCacheStats
 }
 
 // RawDataAccess consolidates raw data access operations
 type RawDataAccess interface {
 	ReadPage(ctx context.Context, pageNum int) ([]byte, error)
 	GetPageSize() int
+	GetUsableSize() int
 	ReadSchemaTable(ctx context.Context) ([]Cell, error)
 }
 
@@ -92,12 +115,18 @@ type IndexRaw interface {
 	GetName() string
 	// Index-specific methods
 	SearchKeys(ctx context.Context, key interface{}) ([]IndexEntry, error)
+	ScanEntries(ctx context.Context, from interface{}) iter.Seq2[*IndexEntry, error]
 	GetIndexedColumns() []string
+	GetKeyColumns() []IndexKeyColumn
+	IsPartial() bool
+	IsUnique() bool
 }
 
 // CellReader provides cell reading capabilities
 type CellReader interface {
 	ReadAllCells(ctx context.Context) ([]Cell, error)
+	Cells(ctx context.Context) iter.Seq2[*Cell, error]
+	Cursor(ctx context.Context) *BTreeCursor
 }
 
 // Physical data structures from SQLite format
@@ -105,10 +134,10 @@ type CellReader interface {
 // Cell represents a B-tree cell (varies by page type)
 type Cell struct {
 	// For Table B-Tree Leaf Cell (header 0x0d):
-	PayloadSize uint64 // varint: total bytes of payload including overflow
-	Rowid       uint64 // varint: integer key (rowid)
-	Record      Record // parsed record from payload
-	// OverflowPage uint32 // 4-byte page number for overflow (if needed)
+	PayloadSize  uint64 // varint: total bytes of payload including overflow
+	Rowid        uint64 // varint: integer key (rowid)
+	Record       Record // parsed record from payload
+	OverflowPage uint32 // first overflow page number, or 0 if the payload fits on the page
 }
 
 // Record represents a record within a cell
@@ -399,59 +428,54 @@ func readRecordBody(data []byte, offset int, header RecordHeader) (RecordBody, i
 	return body, currentOffset, nil
 }
 
-// ParseAsSchema parses the record body as a schema table record
-func (rb *RecordBody) ParseAsSchema() *SchemaRecord {
-	if len(rb.Values) < 5 {
+// ParseAsSchema parses the record as a row of the schema table. The columns are
+// read by position, since the body leaves out a NULL sql column and a rootpage of 0.
+func (r *Record) ParseAsSchema() *SchemaRecord {
+	values := r.ColumnValues()
+	if len(values) < 5 {
 		return nil // Invalid schema record
 	}
 
-	schema := &SchemaRecord{}
-
-	// Parse each field from the Values slice
-	if rb.Values[0] != nil {
-		schema.Type = string(rb.Values[0].([]byte))
-	}
-	if rb.Values[1] != nil {
-		schema.Name = string(rb.Values[1].([]byte))
+	schema := &SchemaRecord{
+		Type:    values[0].String(),
+		Name:    values[1].String(),
+		TblName: values[2].String(),
+		SQL:     values[4].String(),
 	}
-	if rb.Values[2] != nil {
-		schema.TblName = string(rb.Values[2].([]byte))
-	}
-	if rb.Values[3] != nil {
-		// Parse rootpage as integer from bytes
-		rootPageBytes := rb.Values[3].([]byte)
-		if len(rootPageBytes) > 0 {
-			// Convert bytes to uint32 (big-endian)
-			var rootPage uint32
-			for i, b := range rootPageBytes {
-				if i >= 4 { // Limit to 4 bytes for uint32
-					break
-				}
-				rootPage = (rootPage << 8) | uint32(b)
-			}
-			schema.RootPage = rootPage
-		}
-	}
-	if rb.Values[4] != nil {
-		schema.SQL = string(rb.Values[4].([]byte))
+	if rootPage, err := values[3].Int64(); err == nil {
+		schema.RootPage = uint32(rootPage)
 	}
 
 	// Set the union field
-	rb.Schema = schema
+	r.Schema = schema
 	return schema
 }
 
 // IsSchemaRecord checks if this record appears to be from sqlite_schema/sqlite_master
-func (rb *RecordBody) IsSchemaRecord() bool {
-	if len(rb.Values) != 5 {
+func (r *Record) IsSchemaRecord() bool {
+	values := r.ColumnValues()
+	if len(values) != 5 {
 		return false
 	}
 
 	// Check if first field looks like a schema type
-	if rb.Values[0] != nil {
-		typeStr := string(rb.Values[0].([]byte))
-		return typeStr == "table" || typeStr == "index" || typeStr == "view" || typeStr == "trigger"
+	typeStr := values[0].String()
+	return typeStr == "table" || typeStr == "index" || typeStr == "view" || typeStr == "trigger"
+}
+
+// ColumnValues returns one value per serial type in the record header. The record
+// body only holds the values that occupy bytes, so NULLs and the constants 0 and 1
+// are rebuilt from their serial types.
+func (r *Record) ColumnValues() []Value {
+	values := make([]Value, len(r.SerialTypes))
+	bodyIndex := 0
+	for i, serialType := range r.SerialTypes {
+		if getSerialTypeSize(serialType) == 0 || bodyIndex >= len(r.Values) {
+			values[i] = NewSQLiteValue(serialType, nil)
+			continue
+		}
+		values[i] = NewSQLiteValue(serialType, ConvertToBytes(r.Values[bodyIndex]))
+		bodyIndex++
 	}
-
-	return false
+	return values
 }
@@ -3,6 +3,9 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 )
 
 // Value represents a typed database value
@@ -46,6 +49,35 @@ func NewSQLiteValue(serialType uint64, data []byte) *SQLiteValue {
 	}
 }
 
+// NewNullValue creates a NULL value
+func NewNullValue() *SQLiteValue {
+	return NewSQLiteValue(SerialTypeNull, nil)
+}
+
+// NewIntegerValue creates an INTEGER value stored as a 64-bit big-endian integer
+func NewIntegerValue(i int64) *SQLiteValue {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(i))
+	return NewSQLiteValue(SerialTypeInt64, data)
+}
+
+// NewRealValue creates a REAL value stored as a big-endian IEEE-754 double
+func NewRealValue(f float64) *SQLiteValue {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(f))
+	return NewSQLiteValue(SerialTypeFloat64, data)
+}
+
+// NewTextValue creates a TEXT value
+func NewTextValue(s string) *SQLiteValue {
+	return NewSQLiteValue(uint64(13+2*len(s)), []byte(s))
+}
+
+// NewBlobValue creates a BLOB value
+func NewBlobValue(data []byte) *SQLiteValue {
+	return NewSQLiteValue(uint64(12+2*len(data)), data)
+}
+
 // Type returns the value type
 func (v *SQLiteValue) Type() ValueType {
 	switch v.serialType {
@@ -95,13 +127,15 @@ func (v *SQLiteValue) String() string {
 		return "1"
 	case ValueTypeText, ValueTypeBlob:
 		return string(v.data)
+	case ValueTypeFloat64:
+		if f, err := v.Float64(); err == nil {
+			return formatReal(f)
+		}
+		return ""
 	default:
 		if i, err := v.Int64(); err == nil {
 			return fmt.Sprintf("%d", i)
 		}
-		if f, err := v.Float64(); err == nil {
-			return fmt.Sprintf("%g", f)
-		}
 		return ""
 	}
 }
@@ -159,7 +193,7 @@ func (v *SQLiteValue) Float64() (float64, error) {
 	case ValueTypeFloat64:
 		if len(v.data) >= 8 {
 			bits := binary.BigEndian.Uint64(v.data)
-			return float64FromBits(bits), nil
+			return math.Float64frombits(bits), nil
 		}
 		return 0, fmt.Errorf("insufficient data for float64")
 	case ValueTypeZero:
@@ -175,11 +209,69 @@ func (v *SQLiteValue) Float64() (float64, error) {
 	}
 }
 
-// float64FromBits converts uint64 bits to float64 (Go's math.Float64frombits equivalent)
-func float64FromBits(b uint64) float64 {
-	// This is a simplified version - in practice you'd use math.Float64frombits
-	// For now, just return a placeholder
-	return float64(b)
+// formatReal renders a REAL the way the sqlite3 shell does ("%!.15g"):
+// 15 significant digits, and the mantissa always keeps a decimal point (1.0, 1.0e+20)
+func formatReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "" // SQLite stores NaN as NULL
+	case f == 0:
+		return "0.0" // Negative zero is rendered without a sign
+	}
+
+	s := strconv.FormatFloat(f, 'g', 15, 64)
+	mantissa, exponent := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, exponent = s[:i], s[i:]
+	}
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	return mantissa + exponent
+}
+
+// IsNumeric reports whether the value has INTEGER or REAL storage class
+func IsNumeric(value Value) bool {
+	switch value.Type() {
+	case ValueTypeNull, ValueTypeText, ValueTypeBlob:
+		return false
+	default:
+		return true
+	}
+}
+
+// Affinity represents the type affinity of a column
+type Affinity uint8
+
+const (
+	AffinityNone Affinity = iota // expressions such as literals have no affinity
+	AffinityBlob
+	AffinityText
+	AffinityNumeric
+	AffinityInteger
+	AffinityReal
+)
+
+// AffinityForType determines column affinity from a declared type name
+// using SQLite's rules, applied in order
+func AffinityForType(declaredType string) Affinity {
+	t := strings.ToUpper(declaredType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return AffinityInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return AffinityText
+	case strings.Contains(t, "BLOB"), t == "":
+		return AffinityBlob
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return AffinityReal
+	default:
+		return AffinityNumeric
+	}
 }
 
 // Column represents a database column
@@ -188,19 +280,55 @@ type Column struct {
 	Type            string
 	Index           int
 	Nullable        bool
-	IsPrimaryKey    bool // true if this is a PRIMARY KEY column
-	IsAutoIncrement bool // true if this is an AUTOINCREMENT column
+	IsPrimaryKey    bool   // true if this is a PRIMARY KEY column
+	IsAutoIncrement bool   // true if this is an AUTOINCREMENT column
+	RowidAlias      bool   // true for an INTEGER PRIMARY KEY column, which stores the rowid
+	Collation       string // declared COLLATE sequence, empty for BINARY
+
+	// Table qualifies the column in multi-table queries: the table's alias or name.
+	// Hidden columns, such as the right-hand column of a USING join, are not
+	// matched by unqualified names or "*".
+	Table  string
+	Hidden bool
+
+	// IsRowid marks the rowid pseudo-column a FROM clause adds for each table,
+	// reachable as rowid, oid or _rowid_ unless a real column has that name
+	IsRowid bool
+
+	// encoding is the encoding the table stores TEXT in, which orders BINARY
+	// comparisons of the column
+	encoding TextEncoding
 }
 
 // Row represents a database row
 type Row struct {
 	Values []Value
+	Rowid  int64 // rowid of the table row the values were read from
+}
+
+// IndexKeyColumn is one column of an index key
+type IndexKeyColumn struct {
+	Name      string // empty for an expression
+	Desc      bool
+	Collation string       // explicit or inherited from the table column, empty for BINARY
+	encoding  TextEncoding // encoding the index stores TEXT in
+}
+
+// collation returns the collating sequence of the key column; an unknown name
+// falls back to BINARY
+func (k IndexKeyColumn) collation() Collation {
+	collation, err := lookupStoredCollation(k.Collation, k.encoding)
+	if err != nil {
+		return k.encoding.binaryCollation()
+	}
+	return collation
 }
 
 // IndexEntry represents an entry in an index
 type IndexEntry struct {
 	Keys  []Value // The indexed column values
 	Rowid int64   // The rowid of the indexed row
+	Row   *Row    // The row itself, for the primary key of a WITHOUT ROWID table
 }
 
 // Get returns the value for a specific column
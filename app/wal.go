@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// WAL magic numbers; the low bit says whether checksums read words big-endian
+const (
+	walMagicLittleEndian = 0x377f0682
+	walMagicBigEndian    = 0x377f0683
+)
+
+// WAL header and frame header sizes in bytes
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// WALHeader is the 32-byte header of a write-ahead log file
+type WALHeader struct {
+	Magic         uint32 // Offset 0: walMagicLittleEndian or walMagicBigEndian
+	Version       uint32 // Offset 4: File format version, 3007000
+	PageSize      uint32 // Offset 8: Database page size
+	CheckpointSeq uint32 // Offset 12: Checkpoint sequence number
+	Salt1         uint32 // Offset 16: Salt copied into each frame, changed at each checkpoint
+	Salt2         uint32 // Offset 20: A second, random salt
+	Checksum1     uint32 // Offset 24: Checksum of the first 24 bytes
+	Checksum2     uint32 // Offset 28
+}
+
+// WALFrame is a frame of the log: a new image of one database page
+type WALFrame struct {
+	PageNumber   uint32 // page the frame holds
+	DatabaseSize uint32 // size of the database in pages after a commit frame; 0 for others
+	offset       int64  // offset of the frame header in the file
+}
+
+// WALSnapshot is a committed transaction in the log, after which the database
+// can be read as it was then
+type WALSnapshot struct {
+	Frame        int  // number of the commit frame, counting from 1
+	DatabaseSize int  // size of the database in pages after the commit
+	Pages        int  // frames written by the transaction
+	Current      bool // readers see the database as of this commit
+	Available    bool // no checkpoint has overwritten pages of the database file it needs
+}
+
+// WAL is the write-ahead log of a database in WAL mode, opened read-only. Only
+// frames whose salts match the header and whose cumulative checksums hold are
+// valid, and of those only the frames up to the last commit frame are used: the
+// frames of a transaction still being written are ignored, as SQLite does.
+// Readers see the last commit unless UseSnapshot picks an earlier one.
+type WAL struct {
+	file       *os.File
+	header     WALHeader
+	frames     []WALFrame  // valid frames, in log order
+	committed  int         // number of frames belonging to committed transactions
+	backfilled int         // number of frames a checkpoint has copied into the database file
+	snapshot   int         // number of frames readers see
+	pages      map[int]int // page number to index of its newest frame readers see
+}
+
+// OpenWAL opens the write-ahead log of the database at dbPath, returning nil
+// without an error when there is none. A log that is empty or has an invalid
+// header has no frames.
+func OpenWAL(dbPath string, pageSize int) (*WAL, error) {
+	file, err := os.Open(dbPath + "-wal")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	wal := &WAL{file: file}
+	if err := wal.load(pageSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	wal.readBackfill(dbPath + "-shm")
+	if err := wal.UseSnapshot(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return wal, nil
+}
+
+// load reads the header and frame headers, checking salts and checksums
+func (w *WAL) load(pageSize int) error {
+	reader := bufio.NewReaderSize(w.file, 1<<16)
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil
+	}
+	if err := binary.Read(bytes.NewReader(header), binary.BigEndian, &w.header); err != nil {
+		return fmt.Errorf("read wal header: %w", err)
+	}
+	if w.header.Magic != walMagicLittleEndian && w.header.Magic != walMagicBigEndian {
+		return nil
+	}
+	bigEndian := w.header.Magic == walMagicBigEndian
+	s0, s1 := walChecksum(header[:24], bigEndian, 0, 0)
+	if s0 != w.header.Checksum1 || s1 != w.header.Checksum2 {
+		return nil
+	}
+	if int(w.header.PageSize) != pageSize {
+		return fmt.Errorf("wal page size %d differs from database page size %d", w.header.PageSize, pageSize)
+	}
+
+	frame := make([]byte, walFrameHeaderSize+pageSize)
+	for offset := int64(walHeaderSize); ; offset += int64(len(frame)) {
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			break // a partly written frame ends the log
+		}
+		pageNumber := binary.BigEndian.Uint32(frame[0:])
+		if pageNumber == 0 || binary.BigEndian.Uint32(frame[8:]) != w.header.Salt1 || binary.BigEndian.Uint32(frame[12:]) != w.header.Salt2 {
+			break // left over from before the last checkpoint
+		}
+		s0, s1 = walChecksum(frame[:8], bigEndian, s0, s1)
+		s0, s1 = walChecksum(frame[walFrameHeaderSize:], bigEndian, s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+		w.frames = append(w.frames, WALFrame{PageNumber: pageNumber, DatabaseSize: binary.BigEndian.Uint32(frame[4:]), offset: offset})
+		if w.frames[len(w.frames)-1].DatabaseSize != 0 {
+			w.committed = len(w.frames)
+		}
+	}
+	return nil
+}
+
+// readBackfill reads how many frames a checkpoint has copied back into the
+// database file from the wal-index in the -shm file, if there is one for this
+// log. The wal-index is in the byte order of the machine that wrote it.
+func (w *WAL) readBackfill(shmPath string) {
+	file, err := os.Open(shmPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	index := make([]byte, 100)
+	if _, err := file.ReadAt(index, 0); err != nil {
+		return
+	}
+	// the wal-index header keeps the salts of its log at offset 32
+	salts := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, w.header.Salt1), w.header.Salt2)
+	if bytes.Equal(index[32:40], salts) {
+		w.backfilled = int(binary.NativeEndian.Uint32(index[96:]))
+	}
+}
+
+// Snapshots lists the committed transactions, oldest first
+func (w *WAL) Snapshots() []WALSnapshot {
+	var snapshots []WALSnapshot
+	start := 0
+	for i, frame := range w.frames[:w.committed] {
+		if frame.DatabaseSize == 0 {
+			continue
+		}
+		snapshots = append(snapshots, WALSnapshot{
+			Frame:        i + 1,
+			DatabaseSize: int(frame.DatabaseSize),
+			Pages:        i + 1 - start,
+			Current:      i+1 == w.snapshot,
+			Available:    i+1 >= w.backfilled,
+		})
+		start = i + 1
+	}
+	return snapshots
+}
+
+// UseSnapshot makes readers see the database as of the commit frame numbered
+// frame, or as of the last commit for 0. An earlier commit older than the last
+// checkpoint cannot be read: the database file no longer holds all of its pages.
+func (w *WAL) UseSnapshot(frame int) error {
+	if frame == 0 {
+		frame = w.committed
+	} else if frame < 0 || frame > w.committed || w.frames[frame-1].DatabaseSize == 0 {
+		return fmt.Errorf("frame %d is not a commit frame", frame)
+	}
+	if frame < w.backfilled && frame != w.committed {
+		return fmt.Errorf("snapshot %d predates the checkpoint of frame %d", frame, w.backfilled)
+	}
+	w.snapshot = frame
+	w.pages = make(map[int]int)
+	for i, frame := range w.frames[:frame] {
+		w.pages[int(frame.PageNumber)] = i
+	}
+	return nil
+}
+
+// walChecksum continues the checksum s0, s1 over data, read as pairs of 32-bit
+// words in the byte order of the log
+func walChecksum(data []byte, bigEndian bool, s0, s1 uint32) (uint32, uint32) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// Lookup returns the index of the newest frame readers see holding a page
+func (w *WAL) Lookup(pageNum int) (int, bool) {
+	i, ok := w.pages[pageNum]
+	return i, ok
+}
+
+// ReadFrame reads the page image of a frame into page
+func (w *WAL) ReadFrame(index int, page []byte) error {
+	frame := w.frames[index]
+	n, err := w.file.ReadAt(page, frame.offset+walFrameHeaderSize)
+	if err != nil {
+		return fmt.Errorf("read wal frame %d: %w", index, err)
+	}
+	if n != len(page) {
+		return fmt.Errorf("incomplete wal frame %d: expected %d bytes, got %d", index, len(page), n)
+	}
+	return nil
+}
+
+// DatabaseSize returns the size of the database in pages as of the commit
+// readers see, or 0 when the log holds no committed transaction
+func (w *WAL) DatabaseSize() int {
+	if w.snapshot == 0 {
+		return 0
+	}
+	return int(w.frames[w.snapshot-1].DatabaseSize)
+}
+
+// Close closes the log file
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
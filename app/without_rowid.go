@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// NewWithoutRowidTable creates a logical WITHOUT ROWID table. Its rows are stored
+// in an index B-tree ordered by the primary key, as records holding the primary
+// key columns first and then the other columns in declared order. The primary key
+// doubles as the table's index for key lookups.
+func NewWithoutRowidTable(dbRaw DatabaseRaw, schema *SchemaRecord, primaryKey []IndexKeyColumn, encoding TextEncoding) *TableImpl {
+	t := NewTable(nil, schema, encoding)
+
+	// a schema that does not parse fails again when the table is read
+	columns, _ := t.GetSchema(context.Background())
+	for i, key := range primaryKey {
+		primaryKey[i].encoding = encoding
+		for _, col := range columns {
+			if !strings.EqualFold(col.Name, key.Name) {
+				continue
+			}
+			if key.Collation == "" {
+				primaryKey[i].Collation = col.Collation
+			}
+			t.storage = append(t.storage, col.Index)
+		}
+	}
+	for _, col := range columns {
+		if !slices.Contains(t.storage, col.Index) {
+			t.storage = append(t.storage, col.Index)
+		}
+	}
+
+	t.tableRaw = NewWithoutRowidTableRaw(dbRaw, schema.Name, int(schema.RootPage), primaryKey)
+	t.primaryKey = &primaryKeyIndex{table: t, keys: primaryKey}
+	t.indexes = []Index{t.primaryKey}
+	return t
+}
+
+// WithoutRowid reports whether the table is a WITHOUT ROWID table
+func (t *TableImpl) WithoutRowid() bool {
+	return t.primaryKey != nil
+}
+
+// withoutRowidRow builds a row of a WITHOUT ROWID table from its record. Columns
+// added after the row was written are missing from the record and read as NULL.
+func (t *TableImpl) withoutRowidRow(cell Cell, columns []Column) *Row {
+	values := make([]Value, len(columns))
+	for i := range values {
+		values[i] = NewNullValue()
+	}
+	for i, value := range cell.Record.ColumnValues() {
+		if i < len(t.storage) {
+			column := t.storage[i]
+			values[column] = columnValue(value, columns[column])
+		}
+	}
+	return &Row{Values: values}
+}
+
+// primaryKeyIndex is the primary key of a WITHOUT ROWID table. It shares the
+// table's B-tree, so its entries carry their whole rows and need no lookup. Like
+// SQLite's sqlite_stat1, it goes by the name of its table.
+type primaryKeyIndex struct {
+	table *TableImpl
+	keys  []IndexKeyColumn
+}
+
+// GetSchema returns the primary key columns of the table
+func (pk *primaryKeyIndex) GetSchema(ctx context.Context) ([]Column, error) {
+	columns := make([]Column, len(pk.keys))
+	for i, key := range pk.keys {
+		columns[i] = Column{
+			Name:      key.Name,
+			Type:      "TEXT",
+			Index:     i,
+			Collation: key.Collation,
+			encoding:  key.encoding,
+		}
+	}
+	return columns, nil
+}
+
+// Count returns the number of rows in the table
+func (pk *primaryKeyIndex) Count(ctx context.Context) (int, error) {
+	return pk.table.Count(ctx)
+}
+
+// GetName returns the name of the table
+func (pk *primaryKeyIndex) GetName() string {
+	return pk.table.GetName()
+}
+
+// GetTableName returns the name of the table
+func (pk *primaryKeyIndex) GetTableName() string {
+	return pk.table.GetName()
+}
+
+// SearchByKey returns the rows whose primary key starts with key
+func (pk *primaryKeyIndex) SearchByKey(ctx context.Context, key interface{}) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	for entry, err := range pk.ScanFrom(ctx, key) {
+		if err != nil {
+			return nil, err
+		}
+		if compareIndexKeys(pk.keys, entry.Keys, indexKeyOf(key)) != 0 {
+			break
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// Scan streams the rows in primary key order
+func (pk *primaryKeyIndex) Scan(ctx context.Context) iter.Seq2[*IndexEntry, error] {
+	return pk.ScanFrom(ctx, nil)
+}
+
+// ScanFrom streams the rows in primary key order, starting at the first row whose
+// key is not smaller than key, or at the first row when key is nil
+func (pk *primaryKeyIndex) ScanFrom(ctx context.Context, key interface{}) iter.Seq2[*IndexEntry, error] {
+	return func(yield func(*IndexEntry, error) bool) {
+		columns, err := pk.table.GetSchema(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		cursor := pk.table.tableRaw.Cursor(ctx)
+		if key == nil {
+			cursor.First()
+		} else {
+			cursor.Seek(key)
+		}
+		for ; cursor.Valid(); cursor.Next() {
+			cell := cursor.Cell()
+			values := cell.Record.ColumnValues()
+			entry := &IndexEntry{
+				Keys: values[:min(len(pk.keys), len(values))],
+				Row:  pk.table.withoutRowidRow(*cell, columns),
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			yield(nil, fmt.Errorf("scan primary key of %s: %w", pk.table.GetName(), err))
+		}
+	}
+}
+
+// GetKeyColumns returns the primary key columns with their sort order and collation
+func (pk *primaryKeyIndex) GetKeyColumns() []IndexKeyColumn {
+	return pk.keys
+}
+
+// IsPartial reports false: the primary key holds every row
+func (pk *primaryKeyIndex) IsPartial() bool {
+	return false
+}
+
+// IsUnique reports true: no two rows share a primary key
+func (pk *primaryKeyIndex) IsUnique() bool {
+	return true
+}